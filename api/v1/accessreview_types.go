@@ -0,0 +1,225 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AccessReviewDecisionType is whether a reviewer attests that a member still needs access.
+// +kubebuilder:validation:Enum=Keep;Revoke
+type AccessReviewDecisionType string
+
+const (
+	// AccessReviewDecisionKeep attests that the member still needs access.
+	AccessReviewDecisionKeep AccessReviewDecisionType = "Keep"
+	// AccessReviewDecisionRevoke removes the member from the OktaGroup when the review completes.
+	AccessReviewDecisionRevoke AccessReviewDecisionType = "Revoke"
+)
+
+// AccessReviewUnattestedAction is what happens to the members without a decision
+// when the deadline of the review passes.
+// +kubebuilder:validation:Enum=Report;Remove
+type AccessReviewUnattestedAction string
+
+const (
+	// AccessReviewUnattestedActionReport only lists the unattested members in the report.
+	AccessReviewUnattestedActionReport AccessReviewUnattestedAction = "Report"
+	// AccessReviewUnattestedActionRemove removes the unattested members from their OktaGroups.
+	AccessReviewUnattestedActionRemove AccessReviewUnattestedAction = "Remove"
+)
+
+// AccessReviewPhase is where an AccessReview is in its lifecycle.
+type AccessReviewPhase string
+
+const (
+	// AccessReviewPhaseInProgress means that the review collects decisions until its deadline.
+	AccessReviewPhaseInProgress AccessReviewPhase = "InProgress"
+	// AccessReviewPhaseCompleted means that the deadline passed, the decisions were
+	// applied and the report was written.
+	AccessReviewPhaseCompleted AccessReviewPhase = "Completed"
+)
+
+// AccessReviewReportKeys are the keys of the ConfigMap that holds the report of an
+// AccessReview. The signatures are base64 encoded Ed25519 signatures of the reports.
+const (
+	AccessReviewReportJSONKey          = "report.json"
+	AccessReviewReportCSVKey           = "report.csv"
+	AccessReviewReportJSONSignatureKey = "report.json.sig"
+	AccessReviewReportCSVSignatureKey  = "report.csv.sig"
+)
+
+// AccessReviewDecision is the decision of a reviewer on one member of an OktaGroup.
+type AccessReviewDecision struct {
+	// OktaGroup is the name of the reviewed OktaGroup.
+	OktaGroup string `json:"oktaGroup"`
+
+	// Member is the reviewed member, as listed in status.items.
+	Member string `json:"member"`
+
+	// Decision is whether the member keeps its access.
+	Decision AccessReviewDecisionType `json:"decision"`
+
+	// Comment is an optional note of the reviewer.
+	// +optional
+	Comment string `json:"comment,omitempty"`
+
+	// Reviewer is the Kubernetes user that made the decision. It is set by the
+	// mutating webhook, the decisions are ignored while it is disabled. It must be
+	// one of the reviewers of the AccessReview.
+	// +optional
+	Reviewer string `json:"reviewer,omitempty"`
+}
+
+// AccessReviewSpec defines the desired state of AccessReview
+type AccessReviewSpec struct {
+	// OktaGroupSelector selects the OktaGroups whose members are reviewed.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="oktaGroupSelector is immutable"
+	OktaGroupSelector metav1.LabelSelector `json:"oktaGroupSelector"`
+
+	// Reviewers are the Kubernetes users, usually emails, allowed to decide. A
+	// reviewer can't decide on its own access, matched by the email and login of
+	// the Okta user of the member.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="reviewers is immutable"
+	Reviewers []string `json:"reviewers"`
+
+	// Deadline is when the review completes.
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="deadline is immutable"
+	Deadline metav1.Time `json:"deadline"`
+
+	// UnattestedAction is what happens to the members without a decision once the
+	// deadline passes. Revoked members are always removed.
+	// +kubebuilder:default=Report
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="unattestedAction is immutable"
+	// +optional
+	UnattestedAction AccessReviewUnattestedAction `json:"unattestedAction,omitempty"`
+
+	// Decisions are the decisions of the reviewers.
+	// +listType=map
+	// +listMapKey=oktaGroup
+	// +listMapKey=member
+	// +optional
+	Decisions []AccessReviewDecision `json:"decisions,omitempty"`
+}
+
+// AccessReviewItem is one reviewed member and its outcome.
+type AccessReviewItem struct {
+	// OktaGroup is the name of the OktaGroup of the member.
+	OktaGroup string `json:"oktaGroup"`
+
+	// Member is the member of the spec of the OktaGroup when the review started.
+	Member string `json:"member"`
+
+	// UserID, Email and Login identify the Okta user the member resolved to when
+	// the review started, so that a reviewer can't decide on their own access
+	// listed under another identifier.
+	// +optional
+	UserID string `json:"userId,omitempty"`
+	// +optional
+	Email string `json:"email,omitempty"`
+	// +optional
+	Login string `json:"login,omitempty"`
+
+	// Decision is the decision of a reviewer, empty while the member is unattested.
+	// +optional
+	Decision AccessReviewDecisionType `json:"decision,omitempty"`
+
+	// Reviewer is the reviewer that made the decision.
+	// +optional
+	Reviewer string `json:"reviewer,omitempty"`
+
+	// Comment is the comment of the reviewer.
+	// +optional
+	Comment string `json:"comment,omitempty"`
+
+	// Removed is true if the member was removed from the OktaGroup when the review completed.
+	// +optional
+	Removed bool `json:"removed,omitempty"`
+}
+
+// AccessReviewStatus defines the observed state of AccessReview
+type AccessReviewStatus struct {
+	// Phase is where the review is in its lifecycle.
+	// +optional
+	Phase AccessReviewPhase `json:"phase,omitempty"`
+
+	// StartedAt is when the members of the OktaGroups were snapshotted.
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// CompletedAt is when the decisions were applied and the report was written.
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+
+	// Items are the members of the selected OktaGroups when the review started,
+	// with their decisions.
+	// +optional
+	Items []AccessReviewItem `json:"items,omitempty"`
+
+	// Kept, Revoked and Unattested count the items by decision.
+	// +optional
+	Kept int `json:"kept"`
+	// +optional
+	Revoked int `json:"revoked"`
+	// +optional
+	Unattested int `json:"unattested"`
+
+	// ReportRef is the name of the ConfigMap, in the namespace of the review, that
+	// holds the JSON and CSV reports and their signatures.
+	// +optional
+	ReportRef string `json:"reportRef,omitempty"`
+
+	// Message lists the ignored decisions and the revoked members that couldn't be
+	// removed, or why the review couldn't complete.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Deadline",type=date,JSONPath=`.spec.deadline`
+//+kubebuilder:printcolumn:name="Kept",type=integer,JSONPath=`.status.kept`
+//+kubebuilder:printcolumn:name="Revoked",type=integer,JSONPath=`.status.revoked`
+//+kubebuilder:printcolumn:name="Unattested",type=integer,JSONPath=`.status.unattested`
+//+kubebuilder:printcolumn:name="Report",type=string,JSONPath=`.status.reportRef`
+
+// AccessReview is the Schema for the accessreviews API. It is a recertification
+// campaign: the reviewers attest whether the members of the selected OktaGroups
+// still need access until the deadline, when the revoked members are removed and
+// a signed report is written.
+type AccessReview struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AccessReviewSpec   `json:"spec,omitempty"`
+	Status AccessReviewStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AccessReviewList contains a list of AccessReview
+type AccessReviewList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AccessReview `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AccessReview{}, &AccessReviewList{})
+}
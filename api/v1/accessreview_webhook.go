@@ -0,0 +1,78 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var accessreviewlog = logf.Log.WithName("accessreview-resource")
+
+// SetupWebhookWithManager registers the defaulting webhook of AccessReview.
+func (d *AccessReviewDefaulter) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&AccessReview{}).
+		WithDefaulter(d).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-access-manager-github-com-v1-accessreview,mutating=true,failurePolicy=fail,sideEffects=None,groups=access-manager.github.com,resources=accessreviews,verbs=create;update,versions=v1,name=maccessreview.kb.io,admissionReviewVersions=v1
+
+// AccessReviewDefaulter records who made the decisions of the AccessReviews, so
+// that the reviewers can't be forged.
+// +kubebuilder:object:generate=false
+type AccessReviewDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &AccessReviewDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *AccessReviewDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	accessReview := obj.(*AccessReview)
+	accessreviewlog.Info("default", "namespace", accessReview.Namespace, "name", accessReview.Name)
+
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to identify the reviewer: %w", err)
+	}
+	oldAccessReview := &AccessReview{}
+	if len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, oldAccessReview); err != nil {
+			return fmt.Errorf("unable to decode the previous AccessReview: %w", err)
+		}
+	}
+
+	// Only the decisions added or changed by this request are attributed to its user
+	previous := map[[2]string]AccessReviewDecision{}
+	for _, decision := range oldAccessReview.Spec.Decisions {
+		previous[[2]string{decision.OktaGroup, decision.Member}] = decision
+	}
+	for i, decision := range accessReview.Spec.Decisions {
+		if decision != previous[[2]string{decision.OktaGroup, decision.Member}] {
+			accessReview.Spec.Decisions[i].Reviewer = req.UserInfo.Username
+		}
+	}
+	return nil
+}
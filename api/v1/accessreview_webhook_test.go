@@ -0,0 +1,40 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestAccessReviewDefaulter_RecordsReviewers(t *testing.T) {
+	oldAccessReview := &AccessReview{Spec: AccessReviewSpec{Decisions: []AccessReviewDecision{
+		{OktaGroup: "admins", Member: "john@example.com", Decision: AccessReviewDecisionKeep, Reviewer: "alice@example.com"},
+		{OktaGroup: "admins", Member: "jane@example.com", Decision: AccessReviewDecisionKeep, Reviewer: "alice@example.com"},
+	}}}
+	raw, err := json.Marshal(oldAccessReview)
+	assert.NoError(t, err)
+
+	// bob keeps alice's decision on john, changes the one on jane and forges a new one
+	accessReview := &AccessReview{Spec: AccessReviewSpec{Decisions: []AccessReviewDecision{
+		{OktaGroup: "admins", Member: "john@example.com", Decision: AccessReviewDecisionKeep, Reviewer: "alice@example.com"},
+		{OktaGroup: "admins", Member: "jane@example.com", Decision: AccessReviewDecisionRevoke, Reviewer: "alice@example.com"},
+		{OktaGroup: "admins", Member: "adam@example.com", Decision: AccessReviewDecisionRevoke, Reviewer: "alice@example.com"},
+	}}}
+	ctx := admission.NewContextWithRequest(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UserInfo:  authenticationv1.UserInfo{Username: "bob@example.com"},
+		OldObject: runtime.RawExtension{Raw: raw},
+	}})
+	assert.NoError(t, (&AccessReviewDefaulter{}).Default(ctx, accessReview))
+
+	var reviewers []string
+	for _, decision := range accessReview.Spec.Decisions {
+		reviewers = append(reviewers, decision.Reviewer)
+	}
+	assert.Equal(t, []string{"alice@example.com", "bob@example.com", "bob@example.com"}, reviewers)
+}
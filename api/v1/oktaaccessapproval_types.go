@@ -0,0 +1,85 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OktaAccessDecision is whether an OktaAccessApproval approves or denies a request.
+// +kubebuilder:validation:Enum=Approve;Deny
+type OktaAccessDecision string
+
+const (
+	// OktaAccessDecisionApprove grants the requested membership.
+	OktaAccessDecisionApprove OktaAccessDecision = "Approve"
+	// OktaAccessDecisionDeny rejects the request.
+	OktaAccessDecisionDeny OktaAccessDecision = "Deny"
+)
+
+// OktaAccessApprovalSpec defines the desired state of OktaAccessApproval
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+type OktaAccessApprovalSpec struct {
+	// AccessRequestRef is the name of the OktaAccessRequest, in the same namespace,
+	// that is approved or denied.
+	// +kubebuilder:validation:MinLength=1
+	AccessRequestRef string `json:"accessRequestRef"`
+
+	// Decision is whether the request is approved or denied.
+	// +kubebuilder:default=Approve
+	// +optional
+	Decision OktaAccessDecision `json:"decision,omitempty"`
+
+	// Comment is an optional note of the approver.
+	// +optional
+	Comment string `json:"comment,omitempty"`
+
+	// Approver is the Kubernetes user that created the approval. It is set by the
+	// mutating webhook, the approvals are ignored while it is disabled. Without the
+	// OIDC username prefix, it must be the email or login of a member of the approver
+	// group of the requested OktaGroup.
+	// +optional
+	Approver string `json:"approver,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Request",type=string,JSONPath=`.spec.accessRequestRef`
+//+kubebuilder:printcolumn:name="Decision",type=string,JSONPath=`.spec.decision`
+//+kubebuilder:printcolumn:name="Approver",type=string,JSONPath=`.spec.approver`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// OktaAccessApproval is the Schema for the oktaaccessapprovals API. It approves or
+// denies an OktaAccessRequest.
+type OktaAccessApproval struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec OktaAccessApprovalSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OktaAccessApprovalList contains a list of OktaAccessApproval
+type OktaAccessApprovalList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OktaAccessApproval `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OktaAccessApproval{}, &OktaAccessApprovalList{})
+}
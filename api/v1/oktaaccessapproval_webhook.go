@@ -0,0 +1,61 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var oktaaccessapprovallog = logf.Log.WithName("oktaaccessapproval-resource")
+
+// SetupWebhookWithManager registers the defaulting webhook of OktaAccessApproval.
+func (d *OktaAccessApprovalDefaulter) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&OktaAccessApproval{}).
+		WithDefaulter(d).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-access-manager-github-com-v1-oktaaccessapproval,mutating=true,failurePolicy=fail,sideEffects=None,groups=access-manager.github.com,resources=oktaaccessapprovals,verbs=create,versions=v1,name=moktaaccessapproval.kb.io,admissionReviewVersions=v1
+
+// OktaAccessApprovalDefaulter records who created the OktaAccessApprovals, so that
+// the approver can't be forged.
+// +kubebuilder:object:generate=false
+type OktaAccessApprovalDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &OktaAccessApprovalDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *OktaAccessApprovalDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	approval := obj.(*OktaAccessApproval)
+	oktaaccessapprovallog.Info("default", "namespace", approval.Namespace, "name", approval.Name)
+
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to identify the approver: %w", err)
+	}
+	approval.Spec.Approver = req.UserInfo.Username
+	return nil
+}
@@ -0,0 +1,22 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestOktaAccessApprovalDefaulter_RecordsApprover(t *testing.T) {
+	approval := &OktaAccessApproval{Spec: OktaAccessApprovalSpec{AccessRequestRef: "incident", Approver: "forged@example.com"}}
+	ctx := admission.NewContextWithRequest(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		UserInfo: authenticationv1.UserInfo{Username: "alice@example.com"},
+	}})
+	assert.NoError(t, (&OktaAccessApprovalDefaulter{}).Default(ctx, approval))
+	assert.Equal(t, "alice@example.com", approval.Spec.Approver)
+
+	assert.Error(t, (&OktaAccessApprovalDefaulter{}).Default(context.TODO(), approval))
+}
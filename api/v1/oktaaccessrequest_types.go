@@ -0,0 +1,114 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OktaAccessRequestState is the state of an OktaAccessRequest.
+// +kubebuilder:validation:Enum=Pending;Approved;Denied;Expired
+type OktaAccessRequestState string
+
+const (
+	// OktaAccessRequestStatePending means that the request waits for an approval.
+	OktaAccessRequestStatePending OktaAccessRequestState = "Pending"
+	// OktaAccessRequestStateApproved means that the user is a member of the Okta group
+	// until the request expires.
+	OktaAccessRequestStateApproved OktaAccessRequestState = "Approved"
+	// OktaAccessRequestStateDenied means that an approver denied the request, or that
+	// the OktaGroup has no approvers.
+	OktaAccessRequestStateDenied OktaAccessRequestState = "Denied"
+	// OktaAccessRequestStateExpired means that the membership granted by the request
+	// expired and the user was removed from the Okta group.
+	OktaAccessRequestStateExpired OktaAccessRequestState = "Expired"
+)
+
+// OktaAccessRequestSpec defines the desired state of OktaAccessRequest
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+// +kubebuilder:validation:XValidation:rule="duration(self.duration) > duration('0s')",message="duration must be positive"
+type OktaAccessRequestSpec struct {
+	// OktaGroupRef is the name of the OktaGroup the user requests to be a member of.
+	// +kubebuilder:validation:MinLength=1
+	OktaGroupRef string `json:"oktaGroupRef"`
+
+	// User is the email of the Okta user that requests the membership.
+	// +kubebuilder:validation:MinLength=1
+	User string `json:"user"`
+
+	// Justification is why the user needs the membership, for the approvers.
+	// +kubebuilder:validation:MinLength=1
+	Justification string `json:"justification"`
+
+	// Duration is how long the user stays in the Okta group once the request is approved.
+	Duration metav1.Duration `json:"duration"`
+}
+
+// OktaAccessRequestStatus defines the observed state of OktaAccessRequest
+type OktaAccessRequestStatus struct {
+	// State is where the request is in its lifecycle.
+	// +optional
+	State OktaAccessRequestState `json:"state,omitempty"`
+
+	// Message explains the state, e.g. what the request waits for.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// DecidedBy is the approver that approved or denied the request.
+	// +optional
+	DecidedBy string `json:"decidedBy,omitempty"`
+
+	// DecidedAt is when the request was approved or denied.
+	// +optional
+	DecidedAt *metav1.Time `json:"decidedAt,omitempty"`
+
+	// ExpiresAt is when the membership granted by the request expires.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Group",type=string,JSONPath=`.spec.oktaGroupRef`
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.user`
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="Expires",type=date,JSONPath=`.status.expiresAt`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// OktaAccessRequest is the Schema for the oktaaccessrequests API. It requests a
+// temporary membership in an OktaGroup, granted once a member of the approver group
+// of the OktaGroup approves it with an OktaAccessApproval.
+type OktaAccessRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OktaAccessRequestSpec   `json:"spec,omitempty"`
+	Status OktaAccessRequestStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OktaAccessRequestList contains a list of OktaAccessRequest
+type OktaAccessRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OktaAccessRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OktaAccessRequest{}, &OktaAccessRequestList{})
+}
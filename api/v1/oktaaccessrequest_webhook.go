@@ -0,0 +1,74 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"net/mail"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var oktaaccessrequestlog = logf.Log.WithName("oktaaccessrequest-resource")
+
+// SetupWebhookWithManager registers the validating webhook of OktaAccessRequest.
+func (v *OktaAccessRequestValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&OktaAccessRequest{}).
+		WithValidator(v).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-access-manager-github-com-v1-oktaaccessrequest,mutating=false,failurePolicy=fail,sideEffects=None,groups=access-manager.github.com,resources=oktaaccessrequests,verbs=create,versions=v1,name=voktaaccessrequest.kb.io,admissionReviewVersions=v1
+
+// OktaAccessRequestValidator validates the OktaAccessRequests before they are
+// stored. Their spec is immutable, so only their creation is validated.
+// +kubebuilder:object:generate=false
+type OktaAccessRequestValidator struct{}
+
+var _ webhook.CustomValidator = &OktaAccessRequestValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *OktaAccessRequestValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	accessRequest := obj.(*OktaAccessRequest)
+	oktaaccessrequestlog.Info("validate create", "namespace", accessRequest.Namespace, "name", accessRequest.Name)
+
+	// The user is granted as an email member of the OktaGroup
+	user := accessRequest.Spec.User
+	if address, err := mail.ParseAddress(user); err == nil && address.Address == user {
+		return nil, nil
+	}
+	return nil, apierrors.NewInvalid(GroupVersion.WithKind("OktaAccessRequest").GroupKind(), accessRequest.Name,
+		field.ErrorList{field.Invalid(field.NewPath("spec", "user"), user, "must be an email address")})
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *OktaAccessRequestValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *OktaAccessRequestValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
@@ -0,0 +1,27 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestOktaAccessRequestValidator_RequiresAnEmail(t *testing.T) {
+	for user, valid := range map[string]bool{
+		"john@example.com":          true,
+		"john":                      false,
+		"John <john@example.com>":   false,
+		`"john"@example.com or x@y`: false,
+	} {
+		accessRequest := &OktaAccessRequest{Spec: OktaAccessRequestSpec{OktaGroupRef: "production", User: user}}
+		_, err := (&OktaAccessRequestValidator{}).ValidateCreate(context.TODO(), accessRequest)
+		if valid {
+			assert.NoError(t, err, user)
+			continue
+		}
+		assert.True(t, apierrors.IsInvalid(err), user)
+		assert.ErrorContains(t, err, "spec.user: Invalid value", user)
+	}
+}
@@ -17,9 +17,146 @@ limitations under the License.
 package v1
 
 import (
+	"slices"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Condition types of the OktaGroup status.
+const (
+	// OktaGroupConditionReady is True when the Okta group and its members are in sync with the spec.
+	OktaGroupConditionReady = "Ready"
+	// OktaGroupConditionGroupSynced is True when the Okta group exists and its profile matches the spec.
+	OktaGroupConditionGroupSynced = "GroupSynced"
+	// OktaGroupConditionMembersSynced is True when the members of the Okta group match the spec.
+	OktaGroupConditionMembersSynced = "MembersSynced"
+	// OktaGroupConditionCredentialsValid is True when an Okta client could be built for the group's org.
+	OktaGroupConditionCredentialsValid = "CredentialsValid"
+	// OktaGroupConditionRBACSynced is True when the RoleBindings and ClusterRoleBindings
+	// of the group match its kubernetesRBAC.
+	OktaGroupConditionRBACSynced = "RBACSynced"
+)
+
+// Condition reasons of the OktaGroup status.
+const (
+	// OktaGroupReasonSynced means that the reconcile step succeeded.
+	OktaGroupReasonSynced = "Synced"
+	// OktaGroupReasonCredentialsLoaded means that the Okta client of the group's org was built.
+	OktaGroupReasonCredentialsLoaded = "CredentialsLoaded"
+	// OktaGroupReasonCredentialsInvalid means that the Okta client of the group's org couldn't be built.
+	OktaGroupReasonCredentialsInvalid = "CredentialsInvalid"
+	// OktaGroupReasonSecretNamespaceForbidden means that the Secret of the OktaOrg isn't
+	// in the namespace the operator reads the Secrets from.
+	OktaGroupReasonSecretNamespaceForbidden = "SecretNamespaceForbidden"
+	// OktaGroupReasonGroupSyncFailed means that the Okta group couldn't be created, updated or read.
+	OktaGroupReasonGroupSyncFailed = "GroupSyncFailed"
+	// OktaGroupReasonMembersSyncFailed means that the members of the Okta group couldn't be updated.
+	OktaGroupReasonMembersSyncFailed = "MembersSyncFailed"
+	// OktaGroupReasonRateLimited means that the Okta org's rate limit was exhausted, the
+	// group is synced again once it is reset.
+	OktaGroupReasonRateLimited = "RateLimited"
+	// OktaGroupReasonMembersUnresolved means that some users of the spec don't match exactly one Okta user.
+	OktaGroupReasonMembersUnresolved = "MembersUnresolved"
+	// OktaGroupReasonRBACSyncFailed means that the RoleBindings or ClusterRoleBindings
+	// of the group couldn't be updated.
+	OktaGroupReasonRBACSyncFailed = "RBACSyncFailed"
+	// OktaGroupReasonRBACUnchecked means that the roles of the kubernetesRBAC aren't
+	// bound because the webhook checking who may bind them is disabled.
+	OktaGroupReasonRBACUnchecked = "RBACUnchecked"
+	// OktaGroupReasonDryRun means that the Okta group doesn't match the spec, but the
+	// changes were only planned because the OktaGroup is synced in dry-run.
+	OktaGroupReasonDryRun = "DryRun"
+	// OktaGroupReasonCompositionCycle means that the OktaGroup includes itself
+	// through its includeGroups.
+	OktaGroupReasonCompositionCycle = "CompositionCycle"
+	// OktaGroupReasonCompositionInvalid means that an OktaGroup included or excluded by
+	// the group doesn't exist or belongs to another Okta org.
+	OktaGroupReasonCompositionInvalid = "CompositionInvalid"
+)
+
+// OktaGroupDryRunAnnotation syncs an OktaGroup in dry-run when set to "true": the
+// changes to the Okta group are computed and reported in status.plannedChanges,
+// but not applied. Set to "false", it overrides the --dry-run flag of the operator.
+const OktaGroupDryRunAnnotation = "access-manager.github.com/dry-run"
+
+// OktaGroupMemberState is the sync result of one user of an OktaGroup.
+type OktaGroupMemberState string
+
+const (
+	// OktaGroupMemberStateMember means that the user is a member of the Okta group.
+	OktaGroupMemberStateMember OktaGroupMemberState = "Member"
+	// OktaGroupMemberStateNotFound means that no Okta user has the email.
+	OktaGroupMemberStateNotFound OktaGroupMemberState = "NotFound"
+	// OktaGroupMemberStateAmbiguous means that more than one Okta user has the email.
+	OktaGroupMemberStateAmbiguous OktaGroupMemberState = "Ambiguous"
+	// OktaGroupMemberStateInactive means that the Okta user is suspended, deprovisioned
+	// or otherwise not active, so it is kept out of the group.
+	OktaGroupMemberStateInactive OktaGroupMemberState = "Inactive"
+	// OktaGroupMemberStatePending means that the Okta user is staged or provisioned but
+	// not activated yet, so it is kept out of the group until it is.
+	OktaGroupMemberStatePending OktaGroupMemberState = "Pending"
+	// OktaGroupMemberStateError means that the Okta API failed while looking up or
+	// adding the user.
+	OktaGroupMemberStateError OktaGroupMemberState = "Error"
+	// OktaGroupMemberStateExpired means that the membership of the user expired, so
+	// it is removed from the group.
+	OktaGroupMemberStateExpired OktaGroupMemberState = "Expired"
+	// OktaGroupMemberStateExcluded means that the user is a member of an excluded
+	// OktaGroup, so it is kept out of the group.
+	OktaGroupMemberStateExcluded OktaGroupMemberState = "Excluded"
+)
+
+// OktaGroupAdoptionPolicy is whether an existing Okta group with the name of the
+// OktaGroup is adopted instead of creating a new one.
+// +kubebuilder:validation:Enum=Never;IfUnmanaged;Always
+type OktaGroupAdoptionPolicy string
+
+const (
+	// OktaGroupAdoptionPolicyNever never adopts an existing Okta group, creating the
+	// group fails if one with the same name exists.
+	OktaGroupAdoptionPolicyNever OktaGroupAdoptionPolicy = "Never"
+	// OktaGroupAdoptionPolicyIfUnmanaged adopts an existing Okta group unless it is
+	// managed by another instance of the operator.
+	OktaGroupAdoptionPolicyIfUnmanaged OktaGroupAdoptionPolicy = "IfUnmanaged"
+	// OktaGroupAdoptionPolicyAlways adopts an existing Okta group, even if it is
+	// managed by another instance of the operator.
+	OktaGroupAdoptionPolicyAlways OktaGroupAdoptionPolicy = "Always"
+)
+
+// OktaGroupDeletionPolicy is what happens to the Okta group when the OktaGroup is deleted.
+// +kubebuilder:validation:Enum=Delete;Orphan;RemoveMembers
+type OktaGroupDeletionPolicy string
+
+const (
+	// OktaGroupDeletionPolicyDelete deletes the Okta group.
+	OktaGroupDeletionPolicyDelete OktaGroupDeletionPolicy = "Delete"
+	// OktaGroupDeletionPolicyOrphan leaves the Okta group and its members in Okta.
+	OktaGroupDeletionPolicyOrphan OktaGroupDeletionPolicy = "Orphan"
+	// OktaGroupDeletionPolicyRemoveMembers removes the members of the Okta group and
+	// leaves the empty group in Okta, keeping its app assignments.
+	OktaGroupDeletionPolicyRemoveMembers OktaGroupDeletionPolicy = "RemoveMembers"
+)
+
+// OktaGroupChangeAction is a change to an Okta group planned in dry-run.
+type OktaGroupChangeAction string
+
+const (
+	// OktaGroupChangeCreateGroup creates the Okta group.
+	OktaGroupChangeCreateGroup OktaGroupChangeAction = "CreateGroup"
+	// OktaGroupChangeAdoptGroup adopts an existing Okta group with the name of the OktaGroup.
+	OktaGroupChangeAdoptGroup OktaGroupChangeAction = "AdoptGroup"
+	// OktaGroupChangeUpdateGroup updates the name or description of the Okta group.
+	OktaGroupChangeUpdateGroup OktaGroupChangeAction = "UpdateGroup"
+	// OktaGroupChangeDeleteGroup deletes the Okta group.
+	OktaGroupChangeDeleteGroup OktaGroupChangeAction = "DeleteGroup"
+	// OktaGroupChangeOrphanGroup leaves the Okta group in Okta.
+	OktaGroupChangeOrphanGroup OktaGroupChangeAction = "OrphanGroup"
+	// OktaGroupChangeAddMember adds a user to the Okta group.
+	OktaGroupChangeAddMember OktaGroupChangeAction = "AddMember"
+	// OktaGroupChangeRemoveMember removes a user from the Okta group.
+	OktaGroupChangeRemoveMember OktaGroupChangeAction = "RemoveMember"
+)
+
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
@@ -28,10 +165,266 @@ type OktaGroupSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Description is the description of the Okta group
+	// OktaOrgRef is the name of the OktaOrg whose credentials are used to manage
+	// this group. When empty, the credentials are read from the operator's environment.
+	OktaOrgRef string `json:"oktaOrgRef,omitempty"`
+
+	// Description is the description of the Okta group. The operator appends a
+	// marker with its manager ID to it when it creates or adopts the group, to
+	// recognize the groups it manages.
 	Description string `json:"description,omitempty"`
-	// Users is the list of users in the Okta group
-	Users []string `json:"users"`
+	// Users is the list of the emails of the users in the Okta group. It is a
+	// shorthand for members identified by email.
+	// +optional
+	Users []string `json:"users,omitempty"`
+	// Members is the list of the users in the Okta group, identified by their Okta
+	// user ID, login or email.
+	// +optional
+	Members []OktaGroupMember `json:"members,omitempty"`
+	// IncludeGroups are the names of the OktaGroups whose members are members of this
+	// group too, including the members of the groups they include in turn. They must
+	// belong to the same Okta org.
+	// +optional
+	IncludeGroups []string `json:"includeGroups,omitempty"`
+	// ExcludeGroups are the names of the OktaGroups whose members are kept out of this
+	// group, even if they are listed in the spec or in an included group.
+	// +optional
+	ExcludeGroups []string `json:"excludeGroups,omitempty"`
+
+	// AdoptionPolicy is whether an existing Okta group with the same name is adopted
+	// when the OktaGroup isn't linked to an Okta group yet, e.g. on a fresh or
+	// restored cluster.
+	// +kubebuilder:default=Never
+	// +optional
+	AdoptionPolicy OktaGroupAdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// DeletionPolicy is what happens to the Okta group when the OktaGroup is deleted,
+	// overriding the operator's --deletion-policy flag.
+	// +optional
+	DeletionPolicy OktaGroupDeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// ResyncInterval is how often the Okta group is synced again to revert the changes
+	// made outside of the operator, overriding the operator's --resync-interval flag.
+	// A duration of 0 disables the resync of this group.
+	// +optional
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+
+	// ApproverGroupRef is the name of the OktaGroup whose members approve the
+	// OktaAccessRequests for this group. The requests for a group without approvers
+	// are denied.
+	// +optional
+	ApproverGroupRef string `json:"approverGroupRef,omitempty"`
+
+	// KubernetesRBAC lists the roles bound to the Okta group in the cluster, for the
+	// kube-apiserver that authenticates users with the Okta groups as OIDC groups claim.
+	// +optional
+	KubernetesRBAC *OktaGroupKubernetesRBAC `json:"kubernetesRBAC,omitempty"`
+}
+
+// OktaGroupKubernetesRBAC lists the roles bound to an Okta group. The operator owns
+// the RoleBindings and ClusterRoleBindings, whose subject is the group named after
+// the Okta group with the operator's --oidc-groups-prefix.
+type OktaGroupKubernetesRBAC struct {
+	// ClusterRoles are bound in the whole cluster with ClusterRoleBindings.
+	// +optional
+	ClusterRoles []string `json:"clusterRoles,omitempty"`
+
+	// Namespaces are the roles bound in each namespace with RoleBindings.
+	// +listType=map
+	// +listMapKey=namespace
+	// +optional
+	Namespaces []OktaGroupNamespaceRBAC `json:"namespaces,omitempty"`
+}
+
+// OktaGroupNamespaceRBAC lists the roles bound to an Okta group in a namespace.
+type OktaGroupNamespaceRBAC struct {
+	// Namespace is where the roles are bound.
+	// +kubebuilder:validation:MinLength=1
+	Namespace string `json:"namespace"`
+
+	// Roles are the Roles of the namespace that are bound.
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// ClusterRoles are the ClusterRoles that are bound in the namespace only.
+	// +optional
+	ClusterRoles []string `json:"clusterRoles,omitempty"`
+}
+
+// OktaGroupMember identifies an Okta user. When more than one identifier is given,
+// the most precise one is used: the ID, then the login, then the email.
+// +kubebuilder:validation:XValidation:rule="has(self.id) || has(self.login) || has(self.email)",message="one of id, login or email is required"
+// +kubebuilder:validation:XValidation:rule="!(has(self.expiresAt) && has(self.duration))",message="expiresAt and duration are mutually exclusive"
+type OktaGroupMember struct {
+	// ID is the ID of the Okta user.
+	// +optional
+	ID string `json:"id,omitempty"`
+	// Login is the login of the Okta user, unique within the Okta org.
+	// +optional
+	Login string `json:"login,omitempty"`
+	// Email is the primary email of the Okta user, which more than one user can share.
+	// +optional
+	Email string `json:"email,omitempty"`
+
+	// ExpiresAt is when the user is removed from the Okta group.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// Duration is how long the user stays in the Okta group, counted from when the
+	// user is first in the Okta group. The expiry time is recorded in its status,
+	// changing the duration restarts it.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// Identifier returns the most precise identifier of the member: "id:<ID>",
+// "login:<login>", or the email.
+func (m OktaGroupMember) Identifier() string {
+	switch {
+	case m.ID != "":
+		return "id:" + m.ID
+	case m.Login != "":
+		return "login:" + m.Login
+	default:
+		return m.Email
+	}
+}
+
+// AllMembers returns the members of the spec, including the ones listed in Users,
+// normalized and without duplicates.
+func (s *OktaGroupSpec) AllMembers() []OktaGroupMember {
+	members := make([]OktaGroupMember, 0, len(s.Users)+len(s.Members))
+	for _, email := range s.Users {
+		members = append(members, OktaGroupMember{Email: email})
+	}
+	return NormalizeMembers(append(members, s.Members...))
+}
+
+// ComposedGroups returns the names of the OktaGroups the spec includes and excludes.
+func (s *OktaGroupSpec) ComposedGroups() []string {
+	return append(slices.Clone(s.IncludeGroups), s.ExcludeGroups...)
+}
+
+// CompositionCycle returns a cycle of included OktaGroups reachable from the named
+// one, as the names of the OktaGroups along the cycle starting and ending with the
+// same one, e.g. [a b a]. It returns nil if there is none. Excluded OktaGroups don't
+// form cycles, an OktaGroup excluding itself has no members. spec returns the spec
+// of an OktaGroup, or nil if it doesn't exist.
+func CompositionCycle(name string, spec func(name string) *OktaGroupSpec) []string {
+	var path []string
+	done := map[string]bool{}
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		if i := slices.Index(path, name); i >= 0 {
+			return append(slices.Clone(path[i:]), name)
+		}
+		groupSpec := spec(name)
+		if done[name] || groupSpec == nil {
+			return nil
+		}
+		path = append(path, name)
+		for _, ref := range groupSpec.IncludeGroups {
+			if cycle := visit(ref); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		done[name] = true
+		return nil
+	}
+	return visit(name)
+}
+
+// RemoveMembers removes the users and members of the spec with the given
+// identifiers, as returned by AllMembers. It returns the number of removed entries.
+func (s *OktaGroupSpec) RemoveMembers(identifiers ...string) int {
+	matches := func(member OktaGroupMember) bool {
+		normalized := NormalizeMembers([]OktaGroupMember{member})
+		return len(normalized) > 0 && slices.Contains(identifiers, normalized[0].Identifier())
+	}
+	removed := len(s.Users) + len(s.Members)
+	s.Users = slices.DeleteFunc(s.Users, func(email string) bool {
+		return matches(OktaGroupMember{Email: email})
+	})
+	s.Members = slices.DeleteFunc(s.Members, matches)
+	return removed - len(s.Users) - len(s.Members)
+}
+
+// OktaGroupDrift describes the changes made to the Okta group outside of the
+// operator, found and reverted by the last reconcile. In dry-run they are only
+// found, and their revert is in the planned changes.
+type OktaGroupDrift struct {
+	// AddedUsers are the emails of the users that were added to the Okta group
+	// outside of the operator, and removed by it.
+	// +optional
+	AddedUsers []string `json:"addedUsers,omitempty"`
+	// RemovedUsers are the identifiers of the members that were removed from the Okta group
+	// outside of the operator, and added back by it.
+	// +optional
+	RemovedUsers []string `json:"removedUsers,omitempty"`
+	// Profile is true if the name or description of the Okta group were changed
+	// outside of the operator, and restored by it.
+	// +optional
+	Profile bool `json:"profile,omitempty"`
+	// DetectedAt is when the drift was found.
+	DetectedAt metav1.Time `json:"detectedAt"`
+}
+
+// OktaGroupMemberStatus is the sync result of one user of an OktaGroup.
+type OktaGroupMemberStatus struct {
+	// Member is the identifier of the member in the spec, as returned by
+	// OktaGroupMember.Identifier: the email of the members listed in Users.
+	Member string `json:"member"`
+	// Email is the email of the Okta user, or of the member if it isn't resolved.
+	// +optional
+	Email string `json:"email,omitempty"`
+	// Login is the login of the Okta user, or of the member if it isn't resolved.
+	// +optional
+	Login string `json:"login,omitempty"`
+	// IncludedFrom are the names of the included OktaGroups that the member comes
+	// from, empty if the member is only listed in the spec.
+	// +optional
+	IncludedFrom []string `json:"includedFrom,omitempty"`
+	// State is the sync result of the user.
+	State OktaGroupMemberState `json:"state"`
+	// UserID is the ID of the Okta user the member resolved to.
+	// +optional
+	UserID string `json:"userId,omitempty"`
+	// Message explains the state when the user isn't a member.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// ExpiresAt is when the membership of the user expires.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// Duration is the duration of the member in the spec that ExpiresAt was
+	// computed from, to restart it when the duration changes.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// LastTransitionTime is the last time the state of the user changed.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+// OktaGroupPlannedChange is a change to the Okta group that a sync in dry-run
+// computed but didn't apply.
+type OktaGroupPlannedChange struct {
+	// Action is the change.
+	Action OktaGroupChangeAction `json:"action"`
+	// Member is the identifier of the member of the spec, or the email of the Okta
+	// user, that is added or removed.
+	// +optional
+	Member string `json:"member,omitempty"`
+	// UserID is the ID of the Okta user that is added or removed.
+	// +optional
+	UserID string `json:"userId,omitempty"`
+	// Message describes the change.
+	Message string `json:"message"`
+}
+
+// OktaGroupMemberExpiration is the upcoming expiry of the membership of a user.
+type OktaGroupMemberExpiration struct {
+	// Member is the identifier of the member in the spec.
+	Member string `json:"member"`
+	// ExpiresAt is when the user is removed from the Okta group.
+	ExpiresAt metav1.Time `json:"expiresAt"`
 }
 
 // OktaGroupStatus defines the observed state of OktaGroup
@@ -49,11 +442,57 @@ type OktaGroupStatus struct {
 	LastMembershipUpdated metav1.Time `json:"lastMembershipUpdated,omitempty"`
 	// LastUpdated is the time when the Okta group was last updated.
 	LastUpdated metav1.Time `json:"lastUpdated,omitempty"`
+	// AdoptedAt is the time when an existing Okta group was adopted.
+	// +optional
+	AdoptedAt *metav1.Time `json:"adoptedAt,omitempty"`
+
+	// Members is the sync result of every user of the spec.
+	// +listType=map
+	// +listMapKey=member
+	// +optional
+	Members []OktaGroupMemberStatus `json:"members,omitempty"`
+	// SyncedMembers is the number of users of the spec that are members of the Okta group.
+	// +optional
+	SyncedMembers int `json:"syncedMembers"`
+	// UnresolvedMembers is the number of users of the spec that aren't members of the
+	// Okta group, except the ones whose membership expired.
+	// +optional
+	UnresolvedMembers int `json:"unresolvedMembers"`
+	// UpcomingExpirations lists the memberships that expire, soonest first.
+	// +optional
+	UpcomingExpirations []OktaGroupMemberExpiration `json:"upcomingExpirations,omitempty"`
+
+	// Drift describes the changes made outside of the operator that the last
+	// reconcile found and reverted, or only found in dry-run. It is empty if there
+	// were none.
+	// +optional
+	Drift *OktaGroupDrift `json:"drift,omitempty"`
+
+	// PlannedChanges are the changes to the Okta group that the last reconcile
+	// would have made, when the OktaGroup is synced in dry-run.
+	// +optional
+	PlannedChanges []OktaGroupPlannedChange `json:"plannedChanges,omitempty"`
+
+	// ObservedGeneration is the generation of the OktaGroup that was last reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the state of the Okta group: Ready, GroupSynced,
+	// MembersSynced and CredentialsValid.
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Synced",type=integer,JSONPath=`.status.syncedMembers`
+//+kubebuilder:printcolumn:name="Unresolved",type=integer,JSONPath=`.status.unresolvedMembers`
+//+kubebuilder:printcolumn:name="Okta ID",type=string,JSONPath=`.status.id`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // OktaGroup is the Schema for the oktagroups API
 type OktaGroup struct {
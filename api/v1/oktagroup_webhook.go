@@ -0,0 +1,448 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation/path"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// OktaGroupDescriptionMaxLength is the maximum length of the description of an
+	// Okta group, leaving room for the marker of the operator.
+	OktaGroupDescriptionMaxLength = 1024 - 128
+)
+
+// reservedOktaGroupNames are the names of the built-in Okta groups.
+var reservedOktaGroupNames = []string{"Everyone", "Okta Administrators"}
+
+// log is for logging in this package.
+var oktagrouplog = logf.Log.WithName("oktagroup-resource")
+
+// OktaGroupValidator validates the OktaGroups before they are stored, so that
+// malformed specs are rejected instead of failing at reconcile time.
+// +kubebuilder:object:generate=false
+type OktaGroupValidator struct {
+	// Client lists the OktaGroups to find the cycles of the composed groups.
+	Client client.Reader
+	// MaxRemovalRatio is the share of the users of an OktaGroup an update can drop
+	// without a warning. A ratio of 1 disables the warning.
+	MaxRemovalRatio float64
+	// Authorizer creates the SubjectAccessReviews checking that the user creating or
+	// updating an OktaGroup may bind the roles of its kubernetesRBAC, since the
+	// operator binds them on the user's behalf. Without it the roles aren't checked.
+	Authorizer client.Writer
+}
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks of OktaGroup.
+func (v *OktaGroupValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&OktaGroup{}).
+		WithDefaulter(&OktaGroupDefaulter{}).
+		WithValidator(v).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-access-manager-github-com-v1-oktagroup,mutating=true,failurePolicy=fail,sideEffects=None,groups=access-manager.github.com,resources=oktagroups,verbs=create;update,versions=v1,name=moktagroup.kb.io,admissionReviewVersions=v1
+
+// OktaGroupDefaulter normalizes the users of the OktaGroups before they are
+// validated and stored.
+// +kubebuilder:object:generate=false
+type OktaGroupDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &OktaGroupDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *OktaGroupDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	oktaGroup := obj.(*OktaGroup)
+	oktagrouplog.Info("default", "name", oktaGroup.Name)
+
+	oktaGroup.Spec.Users = NormalizeUsers(oktaGroup.Spec.Users)
+	oktaGroup.Spec.Members = NormalizeMembers(oktaGroup.Spec.Members)
+	return nil
+}
+
+// NormalizeUsers returns the emails of users lowercased and trimmed, without empty
+// entries and duplicates, and sorted. Okta compares emails regardless of case.
+func NormalizeUsers(users []string) []string {
+	if users == nil {
+		return nil
+	}
+	normalized := make([]string, 0, len(users))
+	for _, user := range users {
+		if user = strings.ToLower(strings.TrimSpace(user)); user != "" {
+			normalized = append(normalized, user)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// NormalizeMembers returns the members with their identifiers trimmed and their
+// emails and logins lowercased, without the ones that have no identifier and the
+// duplicates, and sorted by identifier. Okta compares logins regardless of case too.
+func NormalizeMembers(members []OktaGroupMember) []OktaGroupMember {
+	if members == nil {
+		return nil
+	}
+	normalized := make([]OktaGroupMember, 0, len(members))
+	for _, member := range members {
+		member.ID = strings.TrimSpace(member.ID)
+		member.Login = strings.ToLower(strings.TrimSpace(member.Login))
+		member.Email = strings.ToLower(strings.TrimSpace(member.Email))
+		if member.Identifier() != "" {
+			normalized = append(normalized, member)
+		}
+	}
+	slices.SortStableFunc(normalized, func(a, b OktaGroupMember) int {
+		return strings.Compare(a.Identifier(), b.Identifier())
+	})
+	return slices.CompactFunc(normalized, func(a, b OktaGroupMember) bool {
+		return a.Identifier() == b.Identifier()
+	})
+}
+
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+//+kubebuilder:webhook:path=/validate-access-manager-github-com-v1-oktagroup,mutating=false,failurePolicy=fail,sideEffects=None,groups=access-manager.github.com,resources=oktagroups,verbs=create;update,versions=v1,name=voktagroup.kb.io,admissionReviewVersions=v1
+
+var _ webhook.CustomValidator = &OktaGroupValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *OktaGroupValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	oktaGroup := obj.(*OktaGroup)
+	oktagrouplog.Info("validate create", "name", oktaGroup.Name)
+
+	return nil, v.validate(ctx, nil, oktaGroup)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *OktaGroupValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldOktaGroup, oktaGroup := oldObj.(*OktaGroup), newObj.(*OktaGroup)
+	oktagrouplog.Info("validate update", "name", oktaGroup.Name)
+
+	// Only the spec is validated, so that the finalizer of an OktaGroup stored before
+	// the webhook can still be removed
+	if equality.Semantic.DeepEqual(oldOktaGroup.Spec, oktaGroup.Spec) {
+		return nil, nil
+	}
+	if err := v.validate(ctx, oldOktaGroup, oktaGroup); err != nil {
+		return nil, err
+	}
+	return v.removalWarnings(oldOktaGroup, oktaGroup), nil
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *OktaGroupValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate validates an OktaGroup, oldOktaGroup is the stored one on update and nil
+// on create.
+func (v *OktaGroupValidator) validate(ctx context.Context, oldOktaGroup, oktaGroup *OktaGroup) error {
+	var errs field.ErrorList
+	namePath := field.NewPath("metadata", "name")
+	specPath := field.NewPath("spec")
+
+	for _, reserved := range reservedOktaGroupNames {
+		if strings.EqualFold(oktaGroup.Name, reserved) {
+			errs = append(errs, field.Forbidden(namePath, fmt.Sprintf("%q is the name of a built-in Okta group", reserved)))
+		}
+	}
+	if len(oktaGroup.Spec.Description) > OktaGroupDescriptionMaxLength {
+		errs = append(errs, field.TooLong(specPath.Child("description"), oktaGroup.Spec.Description, OktaGroupDescriptionMaxLength))
+	}
+	if len(oktaGroup.Spec.Users) == 0 && len(oktaGroup.Spec.Members) == 0 && len(oktaGroup.Spec.IncludeGroups) == 0 {
+		errs = append(errs, field.Required(specPath.Child("users"), "at least one user, member or included group is required"))
+	}
+	errs = append(errs, validateUsers(oktaGroup.Spec.Users, specPath.Child("users"))...)
+	errs = append(errs, validateMembers(oktaGroup.Spec.Members, specPath.Child("members"))...)
+	errs = append(errs, validateGroupRefs(oktaGroup.Spec.IncludeGroups, specPath.Child("includeGroups"))...)
+	errs = append(errs, validateGroupRefs(oktaGroup.Spec.ExcludeGroups, specPath.Child("excludeGroups"))...)
+	for i, name := range oktaGroup.Spec.ExcludeGroups {
+		if slices.Contains(oktaGroup.Spec.IncludeGroups, name) {
+			errs = append(errs, field.Forbidden(specPath.Child("excludeGroups").Index(i), fmt.Sprintf("%s is also an included group", name)))
+		}
+	}
+	errs = append(errs, validateKubernetesRBAC(oktaGroup.Spec.KubernetesRBAC, specPath.Child("kubernetesRBAC"))...)
+	var oldRBAC *OktaGroupKubernetesRBAC
+	if oldOktaGroup != nil {
+		oldRBAC = oldOktaGroup.Spec.KubernetesRBAC
+	}
+	unbindable, err := v.unbindableRoles(ctx, oldRBAC, oktaGroup.Spec.KubernetesRBAC, specPath.Child("kubernetesRBAC"))
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	errs = append(errs, unbindable...)
+
+	cycle, err := v.compositionCycle(ctx, oktaGroup)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if cycle != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("includeGroups"),
+			fmt.Sprintf("the included groups form a cycle: %s", strings.Join(cycle, " -> "))))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("OktaGroup").GroupKind(), oktaGroup.Name, errs)
+}
+
+func validateUsers(users []string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	seen := map[string]bool{}
+	for i, user := range users {
+		if address, err := mail.ParseAddress(user); err != nil || address.Address != user {
+			errs = append(errs, field.Invalid(path.Index(i), user, "must be an email address"))
+			continue
+		}
+		// Okta compares emails regardless of case
+		if seen[strings.ToLower(user)] {
+			errs = append(errs, field.Duplicate(path.Index(i), user))
+		}
+		seen[strings.ToLower(user)] = true
+	}
+	return errs
+}
+
+func validateMembers(members []OktaGroupMember, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	seen := map[string]bool{}
+	for i, member := range members {
+		if member.Identifier() == "" {
+			errs = append(errs, field.Required(path.Index(i), "one of id, login or email is required"))
+			continue
+		}
+		if address, err := mail.ParseAddress(member.Email); member.Email != "" && (err != nil || address.Address != member.Email) {
+			errs = append(errs, field.Invalid(path.Index(i).Child("email"), member.Email, "must be an email address"))
+			continue
+		}
+		if member.ExpiresAt != nil && member.Duration != nil {
+			errs = append(errs, field.Forbidden(path.Index(i).Child("duration"), "expiresAt and duration are mutually exclusive"))
+		}
+		if member.Duration != nil && member.Duration.Duration <= 0 {
+			errs = append(errs, field.Invalid(path.Index(i).Child("duration"), member.Duration.Duration.String(), "must be positive"))
+		}
+		identifier := NormalizeMembers([]OktaGroupMember{member})[0].Identifier()
+		if seen[identifier] {
+			errs = append(errs, field.Duplicate(path.Index(i), identifier))
+		}
+		seen[identifier] = true
+	}
+	return errs
+}
+
+func validateGroupRefs(names []string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	seen := map[string]bool{}
+	for i, name := range names {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			errs = append(errs, field.Invalid(path.Index(i), name, msg))
+		}
+		if seen[name] {
+			errs = append(errs, field.Duplicate(path.Index(i), name))
+		}
+		seen[name] = true
+	}
+	return errs
+}
+
+func validateKubernetesRBAC(rbac *OktaGroupKubernetesRBAC, path *field.Path) field.ErrorList {
+	if rbac == nil {
+		return nil
+	}
+	errs := validateRoleNames(rbac.ClusterRoles, path.Child("clusterRoles"))
+	for i, namespace := range rbac.Namespaces {
+		namespacePath := path.Child("namespaces").Index(i)
+		for _, msg := range validation.IsDNS1123Label(namespace.Namespace) {
+			errs = append(errs, field.Invalid(namespacePath.Child("namespace"), namespace.Namespace, msg))
+		}
+		errs = append(errs, validateRoleNames(namespace.Roles, namespacePath.Child("roles"))...)
+		errs = append(errs, validateRoleNames(namespace.ClusterRoles, namespacePath.Child("clusterRoles"))...)
+	}
+	return errs
+}
+
+func validateRoleNames(names []string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	seen := map[string]bool{}
+	for i, name := range names {
+		if name == "" {
+			errs = append(errs, field.Required(path.Index(i), "the name of the role is required"))
+			continue
+		}
+		for _, msg := range apivalidation.IsValidPathSegmentName(name) {
+			errs = append(errs, field.Invalid(path.Index(i), name, msg))
+		}
+		if seen[name] {
+			errs = append(errs, field.Duplicate(path.Index(i), name))
+		}
+		seen[name] = true
+	}
+	return errs
+}
+
+// rbacRole is a role bound by the kubernetesRBAC of an OktaGroup, in a namespace or
+// cluster-wide, with its path in the spec.
+type rbacRole struct {
+	path                  *field.Path
+	namespace, kind, name string
+}
+
+// binding identifies the binding of the role, regardless of its path.
+func (r rbacRole) binding() string {
+	return r.namespace + "/" + r.kind + "/" + r.name
+}
+
+// rbacRoles returns the roles bound by a kubernetesRBAC.
+func rbacRoles(rbac *OktaGroupKubernetesRBAC, path *field.Path) []rbacRole {
+	if rbac == nil {
+		return nil
+	}
+	var roles []rbacRole
+	for i, clusterRole := range rbac.ClusterRoles {
+		roles = append(roles, rbacRole{path: path.Child("clusterRoles").Index(i), kind: "ClusterRole", name: clusterRole})
+	}
+	for i, namespace := range rbac.Namespaces {
+		namespacePath := path.Child("namespaces").Index(i)
+		for j, role := range namespace.Roles {
+			roles = append(roles, rbacRole{path: namespacePath.Child("roles").Index(j), namespace: namespace.Namespace, kind: "Role", name: role})
+		}
+		for j, clusterRole := range namespace.ClusterRoles {
+			roles = append(roles, rbacRole{path: namespacePath.Child("clusterRoles").Index(j), namespace: namespace.Namespace, kind: "ClusterRole", name: clusterRole})
+		}
+	}
+	return roles
+}
+
+// unbindableRoles returns the errors of the roles of the kubernetesRBAC that the user
+// making the request may not bind. The operator may bind any role, so without this
+// check whoever can edit OktaGroups could grant any role in the cluster. The roles
+// already bound by oldRBAC aren't checked again, so that whoever may only edit the
+// members of an OktaGroup still can.
+func (v *OktaGroupValidator) unbindableRoles(ctx context.Context, oldRBAC, rbac *OktaGroupKubernetesRBAC, path *field.Path) (field.ErrorList, error) {
+	if v.Authorizer == nil {
+		return nil, nil
+	}
+	bound := map[string]bool{}
+	for _, role := range rbacRoles(oldRBAC, path) {
+		bound[role.binding()] = true
+	}
+	roles := slices.DeleteFunc(rbacRoles(rbac, path), func(role rbacRole) bool {
+		return bound[role.binding()]
+	})
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to identify the user binding the roles: %w", err)
+	}
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range req.UserInfo.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+
+	var errs field.ErrorList
+	for _, role := range roles {
+		review := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   req.UserInfo.Username,
+			UID:    req.UserInfo.UID,
+			Groups: req.UserInfo.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: role.namespace,
+				Verb:      "bind",
+				Group:     rbacv1.GroupName,
+				Resource:  strings.ToLower(role.kind) + "s",
+				Name:      role.name,
+			},
+		}}
+		if err := v.Authorizer.Create(ctx, review); err != nil {
+			return nil, fmt.Errorf("unable to check if %q may bind %s %s: %w", req.UserInfo.Username, role.kind, role.name, err)
+		}
+		if !review.Status.Allowed {
+			errs = append(errs, field.Forbidden(role.path, fmt.Sprintf("user %q may not bind %s %s", req.UserInfo.Username, role.kind, role.name)))
+		}
+	}
+	return errs, nil
+}
+
+// compositionCycle returns the cycle that the included groups of the OktaGroup would
+// form with the stored OktaGroups, if any. The OktaGroups it refers to don't need to
+// exist yet.
+func (v *OktaGroupValidator) compositionCycle(ctx context.Context, oktaGroup *OktaGroup) ([]string, error) {
+	if v.Client == nil || len(oktaGroup.Spec.IncludeGroups) == 0 {
+		return nil, nil
+	}
+	oktaGroups := &OktaGroupList{}
+	if err := v.Client.List(ctx, oktaGroups); err != nil {
+		return nil, err
+	}
+	specs := map[string]*OktaGroupSpec{oktaGroup.Name: &oktaGroup.Spec}
+	for i := range oktaGroups.Items {
+		if other := &oktaGroups.Items[i]; other.Name != oktaGroup.Name {
+			specs[other.Name] = &other.Spec
+		}
+	}
+	return CompositionCycle(oktaGroup.Name, func(name string) *OktaGroupSpec { return specs[name] }), nil
+}
+
+// removalWarnings warns when an update drops more than MaxRemovalRatio of the members,
+// which is more likely a mistake than an intended change.
+func (v *OktaGroupValidator) removalWarnings(oldOktaGroup, oktaGroup *OktaGroup) admission.Warnings {
+	oldMembers := oldOktaGroup.Spec.AllMembers()
+	if len(oldMembers) == 0 {
+		return nil
+	}
+	kept := map[string]bool{}
+	for _, member := range oktaGroup.Spec.AllMembers() {
+		kept[member.Identifier()] = true
+	}
+	var removed int
+	for _, member := range oldMembers {
+		if !kept[member.Identifier()] {
+			removed++
+		}
+	}
+
+	ratio := float64(removed) / float64(len(oldMembers))
+	if ratio <= v.MaxRemovalRatio {
+		return nil
+	}
+	return admission.Warnings{fmt.Sprintf("the spec drops %d of the %d users (%.0f%%), they will be removed from Okta group %s",
+		removed, len(oldMembers), ratio*100, oktaGroup.Name)}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newTestOktaGroupValidator(objects ...*OktaGroup) *OktaGroupValidator {
+	scheme := runtime.NewScheme()
+	AddToScheme(scheme)
+
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, obj := range objects {
+		builder = builder.WithObjects(obj)
+	}
+	return &OktaGroupValidator{Client: builder.Build(), MaxRemovalRatio: 0.5}
+}
+
+func newTestOktaGroup(namespace, name string, users ...string) *OktaGroup {
+	return &OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       OktaGroupSpec{Users: users},
+	}
+}
+
+func TestOktaGroupValidator_ValidateCreate(t *testing.T) {
+	validator := newTestOktaGroupValidator(
+		newTestOktaGroup("team-a", "developers", "john@example.com"),
+	)
+
+	for name, tc := range map[string]struct {
+		oktaGroup *OktaGroup
+		invalid   []string
+	}{
+		"valid": {
+			oktaGroup: newTestOktaGroup("team-a", "admins", "john@example.com", "jane@example.com"),
+		},
+		"invalid emails": {
+			oktaGroup: newTestOktaGroup("team-a", "admins", "john", "John Doe <john@example.com>"),
+			invalid:   []string{"spec.users[0]", "spec.users[1]"},
+		},
+		"duplicate users": {
+			oktaGroup: newTestOktaGroup("team-a", "admins", "john@example.com", "John@Example.com"),
+			invalid:   []string{"spec.users[1]"},
+		},
+		"no users": {
+			oktaGroup: newTestOktaGroup("team-a", "admins"),
+			invalid:   []string{"spec.users"},
+		},
+		"valid members": {
+			oktaGroup: &OktaGroup{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "admins"},
+				Spec: OktaGroupSpec{Members: []OktaGroupMember{
+					{ID: "00u1"}, {Login: "svc-ci"}, {Login: "svc-deploy", Email: "robots@example.com"}, {Email: "robots@example.com"},
+				}},
+			},
+		},
+		"invalid members": {
+			oktaGroup: &OktaGroup{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "admins"},
+				Spec: OktaGroupSpec{Members: []OktaGroupMember{
+					{}, {Email: "robots"}, {Login: "svc-ci"}, {Login: "SVC-CI", Email: "robots@example.com"},
+				}},
+			},
+			invalid: []string{"spec.members[0]", "spec.members[1].email", "spec.members[3]"},
+		},
+		"invalid expirations": {
+			oktaGroup: &OktaGroup{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "admins"},
+				Spec: OktaGroupSpec{Members: []OktaGroupMember{
+					{Email: "john@example.com", ExpiresAt: &metav1.Time{}, Duration: &metav1.Duration{Duration: time.Hour}},
+					{Email: "jane@example.com", Duration: &metav1.Duration{}},
+					{Email: "adam@example.com", Duration: &metav1.Duration{Duration: time.Hour}},
+				}},
+			},
+			invalid: []string{"spec.members[0].duration", "spec.members[1].duration"},
+		},
+		"invalid kubernetesRBAC": {
+			oktaGroup: &OktaGroup{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "admins"},
+				Spec: OktaGroupSpec{Users: []string{"john@example.com"}, KubernetesRBAC: &OktaGroupKubernetesRBAC{
+					ClusterRoles: []string{"view", "view"},
+					Namespaces: []OktaGroupNamespaceRBAC{
+						{Namespace: "team-a", Roles: []string{"deployer"}, ClusterRoles: []string{"edit"}},
+						{Namespace: "Team_B", Roles: []string{"a/b"}},
+					},
+				}},
+			},
+			invalid: []string{"spec.kubernetesRBAC.clusterRoles[1]", "spec.kubernetesRBAC.namespaces[1].namespace",
+				"spec.kubernetesRBAC.namespaces[1].roles[0]"},
+		},
+		"reserved name": {
+			oktaGroup: newTestOktaGroup("team-a", "everyone", "john@example.com"),
+			invalid:   []string{"metadata.name"},
+		},
+		"long description": {
+			oktaGroup: &OktaGroup{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "admins"},
+				Spec:       OktaGroupSpec{Description: strings.Repeat("a", 1000), Users: []string{"john@example.com"}},
+			},
+			invalid: []string{"spec.description"},
+		},
+		"valid composition": {
+			oktaGroup: &OktaGroup{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "platform-all"},
+				Spec:       OktaGroupSpec{IncludeGroups: []string{"developers", "platform-sre"}, ExcludeGroups: []string{"contractors"}},
+			},
+		},
+		"invalid composition": {
+			oktaGroup: &OktaGroup{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "platform-all"},
+				Spec: OktaGroupSpec{
+					IncludeGroups: []string{"Platform_SRE", "developers", "developers"},
+					ExcludeGroups: []string{"developers"},
+				},
+			},
+			invalid: []string{"spec.includeGroups[0]", "spec.includeGroups[2]", "spec.excludeGroups[0]"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := validator.ValidateCreate(context.TODO(), tc.oktaGroup)
+			if len(tc.invalid) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, apierrors.IsInvalid(err), err)
+			var fields []string
+			for _, cause := range err.(*apierrors.StatusError).ErrStatus.Details.Causes {
+				fields = append(fields, cause.Field)
+			}
+			assert.Equal(t, tc.invalid, fields)
+		})
+	}
+}
+
+func TestOktaGroupValidator_ValidateUpdate(t *testing.T) {
+	oldOktaGroup := newTestOktaGroup("team-a", "developers", "a@example.com", "b@example.com", "c@example.com", "d@example.com")
+	validator := newTestOktaGroupValidator(oldOktaGroup)
+
+	oktaGroup := newTestOktaGroup("team-a", "developers", "a@example.com", "b@example.com", "e@example.com")
+	warnings, err := validator.ValidateUpdate(context.TODO(), oldOktaGroup, oktaGroup)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	oktaGroup = newTestOktaGroup("team-a", "developers", "a@example.com")
+	warnings, err = validator.ValidateUpdate(context.TODO(), oldOktaGroup, oktaGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"the spec drops 3 of the 4 users (75%), they will be removed from Okta group developers"}, []string(warnings))
+
+	// Updates that don't change the spec, such as removing the finalizer, are always allowed
+	invalid := newTestOktaGroup("team-a", "developers")
+	_, err = validator.ValidateUpdate(context.TODO(), invalid, invalid.DeepCopy())
+	assert.NoError(t, err)
+}
+
+func TestOktaGroupValidator_RejectsCompositionCycles(t *testing.T) {
+	platformAll := newTestOktaGroup("", "platform-all")
+	platformAll.Spec.IncludeGroups = []string{"platform-sre", "platform-dev"}
+	platformDev := newTestOktaGroup("", "platform-dev", "john@example.com")
+	platformDev.Spec.ExcludeGroups = []string{"contractors"}
+	validator := newTestOktaGroupValidator(platformAll, platformDev)
+
+	for name, tc := range map[string]struct {
+		spec  OktaGroupSpec
+		cycle string
+	}{
+		"no cycle":  {spec: OktaGroupSpec{IncludeGroups: []string{"platform-dev"}}},
+		"itself":    {spec: OktaGroupSpec{IncludeGroups: []string{"platform-sre"}}, cycle: "platform-sre -> platform-sre"},
+		"included":  {spec: OktaGroupSpec{IncludeGroups: []string{"platform-all"}}, cycle: "platform-sre -> platform-all -> platform-sre"},
+		"excluded":  {spec: OktaGroupSpec{Users: []string{"jane@example.com"}, ExcludeGroups: []string{"platform-all"}}},
+		"unrelated": {spec: OktaGroupSpec{IncludeGroups: []string{"contractors"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			platformSRE := &OktaGroup{ObjectMeta: metav1.ObjectMeta{Name: "platform-sre"}, Spec: tc.spec}
+			_, err := validator.ValidateCreate(context.TODO(), platformSRE)
+			if tc.cycle == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, apierrors.IsInvalid(err), err)
+			assert.ErrorContains(t, err, "the included groups form a cycle: "+tc.cycle)
+		})
+	}
+}
+
+func TestOktaGroupValidator_ChecksTheUserMayBindTheRoles(t *testing.T) {
+	scheme := runtime.NewScheme()
+	authorizationv1.AddToScheme(scheme)
+	var reviews []authorizationv1.ResourceAttributes
+	validator := newTestOktaGroupValidator()
+	validator.Authorizer = fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			review := obj.(*authorizationv1.SubjectAccessReview)
+			reviews = append(reviews, *review.Spec.ResourceAttributes)
+			review.Status.Allowed = review.Spec.User == "admin" || review.Spec.ResourceAttributes.Name != "cluster-admin"
+			return nil
+		},
+	}).Build()
+	asUser := func(username string) context.Context {
+		return admission.NewContextWithRequest(context.TODO(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			UserInfo: authenticationv1.UserInfo{Username: username},
+		}})
+	}
+
+	oktaGroup := newTestOktaGroup("", "developers", "john@example.com")
+	oktaGroup.Spec.KubernetesRBAC = &OktaGroupKubernetesRBAC{
+		ClusterRoles: []string{"cluster-admin"},
+		Namespaces:   []OktaGroupNamespaceRBAC{{Namespace: "team-a", Roles: []string{"edit"}}},
+	}
+	_, err := validator.ValidateCreate(asUser("admin"), oktaGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, []authorizationv1.ResourceAttributes{
+		{Verb: "bind", Group: "rbac.authorization.k8s.io", Resource: "clusterroles", Name: "cluster-admin"},
+		{Namespace: "team-a", Verb: "bind", Group: "rbac.authorization.k8s.io", Resource: "roles", Name: "edit"},
+	}, reviews)
+
+	_, err = validator.ValidateCreate(asUser("john"), oktaGroup)
+	assert.True(t, apierrors.IsInvalid(err), err)
+	assert.ErrorContains(t, err, `spec.kubernetesRBAC.clusterRoles[0]: Forbidden: user "john" may not bind ClusterRole cluster-admin`)
+	assert.NotContains(t, err.Error(), "Role edit")
+
+	// The roles can't be checked without knowing who binds them
+	_, err = validator.ValidateCreate(context.TODO(), oktaGroup)
+	assert.True(t, apierrors.IsInternalError(err), err)
+
+	// On update only the roles that weren't bound yet are checked, so the user may
+	// still change the members
+	reviews = nil
+	updated := oktaGroup.DeepCopy()
+	updated.Spec.Users = append(updated.Spec.Users, "jane@example.com")
+	_, err = validator.ValidateUpdate(asUser("john"), oktaGroup, updated)
+	assert.NoError(t, err)
+	assert.Empty(t, reviews)
+
+	updated.Spec.KubernetesRBAC.Namespaces[0].Namespace = "team-b"
+	_, err = validator.ValidateUpdate(asUser("john"), oktaGroup, updated)
+	assert.NoError(t, err)
+	assert.Equal(t, []authorizationv1.ResourceAttributes{
+		{Namespace: "team-b", Verb: "bind", Group: "rbac.authorization.k8s.io", Resource: "roles", Name: "edit"},
+	}, reviews)
+}
+
+func TestOktaGroupDefaulter_NormalizesUsers(t *testing.T) {
+	oktaGroup := newTestOktaGroup("team-a", "developers", " Jane@Corp.com", "john@corp.com", "jane@corp.com", "", "Adam@corp.com ")
+	assert.NoError(t, (&OktaGroupDefaulter{}).Default(context.TODO(), oktaGroup))
+	assert.Equal(t, []string{"adam@corp.com", "jane@corp.com", "john@corp.com"}, oktaGroup.Spec.Users)
+
+	assert.Nil(t, NormalizeUsers(nil))
+}
+
+func TestOktaGroupSpec_AllMembers(t *testing.T) {
+	spec := OktaGroupSpec{
+		Users: []string{"Jane@Corp.com", "john@corp.com"},
+		Members: []OktaGroupMember{
+			{ID: "00u1", Login: "ignored", Email: "ignored@corp.com"},
+			{Login: "SVC-CI", Email: "robots@corp.com"},
+			{Email: "jane@corp.com"},
+		},
+	}
+	var identifiers []string
+	for _, member := range spec.AllMembers() {
+		identifiers = append(identifiers, member.Identifier())
+	}
+	assert.Equal(t, []string{"id:00u1", "jane@corp.com", "john@corp.com", "login:svc-ci"}, identifiers)
+}
+
+func TestOktaGroupSpec_RemoveMembers(t *testing.T) {
+	spec := OktaGroupSpec{
+		Users:   []string{"Jane@Corp.com", "john@corp.com"},
+		Members: []OktaGroupMember{{Login: "SVC-CI"}, {Email: "jane@corp.com"}, {ID: "00u1"}},
+	}
+	assert.Equal(t, 3, spec.RemoveMembers("jane@corp.com", "login:svc-ci"))
+	assert.Equal(t, []string{"john@corp.com"}, spec.Users)
+	assert.Equal(t, []OktaGroupMember{{ID: "00u1"}}, spec.Members)
+}
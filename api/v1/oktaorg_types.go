@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// OktaOrgSecretOrgURLKey is the key of the referenced Secret that holds the Okta org URL.
+	OktaOrgSecretOrgURLKey = "client-org-url"
+	// OktaOrgSecretTokenKey is the key of the referenced Secret that holds the Okta API token.
+	OktaOrgSecretTokenKey = "client-token"
+	// OktaOrgSecretPrivateKeyKey is the key of the referenced Secret that holds the PEM encoded
+	// RSA private key (PKCS #1) of the Okta service app.
+	OktaOrgSecretPrivateKeyKey = "client-private-key"
+	// OktaOrgSecretPrivateKeyIDKey is the optional key of the referenced Secret that holds the
+	// ID of the service app's public key, sent as the "kid" of the client assertion.
+	OktaOrgSecretPrivateKeyIDKey = "client-private-key-id"
+)
+
+// OktaAuthorizationMode is how the operator authenticates to an Okta org.
+// +kubebuilder:validation:Enum=SSWS;PrivateKey
+type OktaAuthorizationMode string
+
+const (
+	// OktaAuthorizationModeSSWS authenticates with a long-lived SSWS API token.
+	OktaAuthorizationModeSSWS OktaAuthorizationMode = "SSWS"
+	// OktaAuthorizationModePrivateKey authenticates as an OAuth 2.0 service app, signing
+	// its client assertions with a private key (private_key_jwt).
+	OktaAuthorizationModePrivateKey OktaAuthorizationMode = "PrivateKey"
+)
+
+// RequiredOktaScopes are the OAuth 2.0 scopes the operator needs to manage groups
+// and their members with the PrivateKey authorization mode.
+var RequiredOktaScopes = []string{"okta.groups.manage", "okta.users.read"}
+
+// SecretReference identifies a Secret by name and namespace.
+type SecretReference struct {
+	// Name is the name of the Secret.
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the Secret. It must be the namespace the operator
+	// reads the Secrets from, its own unless set by --okta-secret-namespace.
+	Namespace string `json:"namespace"`
+}
+
+// OktaOrgSpec defines the desired state of OktaOrg
+type OktaOrgSpec struct {
+	// SecretRef references the Secret that holds the credentials of the Okta org.
+	// The Secret must contain the org URL under the "client-org-url" key. With the
+	// SSWS authorization mode it must contain the API token under the "client-token"
+	// key, and with the PrivateKey mode the PEM encoded RSA private key under the
+	// "client-private-key" key and, optionally, its key ID under "client-private-key-id".
+	SecretRef SecretReference `json:"secretRef"`
+
+	// AuthorizationMode is how the operator authenticates to the Okta org.
+	// +kubebuilder:default=SSWS
+	// +optional
+	AuthorizationMode OktaAuthorizationMode `json:"authorizationMode,omitempty"`
+
+	// ClientID is the client ID of the Okta service app. Required by the PrivateKey
+	// authorization mode.
+	// +optional
+	ClientID string `json:"clientId,omitempty"`
+
+	// Scopes are the OAuth 2.0 scopes requested by the PrivateKey authorization mode.
+	// They must include okta.groups.manage and okta.users.read.
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// OktaOrgStatus defines the observed state of OktaOrg
+type OktaOrgStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// OktaOrg is the Schema for the oktaorgs API
+type OktaOrg struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OktaOrgSpec   `json:"spec,omitempty"`
+	Status OktaOrgStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OktaOrgList contains a list of OktaOrg
+type OktaOrgList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OktaOrg `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OktaOrg{}, &OktaOrgList{})
+}
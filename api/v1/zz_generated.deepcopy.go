@@ -21,9 +21,325 @@ limitations under the License.
 package v1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessReview) DeepCopyInto(out *AccessReview) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessReview.
+func (in *AccessReview) DeepCopy() *AccessReview {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessReview)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AccessReview) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessReviewDecision) DeepCopyInto(out *AccessReviewDecision) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessReviewDecision.
+func (in *AccessReviewDecision) DeepCopy() *AccessReviewDecision {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessReviewDecision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessReviewItem) DeepCopyInto(out *AccessReviewItem) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessReviewItem.
+func (in *AccessReviewItem) DeepCopy() *AccessReviewItem {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessReviewItem)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessReviewList) DeepCopyInto(out *AccessReviewList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AccessReview, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessReviewList.
+func (in *AccessReviewList) DeepCopy() *AccessReviewList {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessReviewList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AccessReviewList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessReviewSpec) DeepCopyInto(out *AccessReviewSpec) {
+	*out = *in
+	in.OktaGroupSelector.DeepCopyInto(&out.OktaGroupSelector)
+	if in.Reviewers != nil {
+		in, out := &in.Reviewers, &out.Reviewers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.Deadline.DeepCopyInto(&out.Deadline)
+	if in.Decisions != nil {
+		in, out := &in.Decisions, &out.Decisions
+		*out = make([]AccessReviewDecision, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessReviewSpec.
+func (in *AccessReviewSpec) DeepCopy() *AccessReviewSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessReviewSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AccessReviewStatus) DeepCopyInto(out *AccessReviewStatus) {
+	*out = *in
+	if in.StartedAt != nil {
+		in, out := &in.StartedAt, &out.StartedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletedAt != nil {
+		in, out := &in.CompletedAt, &out.CompletedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AccessReviewItem, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AccessReviewStatus.
+func (in *AccessReviewStatus) DeepCopy() *AccessReviewStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AccessReviewStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaAccessApproval) DeepCopyInto(out *OktaAccessApproval) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaAccessApproval.
+func (in *OktaAccessApproval) DeepCopy() *OktaAccessApproval {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaAccessApproval)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OktaAccessApproval) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaAccessApprovalList) DeepCopyInto(out *OktaAccessApprovalList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]OktaAccessApproval, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaAccessApprovalList.
+func (in *OktaAccessApprovalList) DeepCopy() *OktaAccessApprovalList {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaAccessApprovalList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OktaAccessApprovalList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaAccessApprovalSpec) DeepCopyInto(out *OktaAccessApprovalSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaAccessApprovalSpec.
+func (in *OktaAccessApprovalSpec) DeepCopy() *OktaAccessApprovalSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaAccessApprovalSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaAccessRequest) DeepCopyInto(out *OktaAccessRequest) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaAccessRequest.
+func (in *OktaAccessRequest) DeepCopy() *OktaAccessRequest {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaAccessRequest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OktaAccessRequest) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaAccessRequestList) DeepCopyInto(out *OktaAccessRequestList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]OktaAccessRequest, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaAccessRequestList.
+func (in *OktaAccessRequestList) DeepCopy() *OktaAccessRequestList {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaAccessRequestList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OktaAccessRequestList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaAccessRequestSpec) DeepCopyInto(out *OktaAccessRequestSpec) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaAccessRequestSpec.
+func (in *OktaAccessRequestSpec) DeepCopy() *OktaAccessRequestSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaAccessRequestSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaAccessRequestStatus) DeepCopyInto(out *OktaAccessRequestStatus) {
+	*out = *in
+	if in.DecidedAt != nil {
+		in, out := &in.DecidedAt, &out.DecidedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaAccessRequestStatus.
+func (in *OktaAccessRequestStatus) DeepCopy() *OktaAccessRequestStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaAccessRequestStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OktaGroup) DeepCopyInto(out *OktaGroup) {
 	*out = *in
@@ -51,6 +367,59 @@ func (in *OktaGroup) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaGroupDrift) DeepCopyInto(out *OktaGroupDrift) {
+	*out = *in
+	if in.AddedUsers != nil {
+		in, out := &in.AddedUsers, &out.AddedUsers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RemovedUsers != nil {
+		in, out := &in.RemovedUsers, &out.RemovedUsers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.DetectedAt.DeepCopyInto(&out.DetectedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupDrift.
+func (in *OktaGroupDrift) DeepCopy() *OktaGroupDrift {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaGroupDrift)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaGroupKubernetesRBAC) DeepCopyInto(out *OktaGroupKubernetesRBAC) {
+	*out = *in
+	if in.ClusterRoles != nil {
+		in, out := &in.ClusterRoles, &out.ClusterRoles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]OktaGroupNamespaceRBAC, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupKubernetesRBAC.
+func (in *OktaGroupKubernetesRBAC) DeepCopy() *OktaGroupKubernetesRBAC {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaGroupKubernetesRBAC)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OktaGroupList) DeepCopyInto(out *OktaGroupList) {
 	*out = *in
@@ -83,6 +452,116 @@ func (in *OktaGroupList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaGroupMember) DeepCopyInto(out *OktaGroupMember) {
+	*out = *in
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupMember.
+func (in *OktaGroupMember) DeepCopy() *OktaGroupMember {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaGroupMember)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaGroupMemberExpiration) DeepCopyInto(out *OktaGroupMemberExpiration) {
+	*out = *in
+	in.ExpiresAt.DeepCopyInto(&out.ExpiresAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupMemberExpiration.
+func (in *OktaGroupMemberExpiration) DeepCopy() *OktaGroupMemberExpiration {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaGroupMemberExpiration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaGroupMemberStatus) DeepCopyInto(out *OktaGroupMemberStatus) {
+	*out = *in
+	if in.IncludedFrom != nil {
+		in, out := &in.IncludedFrom, &out.IncludedFrom
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupMemberStatus.
+func (in *OktaGroupMemberStatus) DeepCopy() *OktaGroupMemberStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaGroupMemberStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaGroupNamespaceRBAC) DeepCopyInto(out *OktaGroupNamespaceRBAC) {
+	*out = *in
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterRoles != nil {
+		in, out := &in.ClusterRoles, &out.ClusterRoles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupNamespaceRBAC.
+func (in *OktaGroupNamespaceRBAC) DeepCopy() *OktaGroupNamespaceRBAC {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaGroupNamespaceRBAC)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaGroupPlannedChange) DeepCopyInto(out *OktaGroupPlannedChange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupPlannedChange.
+func (in *OktaGroupPlannedChange) DeepCopy() *OktaGroupPlannedChange {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaGroupPlannedChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OktaGroupSpec) DeepCopyInto(out *OktaGroupSpec) {
 	*out = *in
@@ -91,6 +570,33 @@ func (in *OktaGroupSpec) DeepCopyInto(out *OktaGroupSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]OktaGroupMember, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IncludeGroups != nil {
+		in, out := &in.IncludeGroups, &out.IncludeGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeGroups != nil {
+		in, out := &in.ExcludeGroups, &out.ExcludeGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ResyncInterval != nil {
+		in, out := &in.ResyncInterval, &out.ResyncInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.KubernetesRBAC != nil {
+		in, out := &in.KubernetesRBAC, &out.KubernetesRBAC
+		*out = new(OktaGroupKubernetesRBAC)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupSpec.
@@ -109,6 +615,41 @@ func (in *OktaGroupStatus) DeepCopyInto(out *OktaGroupStatus) {
 	in.Created.DeepCopyInto(&out.Created)
 	in.LastMembershipUpdated.DeepCopyInto(&out.LastMembershipUpdated)
 	in.LastUpdated.DeepCopyInto(&out.LastUpdated)
+	if in.AdoptedAt != nil {
+		in, out := &in.AdoptedAt, &out.AdoptedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]OktaGroupMemberStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.UpcomingExpirations != nil {
+		in, out := &in.UpcomingExpirations, &out.UpcomingExpirations
+		*out = make([]OktaGroupMemberExpiration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Drift != nil {
+		in, out := &in.Drift, &out.Drift
+		*out = new(OktaGroupDrift)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PlannedChanges != nil {
+		in, out := &in.PlannedChanges, &out.PlannedChanges
+		*out = make([]OktaGroupPlannedChange, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaGroupStatus.
@@ -120,3 +661,113 @@ func (in *OktaGroupStatus) DeepCopy() *OktaGroupStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaOrg) DeepCopyInto(out *OktaOrg) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaOrg.
+func (in *OktaOrg) DeepCopy() *OktaOrg {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaOrg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OktaOrg) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaOrgList) DeepCopyInto(out *OktaOrgList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]OktaOrg, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaOrgList.
+func (in *OktaOrgList) DeepCopy() *OktaOrgList {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaOrgList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *OktaOrgList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaOrgSpec) DeepCopyInto(out *OktaOrgSpec) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	if in.Scopes != nil {
+		in, out := &in.Scopes, &out.Scopes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaOrgSpec.
+func (in *OktaOrgSpec) DeepCopy() *OktaOrgSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaOrgSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OktaOrgStatus) DeepCopyInto(out *OktaOrgStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OktaOrgStatus.
+func (in *OktaOrgStatus) DeepCopy() *OktaOrgStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(OktaOrgStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretReference) DeepCopyInto(out *SecretReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretReference.
+func (in *SecretReference) DeepCopy() *SecretReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretReference)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -17,17 +17,25 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"go.opentelemetry.io/otel"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -53,8 +61,68 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var oktaPageSize int64
+	var oktaSecretNamespace string
+	var oktaUserCacheTTL time.Duration
+	var resyncInterval time.Duration
+	var managerID string
+	var oidcGroupsPrefix string
+	var oidcUsernamePrefix string
+	var deletionPolicy string
+	var maxRemovalRatio float64
+	var eventHookAddr string
+	var reportSigningKeyPath string
+	var eventHookAuthHeader string
+	var dryRun bool
+	var tracingEndpoint string
+	var tracingInsecure bool
+	var tracingSamplingRatio float64
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.Int64Var(&oktaPageSize, "okta-page-size", 200,
+		"The number of items requested per page when listing Okta users and groups. 0 uses Okta's default.")
+	flag.StringVar(&oktaSecretNamespace, "okta-secret-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the Secrets of the OktaOrgs, only the Secrets of this namespace are read. "+
+			"Defaults to the namespace of the operator, from the POD_NAMESPACE environment variable.")
+	flag.DurationVar(&oktaUserCacheTTL, "okta-user-cache-ttl", 5*time.Minute,
+		"How long the Okta user of an email is cached. 0 disables the cache.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 10*time.Minute,
+		"How often every OktaGroup is synced again to revert the changes made outside of the operator. "+
+			"0 disables the resync, it can be overridden by the resyncInterval of each OktaGroup.")
+	flag.StringVar(&managerID, "manager-id", controller.DefaultManagerID,
+		"The ID written in the description of the Okta groups managed by this instance of the operator, "+
+			"to tell them apart from the groups of other instances when adopting existing groups.")
+	flag.StringVar(&oidcGroupsPrefix, "oidc-groups-prefix", "",
+		"The prefix of the Okta group names in the subjects of the RoleBindings of the OktaGroups, "+
+			"the same as the --oidc-groups-prefix of the kube-apiserver.")
+	flag.StringVar(&oidcUsernamePrefix, "oidc-username-prefix", "",
+		"The prefix of the Kubernetes usernames of the Okta users, the same as the --oidc-username-prefix of the "+
+			"kube-apiserver. It is stripped from the approvers of the OktaAccessApprovals and the reviewers of the "+
+			"AccessReviews before they are compared with the emails and logins of the Okta users.")
+	flag.StringVar(&deletionPolicy, "deletion-policy", string(accessmanagerv1.OktaGroupDeletionPolicyDelete),
+		"What happens to the Okta group of a deleted OktaGroup: Delete, Orphan or RemoveMembers. "+
+			"It can be overridden by the deletionPolicy of each OktaGroup.")
+	flag.Float64Var(&maxRemovalRatio, "webhook-max-removal-ratio", 0.5,
+		"The share of the users of an OktaGroup an update can drop before the validating webhook warns about it. "+
+			"1 disables the warning.")
+	flag.StringVar(&eventHookAddr, "event-hook-bind-address", "0",
+		"The address the Okta event hook endpoint binds to. Use 0 to disable it. "+
+			"The shared secret of the event hooks is read from the OKTA_EVENT_HOOK_SECRET environment variable.")
+	flag.StringVar(&eventHookAuthHeader, "event-hook-auth-header", "Authorization",
+		"The header the Okta event hooks send the shared secret in.")
+	flag.StringVar(&reportSigningKeyPath, "access-review-signing-key", "",
+		"The path of the PEM encoded Ed25519 private key (PKCS #8) that signs the reports of the AccessReviews. "+
+			"The reports are unsigned without it.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Compute the changes to the Okta groups without making them, reporting them in the status and events "+
+			"of the OktaGroups. It can be overridden by the access-manager.github.com/dry-run annotation of each OktaGroup.")
+	flag.StringVar(&tracingEndpoint, "tracing-endpoint", "",
+		"The host and port of the OTLP/HTTP endpoint, such as otel-collector:4318, that the traces of the reconciles "+
+			"and of the Okta API calls are exported to. Tracing is disabled without it.")
+	flag.BoolVar(&tracingInsecure, "tracing-insecure", false,
+		"Export the traces over HTTP instead of HTTPS.")
+	flag.Float64Var(&tracingSamplingRatio, "tracing-sampling-ratio", 1,
+		"The fraction of the reconciles that are traced, between 0 and 1.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -66,8 +134,45 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	switch accessmanagerv1.OktaGroupDeletionPolicy(deletionPolicy) {
+	case accessmanagerv1.OktaGroupDeletionPolicyDelete, accessmanagerv1.OktaGroupDeletionPolicyOrphan,
+		accessmanagerv1.OktaGroupDeletionPolicyRemoveMembers:
+	default:
+		setupLog.Error(nil, "invalid --deletion-policy, expected Delete, Orphan or RemoveMembers", "deletionPolicy", deletionPolicy)
+		os.Exit(1)
+	}
+
+	shutdownTracing := func() {}
+	if tracingEndpoint != "" {
+		tracerProvider, err := controller.NewTracerProvider(context.Background(), tracingEndpoint, tracingInsecure, tracingSamplingRatio)
+		if err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		otel.SetTracerProvider(tracerProvider)
+		// Flush the spans of the last reconciles before exiting
+		shutdownTracing = func() {
+			if err := tracerProvider.Shutdown(context.Background()); err != nil {
+				setupLog.Error(err, "unable to flush the traces")
+			}
+		}
+	}
+
+	if oktaSecretNamespace == "" {
+		setupLog.Error(nil, "--okta-secret-namespace is required when the POD_NAMESPACE environment variable isn't set")
+		os.Exit(1)
+	}
+
+	// Only the Secrets of the OktaOrgs are read, don't cache every Secret of the cluster
+	cacheOptions := cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {Namespaces: map[string]cache.Config{oktaSecretNamespace: {}}},
+		},
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
+		Cache:                  cacheOptions,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -89,13 +194,106 @@ func main() {
 		os.Exit(1)
 	}
 
+	oktaClients := controller.NewOktaClientRegistry(mgr.GetClient())
+	oktaClients.SecretNamespace = oktaSecretNamespace
+	if err = oktaClients.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up Okta client registry")
+		os.Exit(1)
+	}
+
+	oktaUsers := controller.NewOktaUserResolver(oktaUserCacheTTL)
+
+	var oktaEvents <-chan event.GenericEvent
+	if eventHookAddr != "0" {
+		eventHooks := controller.NewOktaEventHookServer(mgr.GetClient())
+		eventHooks.BindAddress = eventHookAddr
+		eventHooks.AuthHeader = eventHookAuthHeader
+		eventHooks.Secret = os.Getenv("OKTA_EVENT_HOOK_SECRET")
+		eventHooks.OktaUsers = oktaUsers
+		eventHooks.Pod = types.NamespacedName{Namespace: os.Getenv("POD_NAMESPACE"), Name: os.Getenv("POD_NAME")}
+		if eventHooks.Secret == "" {
+			setupLog.Error(nil, "the Okta event hook endpoint requires the OKTA_EVENT_HOOK_SECRET environment variable")
+			os.Exit(1)
+		}
+		if err = eventHooks.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up Okta event hook endpoint")
+			os.Exit(1)
+		}
+		oktaEvents = eventHooks.Events()
+	}
+
 	if err = (&controller.OktaGroupReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		OktaClients:      oktaClients,
+		Recorder:         mgr.GetEventRecorderFor("oktagroup-controller"),
+		OktaPageSize:     oktaPageSize,
+		OktaUsers:        oktaUsers,
+		ResyncInterval:   resyncInterval,
+		DeletionPolicy:   accessmanagerv1.OktaGroupDeletionPolicy(deletionPolicy),
+		ManagerID:        managerID,
+		OIDCGroupsPrefix: oidcGroupsPrefix,
+		RolesAuthorized:  os.Getenv("ENABLE_WEBHOOKS") != "false",
+		DryRun:           dryRun,
+		OktaEvents:       oktaEvents,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "OktaGroup")
 		os.Exit(1)
 	}
+	if err = (&controller.OktaAccessRequestReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor("oktaaccessrequest-controller"),
+		ApproversRecorded:  os.Getenv("ENABLE_WEBHOOKS") != "false",
+		OIDCUsernamePrefix: oidcUsernamePrefix,
+		OktaClients:        oktaClients,
+		OktaUsers:          oktaUsers,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OktaAccessRequest")
+		os.Exit(1)
+	}
+	accessReviews := &controller.AccessReviewReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor("accessreview-controller"),
+		ReviewersRecorded:  os.Getenv("ENABLE_WEBHOOKS") != "false",
+		OIDCUsernamePrefix: oidcUsernamePrefix,
+	}
+	if reportSigningKeyPath != "" {
+		if accessReviews.SigningKey, err = controller.LoadReportSigningKey(reportSigningKeyPath); err != nil {
+			setupLog.Error(err, "unable to load the signing key of the AccessReview reports", "path", reportSigningKeyPath)
+			os.Exit(1)
+		}
+	}
+	if err = accessReviews.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AccessReview")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") == "false" {
+		setupLog.Info("the webhooks are disabled, the kubernetesRBAC of the OktaGroups isn't bound " +
+			"and the OktaAccessApprovals and AccessReview decisions are ignored")
+	} else {
+		if err = (&accessmanagerv1.OktaGroupValidator{
+			Client:          mgr.GetClient(),
+			MaxRemovalRatio: maxRemovalRatio,
+			Authorizer:      mgr.GetClient(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "OktaGroup")
+			os.Exit(1)
+		}
+		if err = (&accessmanagerv1.OktaAccessRequestValidator{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "OktaAccessRequest")
+			os.Exit(1)
+		}
+		if err = (&accessmanagerv1.OktaAccessApprovalDefaulter{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "OktaAccessApproval")
+			os.Exit(1)
+		}
+		if err = (&accessmanagerv1.AccessReviewDefaulter{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AccessReview")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -108,7 +306,9 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	shutdownTracing()
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
@@ -0,0 +1,322 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+const (
+	EventReasonReviewStarted   = "ReviewStarted"
+	EventReasonReviewCompleted = "ReviewCompleted"
+	EventReasonMembersRevoked  = "MembersRevoked"
+	EventReasonRevokeFailed    = "RevokeFailed"
+)
+
+// AccessReviewReconciler runs the AccessReviews: it snapshots the members of the
+// selected OktaGroups, collects the decisions of the reviewers and, once the
+// deadline passes, removes the revoked members and writes the signed report.
+type AccessReviewReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits an event on the AccessReview when it starts and completes.
+	Recorder record.EventRecorder
+
+	// SigningKey signs the reports. The reports are unsigned without it.
+	SigningKey ed25519.PrivateKey
+
+	// ReviewersRecorded is true when the mutating webhook records the reviewers of
+	// the decisions. Otherwise the reviewer is whatever the author of a decision
+	// wrote, so the decisions are ignored.
+	ReviewersRecorded bool
+
+	// OIDCUsernamePrefix is stripped from the reviewers before they are compared with
+	// the emails and logins of the Okta users, the same as the --oidc-username-prefix
+	// of the kube-apiserver.
+	OIDCUsernamePrefix string
+}
+
+//+kubebuilder:rbac:groups=access-manager.github.com,resources=accessreviews,verbs=get;list;watch
+//+kubebuilder:rbac:groups=access-manager.github.com,resources=accessreviews/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update
+
+// Reconcile moves an AccessReview through its phases.
+func (r *AccessReviewReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	accessReview := &accessmanagerv1.AccessReview{}
+	if err := r.Get(ctx, req.NamespacedName, accessReview); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if accessReview.Status.Phase == accessmanagerv1.AccessReviewPhaseCompleted {
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	if accessReview.Status.StartedAt == nil {
+		if err := r.snapshot(ctx, accessReview, now); err != nil {
+			log.Log.Error(err, "unable to snapshot the members of the reviewed OktaGroups")
+			return ctrl.Result{}, err
+		}
+	}
+	accessReview.Status.Message = r.applyDecisions(accessReview)
+
+	var requeueAfter time.Duration
+	if now.Before(accessReview.Spec.Deadline.Time) {
+		requeueAfter = accessReview.Spec.Deadline.Sub(now)
+	} else if err := r.complete(ctx, accessReview, now); err != nil {
+		log.Log.Error(err, "unable to complete AccessReview")
+		accessReview.Status.Message = err.Error()
+		if updateErr := r.Status().Update(ctx, accessReview); updateErr != nil {
+			log.Log.Error(updateErr, "unable to update AccessReview status")
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := r.Status().Update(ctx, accessReview); err != nil {
+		log.Log.Error(err, "unable to update AccessReview status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// snapshot lists the members of the spec of the selected OktaGroups, the ones
+// under review. Members added afterwards aren't reviewed.
+func (r *AccessReviewReconciler) snapshot(ctx context.Context, accessReview *accessmanagerv1.AccessReview, now time.Time) error {
+	selector, err := metav1.LabelSelectorAsSelector(&accessReview.Spec.OktaGroupSelector)
+	if err != nil {
+		return err
+	}
+	oktaGroups := &accessmanagerv1.OktaGroupList{}
+	if err := r.List(ctx, oktaGroups, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return err
+	}
+	slices.SortFunc(oktaGroups.Items, func(a, b accessmanagerv1.OktaGroup) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	accessReview.Status.Items = nil
+	for _, oktaGroup := range oktaGroups.Items {
+		synced := map[string]accessmanagerv1.OktaGroupMemberStatus{}
+		for _, member := range oktaGroup.Status.Members {
+			if member.UserID != "" {
+				synced[memberIdentifier(member)] = member
+			}
+		}
+		for _, member := range oktaGroup.Spec.AllMembers() {
+			user := synced[member.Identifier()]
+			accessReview.Status.Items = append(accessReview.Status.Items, accessmanagerv1.AccessReviewItem{
+				OktaGroup: oktaGroup.Name,
+				Member:    member.Identifier(),
+				UserID:    user.UserID,
+				Email:     strings.ToLower(user.Email),
+				Login:     strings.ToLower(user.Login),
+			})
+		}
+	}
+	startedAt := metav1.NewTime(now)
+	accessReview.Status.StartedAt = &startedAt
+	accessReview.Status.Phase = accessmanagerv1.AccessReviewPhaseInProgress
+	r.Recorder.Eventf(accessReview, corev1.EventTypeNormal, EventReasonReviewStarted,
+		"Reviewing %d members of %d OktaGroups until %s", len(accessReview.Status.Items), len(oktaGroups.Items),
+		accessReview.Spec.Deadline.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// applyDecisions sets the decisions of the reviewers on the items of the review,
+// and counts them. It returns the decisions that were ignored, if any: the ones
+// of users that aren't reviewers, on their own access, or on members that aren't
+// under review.
+func (r *AccessReviewReconciler) applyDecisions(accessReview *accessmanagerv1.AccessReview) string {
+	items := map[[2]string]accessmanagerv1.AccessReviewItem{}
+	userIDs := map[string]string{}
+	for _, item := range accessReview.Status.Items {
+		items[[2]string{item.OktaGroup, item.Member}] = item
+		if item.UserID == "" {
+			continue
+		}
+		for _, identifier := range []string{item.Member, item.Email, item.Login} {
+			if identifier != "" {
+				userIDs[strings.ToLower(identifier)] = item.UserID
+			}
+		}
+	}
+
+	decisions := map[[2]string]accessmanagerv1.AccessReviewDecision{}
+	var ignored []string
+	for _, decision := range accessReview.Spec.Decisions {
+		item, ok := items[[2]string{decision.OktaGroup, decision.Member}]
+		if !ok {
+			item.Member = decision.Member
+		}
+		switch {
+		case !r.ReviewersRecorded:
+			ignored = append(ignored, fmt.Sprintf("%s/%s (reviewer not recorded by the webhook)", decision.OktaGroup, decision.Member))
+		case !slices.ContainsFunc(accessReview.Spec.Reviewers, func(reviewer string) bool {
+			return strings.EqualFold(reviewer, decision.Reviewer)
+		}):
+			ignored = append(ignored, fmt.Sprintf("%s/%s (%q isn't a reviewer)", decision.OktaGroup, decision.Member, decision.Reviewer))
+		case selfReview(strings.TrimPrefix(decision.Reviewer, r.OIDCUsernamePrefix), item, userIDs):
+			ignored = append(ignored, fmt.Sprintf("%s/%s (self-review)", decision.OktaGroup, decision.Member))
+		default:
+			decisions[[2]string{decision.OktaGroup, decision.Member}] = decision
+		}
+	}
+
+	status := &accessReview.Status
+	status.Kept, status.Revoked, status.Unattested = 0, 0, 0
+	for i := range status.Items {
+		item := &status.Items[i]
+		key := [2]string{item.OktaGroup, item.Member}
+		decision, ok := decisions[key]
+		delete(decisions, key)
+		item.Decision, item.Reviewer, item.Comment = decision.Decision, decision.Reviewer, decision.Comment
+		switch {
+		case !ok:
+			status.Unattested++
+		case decision.Decision == accessmanagerv1.AccessReviewDecisionRevoke:
+			status.Revoked++
+		default:
+			status.Kept++
+		}
+	}
+	for key := range decisions {
+		ignored = append(ignored, fmt.Sprintf("%s/%s (not under review)", key[0], key[1]))
+	}
+
+	if len(ignored) == 0 {
+		return ""
+	}
+	slices.Sort(ignored)
+	return fmt.Sprintf("ignored decisions: %s", strings.Join(ignored, ", "))
+}
+
+// selfReview returns whether the reviewer decides on their own access: the reviewer
+// is the member, the email or login of its Okta user, or another identifier of the
+// same Okta user under review. userIDs maps the lowercased identifiers, emails and
+// logins of the items to the IDs of their Okta users.
+func selfReview(reviewer string, item accessmanagerv1.AccessReviewItem, userIDs map[string]string) bool {
+	for _, identifier := range []string{item.Member, item.Email, item.Login} {
+		if identifier != "" && strings.EqualFold(identifier, reviewer) {
+			return true
+		}
+	}
+	userID, ok := userIDs[strings.ToLower(reviewer)]
+	return ok && userID == item.UserID
+}
+
+// complete removes the revoked members, and the unattested ones if the review
+// says so, from the specs of their OktaGroups, then writes the report. The members
+// of an OktaGroup that rejects their removal, such as one that would be left
+// without members, are reported as not removed instead of retrying forever.
+func (r *AccessReviewReconciler) complete(ctx context.Context, accessReview *accessmanagerv1.AccessReview, now time.Time) error {
+	removals := map[string][]string{}
+	for _, item := range accessReview.Status.Items {
+		if item.Decision == accessmanagerv1.AccessReviewDecisionRevoke ||
+			(item.Decision == "" && accessReview.Spec.UnattestedAction == accessmanagerv1.AccessReviewUnattestedActionRemove) {
+			removals[item.OktaGroup] = append(removals[item.OktaGroup], item.Member)
+		}
+	}
+
+	removed := map[string]bool{}
+	var failed []string
+	for name, members := range removals {
+		oktaGroup := &accessmanagerv1.OktaGroup{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name}, oktaGroup); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if oktaGroup.Spec.RemoveMembers(members...) > 0 {
+			if err := r.Update(ctx, oktaGroup); apierrors.IsInvalid(err) || apierrors.IsForbidden(err) {
+				failed = append(failed, fmt.Sprintf("OktaGroup %s (%v)", name, err))
+				r.Recorder.Eventf(accessReview, corev1.EventTypeWarning, EventReasonRevokeFailed,
+					"Unable to remove %s from OktaGroup %s: %v", strings.Join(members, ", "), name, err)
+				continue
+			} else if err != nil {
+				return fmt.Errorf("unable to remove the revoked members of OktaGroup %s: %w", name, err)
+			}
+			r.Recorder.Eventf(oktaGroup, corev1.EventTypeNormal, EventReasonMembersRevoked,
+				"Removed %s as decided by AccessReview %s/%s", strings.Join(members, ", "), accessReview.Namespace, accessReview.Name)
+		}
+		removed[name] = true
+	}
+	for i := range accessReview.Status.Items {
+		item := &accessReview.Status.Items[i]
+		item.Removed = removed[item.OktaGroup] && slices.Contains(removals[item.OktaGroup], item.Member)
+	}
+
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		message := fmt.Sprintf("unable to remove the revoked members of %s", strings.Join(failed, ", "))
+		if accessReview.Status.Message != "" {
+			message = accessReview.Status.Message + "; " + message
+		}
+		accessReview.Status.Message = message
+	}
+
+	completedAt := metav1.NewTime(now)
+	accessReview.Status.CompletedAt = &completedAt
+	data, err := newAccessReviewReport(accessReview).reportData(r.SigningKey)
+	if err != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: accessReview.Namespace, Name: accessReview.Name + "-report"}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		configMap.Data = data
+		return controllerutil.SetControllerReference(accessReview, configMap, r.Scheme)
+	}); err != nil {
+		return fmt.Errorf("unable to write the report: %w", err)
+	}
+
+	accessReview.Status.Phase = accessmanagerv1.AccessReviewPhaseCompleted
+	accessReview.Status.ReportRef = configMap.Name
+	signed := "signed"
+	if r.SigningKey == nil {
+		signed = "unsigned"
+	}
+	r.Recorder.Eventf(accessReview, corev1.EventTypeNormal, EventReasonReviewCompleted,
+		"Kept %d, revoked %d and left %d members unattested, %s report written to ConfigMap %s",
+		accessReview.Status.Kept, accessReview.Status.Revoked, accessReview.Status.Unattested, signed, configMap.Name)
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AccessReviewReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&accessmanagerv1.AccessReview{}).
+		Owns(&corev1.ConfigMap{}).
+		Complete(r)
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+func newTestAccessReview(deadline time.Time, decisions ...accessmanagerv1.AccessReviewDecision) *accessmanagerv1.AccessReview {
+	return &accessmanagerv1.AccessReview{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "audit", Name: "q3"},
+		Spec: accessmanagerv1.AccessReviewSpec{
+			OktaGroupSelector: metav1.LabelSelector{MatchLabels: map[string]string{"privileged": "true"}},
+			Reviewers:         []string{"alice@corp.com"},
+			Deadline:          metav1.NewTime(deadline),
+			UnattestedAction:  accessmanagerv1.AccessReviewUnattestedActionReport,
+			Decisions:         decisions,
+		},
+	}
+}
+
+func newTestAccessReviewClient(accessReview *accessmanagerv1.AccessReview) client.Client {
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+	admins := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "admins", Labels: map[string]string{"privileged": "true"}},
+		Spec: accessmanagerv1.OktaGroupSpec{
+			Users:   []string{"alice@corp.com", "john@corp.com"},
+			Members: []accessmanagerv1.OktaGroupMember{{Login: "svc-ci"}},
+		},
+		Status: accessmanagerv1.OktaGroupStatus{Members: []accessmanagerv1.OktaGroupMemberStatus{
+			{Member: "login:svc-ci", UserID: "00u3", Email: "CI@corp.com", Login: "svc-ci", State: accessmanagerv1.OktaGroupMemberStateMember},
+		}},
+	}
+	developers := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: []string{"jane@corp.com"}},
+	}
+	return fake.NewClientBuilder().WithObjects(accessReview, admins, developers).WithStatusSubresource(accessReview).Build()
+}
+
+func TestAccessReviewReconciler_CollectsDecisions(t *testing.T) {
+	ctx := context.TODO()
+	accessReview := newTestAccessReview(time.Now().Add(time.Hour),
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "admins", Member: "john@corp.com", Decision: accessmanagerv1.AccessReviewDecisionKeep, Reviewer: "Alice@corp.com"},
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "admins", Member: "alice@corp.com", Decision: accessmanagerv1.AccessReviewDecisionKeep, Reviewer: "alice@corp.com"},
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "admins", Member: "login:svc-ci", Decision: accessmanagerv1.AccessReviewDecisionRevoke, Reviewer: "bob@corp.com"},
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "developers", Member: "jane@corp.com", Decision: accessmanagerv1.AccessReviewDecisionRevoke, Reviewer: "alice@corp.com"},
+	)
+	fakeClient := newTestAccessReviewClient(accessReview)
+	reconciler := &AccessReviewReconciler{Client: fakeClient, Scheme: scheme.Scheme, Recorder: record.NewFakeRecorder(10), ReviewersRecorded: true}
+
+	res, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(accessReview)})
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Hour, res.RequeueAfter, float64(5*time.Second))
+
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(accessReview), accessReview))
+	assert.Equal(t, accessmanagerv1.AccessReviewPhaseInProgress, accessReview.Status.Phase)
+	assert.Equal(t, []accessmanagerv1.AccessReviewItem{
+		{OktaGroup: "admins", Member: "alice@corp.com"},
+		{OktaGroup: "admins", Member: "john@corp.com", Decision: accessmanagerv1.AccessReviewDecisionKeep, Reviewer: "Alice@corp.com"},
+		{OktaGroup: "admins", Member: "login:svc-ci", UserID: "00u3", Email: "ci@corp.com", Login: "svc-ci"},
+	}, accessReview.Status.Items)
+	assert.Equal(t, 1, accessReview.Status.Kept)
+	assert.Equal(t, 2, accessReview.Status.Unattested)
+	assert.Equal(t, `ignored decisions: admins/alice@corp.com (self-review), admins/login:svc-ci ("bob@corp.com" isn't a reviewer), `+
+		`developers/jane@corp.com (not under review)`, accessReview.Status.Message)
+}
+
+func TestAccessReviewReconciler_CompletesWithSignedReport(t *testing.T) {
+	ctx := context.TODO()
+	publicKey, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	accessReview := newTestAccessReview(time.Now().Add(-time.Minute),
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "admins", Member: "john@corp.com", Decision: accessmanagerv1.AccessReviewDecisionKeep, Reviewer: "alice@corp.com"},
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "admins", Member: "login:svc-ci", Decision: accessmanagerv1.AccessReviewDecisionRevoke, Reviewer: "alice@corp.com"},
+	)
+	accessReview.Spec.UnattestedAction = accessmanagerv1.AccessReviewUnattestedActionRemove
+	fakeClient := newTestAccessReviewClient(accessReview)
+	reconciler := &AccessReviewReconciler{Client: fakeClient, Scheme: scheme.Scheme, Recorder: record.NewFakeRecorder(10), SigningKey: signingKey, ReviewersRecorded: true}
+
+	res, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(accessReview)})
+	assert.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter)
+
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(accessReview), accessReview))
+	assert.Equal(t, accessmanagerv1.AccessReviewPhaseCompleted, accessReview.Status.Phase)
+	assert.Equal(t, "q3-report", accessReview.Status.ReportRef)
+
+	// The revoked and unattested members are removed from the spec
+	admins := &accessmanagerv1.OktaGroup{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKey{Name: "admins"}, admins))
+	assert.Equal(t, []string{"john@corp.com"}, admins.Spec.Users)
+	assert.Empty(t, admins.Spec.Members)
+
+	report := &corev1.ConfigMap{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKey{Namespace: "audit", Name: "q3-report"}, report))
+	assert.Equal(t, "oktaGroup,member,decision,reviewer,comment,removed\n"+
+		"admins,alice@corp.com,,,,true\n"+
+		"admins,john@corp.com,Keep,alice@corp.com,,false\n"+
+		"admins,login:svc-ci,Revoke,alice@corp.com,,true\n", report.Data[accessmanagerv1.AccessReviewReportCSVKey])
+	for key, signatureKey := range map[string]string{
+		accessmanagerv1.AccessReviewReportJSONKey: accessmanagerv1.AccessReviewReportJSONSignatureKey,
+		accessmanagerv1.AccessReviewReportCSVKey:  accessmanagerv1.AccessReviewReportCSVSignatureKey,
+	} {
+		signature, err := base64.StdEncoding.DecodeString(report.Data[signatureKey])
+		assert.NoError(t, err)
+		assert.True(t, ed25519.Verify(publicKey, []byte(report.Data[key]), signature), key)
+	}
+}
+
+func TestApplyDecisions_IgnoresSelfReviewsOfTheSameOktaUser(t *testing.T) {
+	accessReview := newTestAccessReview(time.Now().Add(time.Hour),
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "breakglass", Member: "id:00u1", Decision: accessmanagerv1.AccessReviewDecisionKeep, Reviewer: "Alice@corp.com"},
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "breakglass", Member: "login:alice", Decision: accessmanagerv1.AccessReviewDecisionKeep, Reviewer: "alice@old.com"},
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "breakglass", Member: "login:john", Decision: accessmanagerv1.AccessReviewDecisionKeep, Reviewer: "oidc:alice"},
+	)
+	accessReview.Spec.Reviewers = []string{"alice@corp.com", "alice@old.com", "oidc:alice"}
+	// alice is a member by ID and by login, and was renamed from alice@old.com
+	accessReview.Status.Items = []accessmanagerv1.AccessReviewItem{
+		{OktaGroup: "breakglass", Member: "id:00u1", UserID: "00u1", Email: "alice@corp.com", Login: "alice"},
+		{OktaGroup: "breakglass", Member: "login:alice", UserID: "00u1", Email: "alice@corp.com", Login: "alice"},
+		{OktaGroup: "breakglass", Member: "login:john", UserID: "00u2", Email: "john@corp.com", Login: "john"},
+		{OktaGroup: "developers", Member: "alice@old.com", UserID: "00u1", Email: "alice@corp.com", Login: "alice"},
+	}
+
+	reconciler := &AccessReviewReconciler{ReviewersRecorded: true, OIDCUsernamePrefix: "oidc:"}
+	assert.Equal(t, "ignored decisions: breakglass/id:00u1 (self-review), breakglass/login:alice (self-review)", reconciler.applyDecisions(accessReview))
+	assert.Equal(t, 1, accessReview.Status.Kept)
+	assert.Equal(t, accessmanagerv1.AccessReviewDecisionKeep, accessReview.Status.Items[2].Decision)
+
+	// Without the webhook the reviewers can be forged, so the decisions are ignored
+	reconciler.ReviewersRecorded = false
+	assert.Contains(t, reconciler.applyDecisions(accessReview), "breakglass/login:john (reviewer not recorded by the webhook)")
+	assert.Zero(t, accessReview.Status.Kept)
+}
+
+func TestAccessReviewReconciler_CompletesWhenTheRemovalIsRejected(t *testing.T) {
+	ctx := context.TODO()
+	accessReview := newTestAccessReview(time.Now().Add(-time.Minute),
+		accessmanagerv1.AccessReviewDecision{OktaGroup: "admins", Member: "john@corp.com", Decision: accessmanagerv1.AccessReviewDecisionRevoke, Reviewer: "alice@corp.com"},
+	)
+	accessReview.Spec.UnattestedAction = accessmanagerv1.AccessReviewUnattestedActionRemove
+	// The webhook rejects an OktaGroup without members
+	rejecting := interceptor.Funcs{Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+		return apierrors.NewInvalid(accessmanagerv1.GroupVersion.WithKind("OktaGroup").GroupKind(), obj.GetName(),
+			field.ErrorList{field.Required(field.NewPath("spec", "users"), "at least one user, member or included group is required")})
+	}}
+	fakeClient := interceptor.NewClient(newTestAccessReviewClient(accessReview).(client.WithWatch), rejecting)
+	recorder := record.NewFakeRecorder(10)
+	reconciler := &AccessReviewReconciler{Client: fakeClient, Scheme: scheme.Scheme, Recorder: recorder, ReviewersRecorded: true}
+
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(accessReview)})
+	assert.NoError(t, err)
+
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(accessReview), accessReview))
+	assert.Equal(t, accessmanagerv1.AccessReviewPhaseCompleted, accessReview.Status.Phase)
+	assert.Contains(t, accessReview.Status.Message, "unable to remove the revoked members of OktaGroup admins")
+	for _, item := range accessReview.Status.Items {
+		assert.False(t, item.Removed, item.Member)
+	}
+	<-recorder.Events
+	assert.Contains(t, <-recorder.Events, "Warning RevokeFailed Unable to remove alice@corp.com, john@corp.com, login:svc-ci from OktaGroup admins")
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+// accessReviewReport is the audit evidence of a completed AccessReview.
+type accessReviewReport struct {
+	Namespace        string                                       `json:"namespace"`
+	Name             string                                       `json:"name"`
+	UID              string                                       `json:"uid"`
+	Reviewers        []string                                     `json:"reviewers"`
+	Deadline         metav1.Time                                  `json:"deadline"`
+	UnattestedAction accessmanagerv1.AccessReviewUnattestedAction `json:"unattestedAction"`
+	StartedAt        *metav1.Time                                 `json:"startedAt"`
+	CompletedAt      *metav1.Time                                 `json:"completedAt"`
+	Items            []accessmanagerv1.AccessReviewItem           `json:"items"`
+}
+
+// newAccessReviewReport returns the report of an AccessReview from its status.
+func newAccessReviewReport(accessReview *accessmanagerv1.AccessReview) *accessReviewReport {
+	return &accessReviewReport{
+		Namespace:        accessReview.Namespace,
+		Name:             accessReview.Name,
+		UID:              string(accessReview.UID),
+		Reviewers:        accessReview.Spec.Reviewers,
+		Deadline:         accessReview.Spec.Deadline,
+		UnattestedAction: accessReview.Spec.UnattestedAction,
+		StartedAt:        accessReview.Status.StartedAt,
+		CompletedAt:      accessReview.Status.CompletedAt,
+		Items:            accessReview.Status.Items,
+	}
+}
+
+// JSON returns the indented JSON encoding of the report.
+func (r *accessReviewReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// CSV returns the items of the report as CSV, one row per reviewed member.
+func (r *accessReviewReport) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"oktaGroup", "member", "decision", "reviewer", "comment", "removed"})
+	for _, item := range r.Items {
+		w.Write([]string{item.OktaGroup, item.Member, string(item.Decision), item.Reviewer, item.Comment, strconv.FormatBool(item.Removed)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// reportData returns the data of the ConfigMap of a report: its JSON and CSV
+// encodings, and their signatures when a signing key is given.
+func (r *accessReviewReport) reportData(signingKey ed25519.PrivateKey) (map[string]string, error) {
+	reportJSON, err := r.JSON()
+	if err != nil {
+		return nil, err
+	}
+	reportCSV, err := r.CSV()
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{
+		accessmanagerv1.AccessReviewReportJSONKey: string(reportJSON),
+		accessmanagerv1.AccessReviewReportCSVKey:  string(reportCSV),
+	}
+	if signingKey != nil {
+		data[accessmanagerv1.AccessReviewReportJSONSignatureKey] = base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, reportJSON))
+		data[accessmanagerv1.AccessReviewReportCSVSignatureKey] = base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, reportCSV))
+	}
+	return data, nil
+}
+
+// LoadReportSigningKey reads the PEM encoded Ed25519 private key (PKCS #8) that
+// signs the reports of the AccessReviews.
+func LoadReportSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an Ed25519 private key, got %T", key)
+	}
+	return signingKey, nil
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+var (
+	oktaGroupsDrifted = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "access_manager_oktagroups_drifted",
+		Help: "Number of OktaGroups whose last reconcile found and reverted changes made outside of the operator.",
+	})
+
+	driftedOktaGroups = &oktaGroupSet{gauge: oktaGroupsDrifted, names: map[string]struct{}{}}
+
+	oktaAPIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_manager_okta_api_requests_total",
+		Help: "Number of Okta API calls by org, method, endpoint family and status code, or \"error\" if no response was received.",
+	}, []string{"org", "method", "endpoint", "code"})
+
+	oktaAPIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "access_manager_okta_api_request_duration_seconds",
+		Help:    "Duration of the Okta API calls by org, method and endpoint family.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"org", "method", "endpoint"})
+
+	oktaRateLimitRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_manager_okta_rate_limit_remaining",
+		Help: "Number of calls left in the current rate limit window of an Okta endpoint family, as last reported by Okta.",
+	}, []string{"org", "endpoint"})
+
+	oktaRateLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_manager_okta_rate_limit",
+		Help: "Number of calls allowed per rate limit window of an Okta endpoint family, as last reported by Okta.",
+	}, []string{"org", "endpoint"})
+
+	oktaGroupMembersAdded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_manager_oktagroup_members_added_total",
+		Help: "Number of users added to the Okta group of an OktaGroup.",
+	}, []string{"org", "oktagroup"})
+
+	oktaGroupMembersRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_manager_oktagroup_members_removed_total",
+		Help: "Number of users removed from the Okta group of an OktaGroup.",
+	}, []string{"org", "oktagroup"})
+
+	oktaGroupMembers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_manager_oktagroup_members",
+		Help: "Number of members of the spec of an OktaGroup that are members of its Okta group.",
+	}, []string{"org", "oktagroup"})
+
+	oktaGroupUnresolvedMembers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_manager_oktagroup_unresolved_members",
+		Help: "Number of members of the spec of an OktaGroup that aren't members of its Okta group.",
+	}, []string{"org", "oktagroup"})
+
+	oktaGroupReconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_manager_oktagroup_reconciles_total",
+		Help: "Number of OktaGroup reconciles by org and reason of the resulting Ready condition, Synced if it succeeded.",
+	}, []string{"org", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		oktaGroupsDrifted,
+		oktaAPIRequests,
+		oktaAPIRequestDuration,
+		oktaRateLimitRemaining,
+		oktaRateLimit,
+		oktaGroupMembersAdded,
+		oktaGroupMembersRemoved,
+		oktaGroupMembers,
+		oktaGroupUnresolvedMembers,
+		oktaGroupReconciles,
+	)
+}
+
+// oktaGroupSet is a set of OktaGroup names whose size is exported by a gauge.
+type oktaGroupSet struct {
+	gauge prometheus.Gauge
+
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+// set adds the OktaGroup to the set or removes it from it.
+func (s *oktaGroupSet) set(name string, included bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if included {
+		s.names[name] = struct{}{}
+	} else {
+		delete(s.names, name)
+	}
+	s.gauge.Set(float64(len(s.names)))
+}
+
+// observeReconcile counts a reconcile of the OktaGroup by the reason of its Ready
+// condition, and exports the number of members in its status.
+func observeReconcile(oktaGroupCRD *accessmanagerv1.OktaGroup, reason string) {
+	org := oktaGroupCRD.Spec.OktaOrgRef
+	oktaGroupReconciles.WithLabelValues(org, reason).Inc()
+	oktaGroupMembers.WithLabelValues(org, oktaGroupCRD.Name).Set(float64(oktaGroupCRD.Status.SyncedMembers))
+	oktaGroupUnresolvedMembers.WithLabelValues(org, oktaGroupCRD.Name).Set(float64(oktaGroupCRD.Status.UnresolvedMembers))
+}
+
+// forgetOktaGroup drops the metrics of a deleted OktaGroup.
+func forgetOktaGroup(oktaGroupCRD *accessmanagerv1.OktaGroup) {
+	driftedOktaGroups.set(oktaGroupCRD.Name, false)
+	labels := prometheus.Labels{"oktagroup": oktaGroupCRD.Name}
+	for _, vec := range []interface{ DeletePartialMatch(prometheus.Labels) int }{
+		oktaGroupMembersAdded, oktaGroupMembersRemoved, oktaGroupMembers, oktaGroupUnresolvedMembers,
+	} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// instrumentedTransport records the Okta API calls of an org, and the rate limits
+// reported in their responses, in the metrics and as spans.
+type instrumentedTransport struct {
+	next http.RoundTripper
+	org  string
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := oktaEndpointFamily(req.URL.Path)
+	req, span := traceOktaRequest(req, t.org, endpoint)
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	oktaAPIRequestDuration.WithLabelValues(t.org, req.Method, endpoint).Observe(time.Since(start).Seconds())
+	endOktaRequest(span, resp, err)
+	if err != nil {
+		oktaAPIRequests.WithLabelValues(t.org, req.Method, endpoint, "error").Inc()
+		return nil, err
+	}
+	oktaAPIRequests.WithLabelValues(t.org, req.Method, endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Remaining")); err == nil {
+		oktaRateLimitRemaining.WithLabelValues(t.org, endpoint).Set(float64(remaining))
+	}
+	if limit, err := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Limit")); err == nil {
+		oktaRateLimit.WithLabelValues(t.org, endpoint).Set(float64(limit))
+	}
+	return resp, nil
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+func TestOktaGroupSet_CountsDriftedGroups(t *testing.T) {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_drifted"})
+	groups := &oktaGroupSet{gauge: gauge, names: map[string]struct{}{}}
+
+	groups.set("developers", true)
+	groups.set("admins", true)
+	groups.set("developers", true)
+	assert.Equal(t, 2.0, testutil.ToFloat64(gauge))
+
+	groups.set("developers", false)
+	groups.set("unknown", false)
+	assert.Equal(t, 1.0, testutil.ToFloat64(gauge))
+}
+
+func TestInstrumentedTransport_RecordsOktaAPICalls(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit-Limit", "600")
+		w.Header().Set("X-Rate-Limit-Remaining", "42")
+		writeTestJSON(w, status, "[]")
+	}))
+	defer server.Close()
+
+	httpClient := newRateLimitedHTTPClient("metrics", newOktaRateLimiter())
+	get := func(path string) {
+		if resp, err := httpClient.Get(server.URL + path); err == nil {
+			resp.Body.Close()
+		}
+	}
+	get("/api/v1/groups/00g1/users")
+	get("/api/v1/groups/00g2/users")
+	status = http.StatusTooManyRequests
+	get("/api/v1/groups/00g1/users")
+
+	endpoint := "/api/v1/groups/{id}/users"
+	assert.Equal(t, 2.0, testutil.ToFloat64(oktaAPIRequests.WithLabelValues("metrics", "GET", endpoint, "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(oktaAPIRequests.WithLabelValues("metrics", "GET", endpoint, "429")))
+	assert.Equal(t, 42.0, testutil.ToFloat64(oktaRateLimitRemaining.WithLabelValues("metrics", endpoint)))
+	assert.Equal(t, 600.0, testutil.ToFloat64(oktaRateLimit.WithLabelValues("metrics", endpoint)))
+}
+
+func TestObserveReconcile_ForgetsDeletedGroups(t *testing.T) {
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "metrics"},
+		Spec:       accessmanagerv1.OktaGroupSpec{OktaOrgRef: "metrics"},
+		Status:     accessmanagerv1.OktaGroupStatus{SyncedMembers: 3, UnresolvedMembers: 1},
+	}
+	observeReconcile(oktaGroupCRD, accessmanagerv1.OktaGroupReasonSynced)
+	observeReconcile(oktaGroupCRD, accessmanagerv1.OktaGroupReasonMembersUnresolved)
+	assert.Equal(t, 1.0, testutil.ToFloat64(oktaGroupReconciles.WithLabelValues("metrics", accessmanagerv1.OktaGroupReasonSynced)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(oktaGroupMembers.WithLabelValues("metrics", "metrics")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(oktaGroupUnresolvedMembers.WithLabelValues("metrics", "metrics")))
+
+	members := testutil.CollectAndCount(oktaGroupMembers)
+	forgetOktaGroup(oktaGroupCRD)
+	assert.Equal(t, members-1, testutil.CollectAndCount(oktaGroupMembers))
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+const (
+	EventReasonAccessApproved = "AccessApproved"
+	EventReasonAccessDenied   = "AccessDenied"
+	EventReasonAccessExpired  = "AccessExpired"
+)
+
+// OktaAccessRequestReconciler moves the OktaAccessRequests through their states as
+// they are approved, denied and expire. The OktaGroupReconciler adds the users of
+// the approved requests to the Okta groups.
+type OktaAccessRequestReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits an event on the OktaAccessRequest when it is decided or expires.
+	Recorder record.EventRecorder
+
+	// ApproversRecorded is true when the mutating webhook records the approvers of
+	// the OktaAccessApprovals. Otherwise the approver is whatever the creator of an
+	// approval wrote, so the approvals are ignored.
+	ApproversRecorded bool
+
+	// OIDCUsernamePrefix is stripped from the approvers before they are compared with
+	// the emails and logins of the Okta users, the same as the --oidc-username-prefix
+	// of the kube-apiserver.
+	OIDCUsernamePrefix string
+
+	// OktaClients hands out the shared Okta client of each OktaOrg.
+	OktaClients *OktaClientRegistry
+
+	// OktaUsers resolves the users of the requests to Okta users, so that a user can't
+	// approve their own request under another email or login. Without it the users
+	// are looked up without caching.
+	OktaUsers *OktaUserResolver
+}
+
+//+kubebuilder:rbac:groups=access-manager.github.com,resources=oktaaccessrequests,verbs=get;list;watch
+//+kubebuilder:rbac:groups=access-manager.github.com,resources=oktaaccessrequests/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=access-manager.github.com,resources=oktaaccessapprovals,verbs=get;list;watch
+
+// Reconcile decides a pending OktaAccessRequest with the first valid approval, and
+// expires an approved one once its duration has passed.
+func (r *OktaAccessRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	accessRequest := &accessmanagerv1.OktaAccessRequest{}
+	if err := r.Get(ctx, req.NamespacedName, accessRequest); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	now := time.Now()
+	status := accessRequest.Status.DeepCopy()
+	var requeueAfter time.Duration
+	switch accessRequest.Status.State {
+	case accessmanagerv1.OktaAccessRequestStateDenied, accessmanagerv1.OktaAccessRequestStateExpired:
+		return ctrl.Result{}, nil
+	case accessmanagerv1.OktaAccessRequestStateApproved:
+		if expiresAt := accessRequest.Status.ExpiresAt; expiresAt != nil && now.Before(expiresAt.Time) {
+			return ctrl.Result{RequeueAfter: expiresAt.Sub(now)}, nil
+		}
+		status.State = accessmanagerv1.OktaAccessRequestStateExpired
+		status.Message = fmt.Sprintf("membership in OktaGroup %s expired", accessRequest.Spec.OktaGroupRef)
+		r.Recorder.Event(accessRequest, corev1.EventTypeNormal, EventReasonAccessExpired, status.Message)
+	default:
+		if err := r.decide(ctx, accessRequest, status, now); err != nil {
+			return ctrl.Result{}, err
+		}
+		if status.ExpiresAt != nil {
+			requeueAfter = status.ExpiresAt.Sub(now)
+		}
+	}
+
+	if !equalAccessRequestStatus(&accessRequest.Status, status) {
+		accessRequest.Status = *status
+		if err := r.Status().Update(ctx, accessRequest); err != nil {
+			log.Log.Error(err, "unable to update OktaAccessRequest status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// decide sets the status of a pending request from its approvals. The first
+// approval, by creation time, of a member of the approver group decides it.
+func (r *OktaAccessRequestReconciler) decide(ctx context.Context, accessRequest *accessmanagerv1.OktaAccessRequest, status *accessmanagerv1.OktaAccessRequestStatus, now time.Time) error {
+	status.State = accessmanagerv1.OktaAccessRequestStatePending
+
+	oktaGroup := &accessmanagerv1.OktaGroup{}
+	if err := r.Get(ctx, types.NamespacedName{Name: accessRequest.Spec.OktaGroupRef}, oktaGroup); err != nil {
+		if apierrors.IsNotFound(err) {
+			status.Message = fmt.Sprintf("OktaGroup %s not found", accessRequest.Spec.OktaGroupRef)
+			return nil
+		}
+		return err
+	}
+	if oktaGroup.Spec.ApproverGroupRef == "" {
+		status.State = accessmanagerv1.OktaAccessRequestStateDenied
+		status.Message = fmt.Sprintf("OktaGroup %s has no approver group", oktaGroup.Name)
+		r.Recorder.Event(accessRequest, corev1.EventTypeWarning, EventReasonAccessDenied, status.Message)
+		return nil
+	}
+	approvers := &accessmanagerv1.OktaGroup{}
+	if err := r.Get(ctx, types.NamespacedName{Name: oktaGroup.Spec.ApproverGroupRef}, approvers); err != nil {
+		if apierrors.IsNotFound(err) {
+			status.Message = fmt.Sprintf("approver OktaGroup %s not found", oktaGroup.Spec.ApproverGroupRef)
+			return nil
+		}
+		return err
+	}
+
+	if !r.ApproversRecorded {
+		status.Message = "approvals are ignored while the webhook recording their approvers is disabled"
+		return nil
+	}
+
+	approvals := &accessmanagerv1.OktaAccessApprovalList{}
+	if err := r.List(ctx, approvals, client.InNamespace(accessRequest.Namespace)); err != nil {
+		return err
+	}
+	slices.SortStableFunc(approvals.Items, func(a, b accessmanagerv1.OktaAccessApproval) int {
+		return a.CreationTimestamp.Time.Compare(b.CreationTimestamp.Time)
+	})
+
+	approvals.Items = slices.DeleteFunc(approvals.Items, func(approval accessmanagerv1.OktaAccessApproval) bool {
+		return approval.Spec.AccessRequestRef != accessRequest.Name
+	})
+	var requesterIDs map[string]bool
+	if len(approvals.Items) > 0 {
+		var err error
+		if requesterIDs, err = r.requesterIDs(ctx, accessRequest, approvers); err != nil {
+			return err
+		}
+	}
+
+	var ignored []string
+	for _, approval := range approvals.Items {
+		if reason := invalidApproval(&approval, accessRequest, approvers, requesterIDs, r.OIDCUsernamePrefix); reason != "" {
+			ignored = append(ignored, fmt.Sprintf("%s (%s)", approval.Name, reason))
+			continue
+		}
+
+		decidedAt := metav1.NewTime(now)
+		status.DecidedBy = approval.Spec.Approver
+		status.DecidedAt = &decidedAt
+		if approval.Spec.Decision == accessmanagerv1.OktaAccessDecisionDeny {
+			status.State = accessmanagerv1.OktaAccessRequestStateDenied
+			status.Message = fmt.Sprintf("denied by %s", approval.Spec.Approver)
+			r.Recorder.Event(accessRequest, corev1.EventTypeNormal, EventReasonAccessDenied, status.Message)
+			return nil
+		}
+		expiresAt := metav1.NewTime(now.Add(accessRequest.Spec.Duration.Duration).Truncate(time.Second))
+		status.State = accessmanagerv1.OktaAccessRequestStateApproved
+		status.ExpiresAt = &expiresAt
+		status.Message = fmt.Sprintf("approved by %s, member of OktaGroup %s until %s",
+			approval.Spec.Approver, oktaGroup.Name, expiresAt.UTC().Format(time.RFC3339))
+		r.Recorder.Event(accessRequest, corev1.EventTypeNormal, EventReasonAccessApproved, status.Message)
+		return nil
+	}
+
+	status.Message = fmt.Sprintf("waiting for an approval by a member of OktaGroup %s", approvers.Name)
+	if len(ignored) > 0 {
+		status.Message += fmt.Sprintf(", ignored approvals: %s", strings.Join(ignored, ", "))
+	}
+	return nil
+}
+
+// requesterIDs returns the IDs of the Okta users with the email of the user of the
+// request, in the Okta org of the approver group whose members approve it.
+func (r *OktaAccessRequestReconciler) requesterIDs(ctx context.Context, accessRequest *accessmanagerv1.OktaAccessRequest, approvers *accessmanagerv1.OktaGroup) (map[string]bool, error) {
+	oktaClient, err := r.OktaClients.Client(ctx, approvers.Spec.OktaOrgRef)
+	if err != nil {
+		return nil, err
+	}
+	resolver := r.OktaUsers
+	if resolver == nil {
+		resolver = NewOktaUserResolver(0)
+	}
+	resolved, err := resolver.Resolve(ctx, NewOktaAPI(oktaClient).User, approvers.Spec.OktaOrgRef, []string{accessRequest.Spec.User}, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up the Okta user of %s: %w", accessRequest.Spec.User, err)
+	}
+	ids := map[string]bool{}
+	for _, user := range resolved[accessRequest.Spec.User] {
+		ids[user.Id] = true
+	}
+	return ids, nil
+}
+
+// invalidApproval returns why an approval can't decide the request, or an empty
+// string if it can. Approvers must be members of the approver group, and can't
+// approve their own requests. They are matched by Kubernetes username, without
+// the OIDC username prefix, against the emails and logins of the Okta users, and
+// compared with the user of the request by Okta user ID, requesterIDs being the
+// IDs of the Okta users with its email.
+func invalidApproval(approval *accessmanagerv1.OktaAccessApproval, accessRequest *accessmanagerv1.OktaAccessRequest, approvers *accessmanagerv1.OktaGroup, requesterIDs map[string]bool, usernamePrefix string) string {
+	approver := strings.TrimPrefix(approval.Spec.Approver, usernamePrefix)
+	switch {
+	case approver == "":
+		return "no approver"
+	case strings.EqualFold(approver, accessRequest.Spec.User):
+		return "self-approval"
+	}
+	for _, member := range approvers.Status.Members {
+		if member.State != accessmanagerv1.OktaGroupMemberStateMember {
+			continue
+		}
+		if !strings.EqualFold(approver, member.Email) && !strings.EqualFold(approver, member.Login) {
+			continue
+		}
+		if requesterIDs[member.UserID] {
+			return "self-approval"
+		}
+		return ""
+	}
+	return fmt.Sprintf("%s isn't a member of OktaGroup %s", approver, approvers.Name)
+}
+
+// equalAccessRequestStatus reports whether two statuses are the same, so that
+// unchanged statuses aren't written back.
+func equalAccessRequestStatus(a, b *accessmanagerv1.OktaAccessRequestStatus) bool {
+	return a.State == b.State && a.Message == b.Message && a.DecidedBy == b.DecidedBy &&
+		a.DecidedAt.Equal(b.DecidedAt) && a.ExpiresAt.Equal(b.ExpiresAt)
+}
+
+// grantedMembers returns the users of the approved OktaAccessRequests of an
+// OktaGroup, as members that expire with their requests.
+func grantedMembers(ctx context.Context, c client.Reader, oktaGroupCRD *accessmanagerv1.OktaGroup) ([]accessmanagerv1.OktaGroupMember, error) {
+	accessRequests := &accessmanagerv1.OktaAccessRequestList{}
+	if err := c.List(ctx, accessRequests); err != nil {
+		return nil, err
+	}
+	var members []accessmanagerv1.OktaGroupMember
+	for _, accessRequest := range accessRequests.Items {
+		if accessRequest.Spec.OktaGroupRef != oktaGroupCRD.Name ||
+			accessRequest.Status.State != accessmanagerv1.OktaAccessRequestStateApproved || accessRequest.Status.ExpiresAt == nil {
+			continue
+		}
+		members = append(members, accessmanagerv1.OktaGroupMember{
+			Email:     accessRequest.Spec.User,
+			ExpiresAt: accessRequest.Status.ExpiresAt,
+		})
+	}
+	return members, nil
+}
+
+// accessRequestsOfOktaGroup enqueues the pending OktaAccessRequests for an OktaGroup,
+// or approved by its members, when it changes.
+func (r *OktaAccessRequestReconciler) accessRequestsOfOktaGroup(ctx context.Context, obj client.Object) []reconcile.Request {
+	accessRequests := &accessmanagerv1.OktaAccessRequestList{}
+	if err := r.List(ctx, accessRequests); err != nil {
+		log.Log.Error(err, "unable to list OktaAccessRequests")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, accessRequest := range accessRequests.Items {
+		if accessRequest.Status.State != "" && accessRequest.Status.State != accessmanagerv1.OktaAccessRequestStatePending {
+			continue
+		}
+		if accessRequest.Spec.OktaGroupRef != obj.GetName() {
+			oktaGroup := &accessmanagerv1.OktaGroup{}
+			if err := r.Get(ctx, types.NamespacedName{Name: accessRequest.Spec.OktaGroupRef}, oktaGroup); err != nil ||
+				oktaGroup.Spec.ApproverGroupRef != obj.GetName() {
+				continue
+			}
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&accessRequest)})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *OktaAccessRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&accessmanagerv1.OktaAccessRequest{}).
+		Watches(&accessmanagerv1.OktaAccessApproval{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				approval := obj.(*accessmanagerv1.OktaAccessApproval)
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{
+					Namespace: approval.Namespace, Name: approval.Spec.AccessRequestRef,
+				}}}
+			})).
+		Watches(&accessmanagerv1.OktaGroup{}, handler.EnqueueRequestsFromMapFunc(r.accessRequestsOfOktaGroup)).
+		Complete(r)
+}
@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+	"github.com/franciscoprin/access-manager-operator/internal/oktafake"
+)
+
+func newTestAccessRequest(name, user string) *accessmanagerv1.OktaAccessRequest {
+	return &accessmanagerv1.OktaAccessRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: name},
+		Spec: accessmanagerv1.OktaAccessRequestSpec{
+			OktaGroupRef:  "production",
+			User:          user,
+			Justification: "incident",
+			Duration:      metav1.Duration{Duration: time.Hour},
+		},
+	}
+}
+
+func newTestAccessApproval(name, accessRequest, approver string, decision accessmanagerv1.OktaAccessDecision, created time.Time) *accessmanagerv1.OktaAccessApproval {
+	return &accessmanagerv1.OktaAccessApproval{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: name, CreationTimestamp: metav1.NewTime(created)},
+		Spec: accessmanagerv1.OktaAccessApprovalSpec{
+			AccessRequestRef: accessRequest,
+			Decision:         decision,
+			Approver:         approver,
+		},
+	}
+}
+
+func TestOktaAccessRequestReconciler_DecidesRequests(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	server := oktafake.NewServer()
+	defer server.Close()
+	oktaClient, err := server.Client(ctx)
+	assert.NoError(t, err)
+	alice := server.AddUser("alice", "alice@corp.com", oktafake.StatusActive)
+	bob := server.AddUser("bob", "bob@corp.com", oktafake.StatusActive)
+
+	now := time.Now()
+	production := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "production"},
+		Spec:       accessmanagerv1.OktaGroupSpec{ApproverGroupRef: "sre"},
+	}
+	sre := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "sre"},
+		Status: accessmanagerv1.OktaGroupStatus{Members: []accessmanagerv1.OktaGroupMemberStatus{
+			{Member: "alice@corp.com", Email: "alice@corp.com", Login: "alice", UserID: alice.Id, State: accessmanagerv1.OktaGroupMemberStateMember},
+			{Member: "bob@corp.com", Email: "bob@corp.com", Login: "bob", UserID: bob.Id, State: accessmanagerv1.OktaGroupMemberStateMember},
+			{Member: "carol@corp.com", Email: "carol@corp.com", State: accessmanagerv1.OktaGroupMemberStateInactive},
+		}},
+	}
+	objects := []client.Object{
+		production, sre,
+		newTestAccessRequest("approved", "john@corp.com"),
+		newTestAccessRequest("denied", "john@corp.com"),
+		newTestAccessRequest("pending", "bob@corp.com"),
+		newTestAccessApproval("by-carol", "approved", "carol@corp.com", accessmanagerv1.OktaAccessDecisionApprove, now.Add(-2*time.Minute)),
+		newTestAccessApproval("by-alice", "approved", "oidc:ALICE", accessmanagerv1.OktaAccessDecisionApprove, now.Add(-time.Minute)),
+		newTestAccessApproval("by-bob", "approved", "bob@corp.com", accessmanagerv1.OktaAccessDecisionDeny, now),
+		newTestAccessApproval("deny", "denied", "bob@corp.com", accessmanagerv1.OktaAccessDecisionDeny, now),
+		newTestAccessApproval("self", "pending", "oidc:bob@corp.com", accessmanagerv1.OktaAccessDecisionApprove, now),
+		newTestAccessRequest("by-login", "bob@corp.com"),
+		newTestAccessApproval("self-login", "by-login", "oidc:bob", accessmanagerv1.OktaAccessDecisionApprove, now),
+		newTestAccessRequest("unrecorded", "john@corp.com"),
+		newTestAccessApproval("forged", "unrecorded", "alice@corp.com", accessmanagerv1.OktaAccessDecisionApprove, now),
+	}
+	fakeClient := fake.NewClientBuilder().WithObjects(objects...).
+		WithStatusSubresource(&accessmanagerv1.OktaAccessRequest{}).Build()
+	reconciler := &OktaAccessRequestReconciler{Client: fakeClient, Scheme: scheme.Scheme, Recorder: record.NewFakeRecorder(10),
+		ApproversRecorded: true, OIDCUsernamePrefix: "oidc:", OktaClients: NewOktaClientRegistry(fakeClient)}
+	reconciler.OktaClients.Register("", oktaClient)
+
+	for name, want := range map[string]struct {
+		state     accessmanagerv1.OktaAccessRequestState
+		decidedBy string
+		message   string
+	}{
+		"approved": {accessmanagerv1.OktaAccessRequestStateApproved, "oidc:ALICE", ""},
+		"denied":   {accessmanagerv1.OktaAccessRequestStateDenied, "bob@corp.com", "denied by bob@corp.com"},
+		"pending": {accessmanagerv1.OktaAccessRequestStatePending, "",
+			"waiting for an approval by a member of OktaGroup sre, ignored approvals: self (self-approval)"},
+		// The approver is the same Okta user as the one of the request, under its login
+		"by-login": {accessmanagerv1.OktaAccessRequestStatePending, "",
+			"waiting for an approval by a member of OktaGroup sre, ignored approvals: self-login (self-approval)"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			key := client.ObjectKey{Namespace: "team-a", Name: name}
+			res, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			assert.NoError(t, err)
+
+			accessRequest := &accessmanagerv1.OktaAccessRequest{}
+			assert.NoError(t, fakeClient.Get(ctx, key, accessRequest))
+			assert.Equal(t, want.state, accessRequest.Status.State)
+			assert.Equal(t, want.decidedBy, accessRequest.Status.DecidedBy)
+			if want.message != "" {
+				assert.Equal(t, want.message, accessRequest.Status.Message)
+			}
+			if want.state == accessmanagerv1.OktaAccessRequestStateApproved {
+				assert.WithinDuration(t, now.Add(time.Hour), accessRequest.Status.ExpiresAt.Time, 5*time.Second)
+				assert.InDelta(t, time.Hour, res.RequeueAfter, float64(5*time.Second))
+			}
+		})
+	}
+
+	// Without the webhook the approvers can be forged, so the approvals are ignored
+	reconciler.ApproversRecorded = false
+	key := client.ObjectKey{Namespace: "team-a", Name: "unrecorded"}
+	_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+	assert.NoError(t, err)
+	accessRequest := &accessmanagerv1.OktaAccessRequest{}
+	assert.NoError(t, fakeClient.Get(ctx, key, accessRequest))
+	assert.Equal(t, accessmanagerv1.OktaAccessRequestStatePending, accessRequest.Status.State)
+	assert.Equal(t, "approvals are ignored while the webhook recording their approvers is disabled", accessRequest.Status.Message)
+
+	// The users of the approved requests are members of the Okta group until they expire
+	granted, err := grantedMembers(ctx, fakeClient, production)
+	assert.NoError(t, err)
+	if assert.Len(t, granted, 1) {
+		assert.Equal(t, "john@corp.com", granted[0].Email)
+		assert.NotNil(t, granted[0].ExpiresAt)
+	}
+}
+
+func TestOktaAccessRequestReconciler_ExpiresRequests(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	expiresAt := metav1.NewTime(time.Now().Add(-time.Second))
+	accessRequest := newTestAccessRequest("approved", "john@corp.com")
+	accessRequest.Status = accessmanagerv1.OktaAccessRequestStatus{State: accessmanagerv1.OktaAccessRequestStateApproved, ExpiresAt: &expiresAt}
+	fakeClient := fake.NewClientBuilder().WithObjects(accessRequest).WithStatusSubresource(accessRequest).Build()
+	reconciler := &OktaAccessRequestReconciler{Client: fakeClient, Scheme: scheme.Scheme, Recorder: record.NewFakeRecorder(10)}
+
+	res, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(accessRequest)})
+	assert.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter)
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(accessRequest), accessRequest))
+	assert.Equal(t, accessmanagerv1.OktaAccessRequestStateExpired, accessRequest.Status.State)
+
+	// Requests for a group without approvers are denied
+	unapproved := newTestAccessRequest("unapproved", "john@corp.com")
+	fakeClient = fake.NewClientBuilder().
+		WithObjects(unapproved, &accessmanagerv1.OktaGroup{ObjectMeta: metav1.ObjectMeta{Name: "production"}}).
+		WithStatusSubresource(unapproved).Build()
+	reconciler.Client = fakeClient
+	_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(unapproved)})
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(unapproved), unapproved))
+	assert.Equal(t, accessmanagerv1.OktaAccessRequestStateDenied, unapproved.Status.State)
+	assert.Equal(t, "OktaGroup production has no approver group", unapproved.Status.Message)
+}
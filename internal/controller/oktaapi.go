@@ -0,0 +1,59 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+)
+
+// GroupAPI is the part of the Okta groups API used by the operator, implemented by
+// the Group resource of an okta.Client.
+type GroupAPI interface {
+	CreateGroup(ctx context.Context, body okta.Group) (*okta.Group, *okta.Response, error)
+	GetGroup(ctx context.Context, groupId string) (*okta.Group, *okta.Response, error)
+	UpdateGroup(ctx context.Context, groupId string, body okta.Group) (*okta.Group, *okta.Response, error)
+	DeleteGroup(ctx context.Context, groupId string) (*okta.Response, error)
+	ListGroups(ctx context.Context, qp *query.Params) ([]*okta.Group, *okta.Response, error)
+	ListGroupUsers(ctx context.Context, groupId string, qp *query.Params) ([]*okta.User, *okta.Response, error)
+	AddUserToGroup(ctx context.Context, groupId string, userId string) (*okta.Response, error)
+	RemoveUserFromGroup(ctx context.Context, groupId string, userId string) (*okta.Response, error)
+}
+
+// UserAPI is the part of the Okta users API used by the operator, implemented by
+// the User resource of an okta.Client.
+type UserAPI interface {
+	ListUsers(ctx context.Context, qp *query.Params) ([]*okta.User, *okta.Response, error)
+}
+
+var (
+	_ GroupAPI = &okta.GroupResource{}
+	_ UserAPI  = &okta.UserResource{}
+)
+
+// OktaAPI is the Okta API of an org, as used by OktaGroupManager.
+type OktaAPI struct {
+	Group GroupAPI
+	User  UserAPI
+}
+
+// NewOktaAPI returns the Okta API of a client.
+func NewOktaAPI(oktaClient *okta.Client) OktaAPI {
+	return OktaAPI{Group: oktaClient.Group, User: oktaClient.User}
+}
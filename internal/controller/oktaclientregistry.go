@@ -0,0 +1,364 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+// environmentOktaOrg is the registry key of the client built from the operator's
+// environment, used by OktaGroups that don't reference an OktaOrg.
+const environmentOktaOrg = ""
+
+// errSecretNamespaceForbidden is returned for an OktaOrg whose Secret isn't in the
+// namespace the operator reads the Secrets from.
+var errSecretNamespaceForbidden = errors.New("the operator doesn't read the Secrets of this namespace")
+
+// OktaClientRegistry hands out one shared Okta client per OktaOrg. It is owned by
+// the manager and watches the OktaOrgs and their Secrets, swapping in a new client
+// as soon as the credentials change so that token rotations don't need a restart.
+type OktaClientRegistry struct {
+	// SecretNamespace is the only namespace the Secrets of the OktaOrgs are read from,
+	// as the operator only caches the Secrets of that namespace. Empty allows any.
+	SecretNamespace string
+
+	client    client.Client
+	informers cache.Informers
+
+	mu      sync.RWMutex
+	clients map[string]*registeredOktaClient
+	// rateLimiters are the rate limiters of every org, kept across client rebuilds.
+	rateLimiters map[string]*oktaRateLimiter
+}
+
+type registeredOktaClient struct {
+	client *okta.Client
+	// oktaOrg is the OktaOrg the client was built from, nil for the environment client.
+	oktaOrg *accessmanagerv1.OktaOrg
+	// secret is the Secret the client was built from, empty for the environment client.
+	secret types.NamespacedName
+	// secretVersion is the resource version of the Secret the client was built from.
+	secretVersion string
+}
+
+// NewOktaClientRegistry creates a registry that reads OktaOrgs and Secrets with the given client.
+func NewOktaClientRegistry(k8sClient client.Client) *OktaClientRegistry {
+	return &OktaClientRegistry{
+		client:       k8sClient,
+		clients:      map[string]*registeredOktaClient{},
+		rateLimiters: map[string]*oktaRateLimiter{},
+	}
+}
+
+// SetupWithManager adds the registry to the Manager, so that it starts watching
+// OktaOrgs and Secrets together with the manager's caches.
+func (r *OktaClientRegistry) SetupWithManager(mgr ctrl.Manager) error {
+	r.informers = mgr.GetCache()
+	return mgr.Add(r)
+}
+
+// Start registers the OktaOrg and Secret event handlers, builds the client of every
+// existing OktaOrg so that invalid credentials are reported at startup instead of in
+// the middle of a reconcile, and blocks until the context is done.
+func (r *OktaClientRegistry) Start(ctx context.Context) error {
+	oktaOrgInformer, err := r.informers.GetInformer(ctx, &accessmanagerv1.OktaOrg{})
+	if err != nil {
+		return err
+	}
+	if _, err := oktaOrgInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj interface{}) { r.oktaOrgChanged(obj) },
+		DeleteFunc: func(obj interface{}) { r.oktaOrgChanged(obj) },
+	}); err != nil {
+		return err
+	}
+
+	secretInformer, err := r.informers.GetInformer(ctx, &corev1.Secret{})
+	if err != nil {
+		return err
+	}
+	if _, err := secretInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj interface{}) { r.secretUpdated(ctx, obj) },
+		DeleteFunc: func(obj interface{}) { r.secretDeleted(obj) },
+	}); err != nil {
+		return err
+	}
+
+	oktaOrgs := &accessmanagerv1.OktaOrgList{}
+	if err := r.client.List(ctx, oktaOrgs); err != nil {
+		return err
+	}
+	for _, oktaOrg := range oktaOrgs.Items {
+		if _, err := r.Client(ctx, oktaOrg.Name); err != nil {
+			log.Log.Error(err, "invalid Okta credentials", "oktaOrg", oktaOrg.Name)
+		}
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// Client returns the shared Okta client of the given OktaOrg, building it on first use.
+// An empty org name returns the client configured from the operator's environment.
+func (r *OktaClientRegistry) Client(ctx context.Context, orgName string) (*okta.Client, error) {
+	r.mu.RLock()
+	registered, ok := r.clients[orgName]
+	r.mu.RUnlock()
+	if ok {
+		return registered.client, nil
+	}
+
+	// Building while holding the lock orders the build against the Secret event
+	// handlers, so a rotation can't be missed between reading the Secret and
+	// registering the client.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if registered, ok := r.clients[orgName]; ok {
+		return registered.client, nil
+	}
+
+	registered, err := r.build(ctx, orgName)
+	if err != nil {
+		return nil, err
+	}
+	r.clients[orgName] = registered
+
+	return registered.client, nil
+}
+
+// Register sets the Okta client of the given OktaOrg instead of building it from
+// its credentials, e.g. to point the operator at a fake Okta org in tests.
+func (r *OktaClientRegistry) Register(orgName string, oktaClient *okta.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[orgName] = &registeredOktaClient{client: oktaClient}
+}
+
+func (r *OktaClientRegistry) build(ctx context.Context, orgName string) (*registeredOktaClient, error) {
+	if orgName == environmentOktaOrg {
+		_, oktaClient, err := okta.NewClient(ctx, okta.WithCache(false), okta.WithHttpClientPtr(r.httpClient(orgName)))
+		if err != nil {
+			return nil, err
+		}
+		config := oktaClient.GetConfig().Okta.Client
+		if config.AuthorizationMode == string(accessmanagerv1.OktaAuthorizationModePrivateKey) {
+			if err := validateOktaScopes(config.Scopes); err != nil {
+				return nil, fmt.Errorf("invalid Okta client configuration in the environment: %w", err)
+			}
+		}
+		return &registeredOktaClient{client: oktaClient}, nil
+	}
+
+	oktaOrg := &accessmanagerv1.OktaOrg{}
+	if err := r.client.Get(ctx, types.NamespacedName{Name: orgName}, oktaOrg); err != nil {
+		return nil, fmt.Errorf("unable to fetch OktaOrg %q: %w", orgName, err)
+	}
+
+	secretRef := types.NamespacedName{Namespace: oktaOrg.Spec.SecretRef.Namespace, Name: oktaOrg.Spec.SecretRef.Name}
+	if r.SecretNamespace != "" && secretRef.Namespace != r.SecretNamespace {
+		return nil, fmt.Errorf("%w: the Secret %s of OktaOrg %q must be in the namespace %s",
+			errSecretNamespaceForbidden, secretRef, orgName, r.SecretNamespace)
+	}
+	secret := &corev1.Secret{}
+	if err := r.client.Get(ctx, secretRef, secret); err != nil {
+		return nil, fmt.Errorf("unable to fetch Secret %s of OktaOrg %q: %w", secretRef, orgName, err)
+	}
+
+	return r.buildFromSecret(ctx, oktaOrg, secret)
+}
+
+func (r *OktaClientRegistry) buildFromSecret(ctx context.Context, oktaOrg *accessmanagerv1.OktaOrg, secret *corev1.Secret) (*registeredOktaClient, error) {
+	config, err := oktaClientConfig(oktaOrg, secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials of OktaOrg %q: %w", oktaOrg.Name, err)
+	}
+
+	_, oktaClient, err := okta.NewClient(ctx, append(config, okta.WithHttpClientPtr(r.httpClient(oktaOrg.Name)))...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Okta client for OktaOrg %q: %w", oktaOrg.Name, err)
+	}
+
+	return &registeredOktaClient{
+		client:        oktaClient,
+		oktaOrg:       oktaOrg,
+		secret:        client.ObjectKeyFromObject(secret),
+		secretVersion: secret.ResourceVersion,
+	}, nil
+}
+
+// credentialsReason returns the reason of the CredentialsValid condition of an
+// OktaGroup whose Okta client can't be built.
+func credentialsReason(err error) string {
+	if errors.Is(err, errSecretNamespaceForbidden) {
+		return accessmanagerv1.OktaGroupReasonSecretNamespaceForbidden
+	}
+	return accessmanagerv1.OktaGroupReasonCredentialsInvalid
+}
+
+// httpClient returns a new HTTP client for the org, throttled by the rate limiter
+// shared by all its clients. The caller must hold the write lock.
+func (r *OktaClientRegistry) httpClient(orgName string) *http.Client {
+	limiter, ok := r.rateLimiters[orgName]
+	if !ok {
+		limiter = newOktaRateLimiter()
+		r.rateLimiters[orgName] = limiter
+	}
+	return newRateLimitedHTTPClient(orgName, limiter)
+}
+
+// oktaClientConfig returns the Okta client configuration of the OktaOrg, reading
+// its credentials from the given Secret.
+func oktaClientConfig(oktaOrg *accessmanagerv1.OktaOrg, secret *corev1.Secret) ([]okta.ConfigSetter, error) {
+	orgURL, err := secretValue(secret, accessmanagerv1.OktaOrgSecretOrgURLKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := []okta.ConfigSetter{
+		okta.WithCache(false),
+		okta.WithOrgUrl(orgURL),
+	}
+
+	switch oktaOrg.Spec.AuthorizationMode {
+	case accessmanagerv1.OktaAuthorizationModeSSWS, "":
+		token, err := secretValue(secret, accessmanagerv1.OktaOrgSecretTokenKey)
+		if err != nil {
+			return nil, err
+		}
+		config = append(config,
+			okta.WithAuthorizationMode(string(accessmanagerv1.OktaAuthorizationModeSSWS)),
+			okta.WithToken(token),
+		)
+
+	case accessmanagerv1.OktaAuthorizationModePrivateKey:
+		if oktaOrg.Spec.ClientID == "" {
+			return nil, errors.New("the PrivateKey authorization mode requires a clientId")
+		}
+		if err := validateOktaScopes(oktaOrg.Spec.Scopes); err != nil {
+			return nil, err
+		}
+		privateKey, err := secretValue(secret, accessmanagerv1.OktaOrgSecretPrivateKeyKey)
+		if err != nil {
+			return nil, err
+		}
+		// Parse the key up front, the SDK would otherwise only do it on the first API call
+		signer, err := okta.CreateKeySigner(privateKey, string(secret.Data[accessmanagerv1.OktaOrgSecretPrivateKeyIDKey]))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the private key of Secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+		config = append(config,
+			okta.WithAuthorizationMode(string(accessmanagerv1.OktaAuthorizationModePrivateKey)),
+			okta.WithClientId(oktaOrg.Spec.ClientID),
+			okta.WithScopes(oktaOrg.Spec.Scopes),
+			okta.WithPrivateKeySigner(signer),
+		)
+
+	default:
+		return nil, fmt.Errorf("unsupported authorization mode %q", oktaOrg.Spec.AuthorizationMode)
+	}
+
+	return config, nil
+}
+
+// validateOktaScopes checks that the requested scopes include every scope the
+// operator needs, so that a missing one isn't discovered through a 403 response.
+func validateOktaScopes(scopes []string) error {
+	var missing []string
+	for _, required := range accessmanagerv1.RequiredOktaScopes {
+		if !contains(scopes, required) {
+			missing = append(missing, required)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required scopes: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// oktaOrgChanged drops the client of an updated or deleted OktaOrg, it is rebuilt
+// from the new spec on its next use.
+func (r *OktaClientRegistry) oktaOrgChanged(obj interface{}) {
+	oktaOrg, ok := unwrapDeleted(obj).(*accessmanagerv1.OktaOrg)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.clients, oktaOrg.Name)
+}
+
+// secretUpdated swaps in a new client for every OktaOrg backed by the updated
+// Secret. If the new credentials are unusable the client is dropped, so that the
+// next reconcile reports the error.
+func (r *OktaClientRegistry) secretUpdated(ctx context.Context, obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for orgName, registered := range r.clients {
+		if registered.secret != client.ObjectKeyFromObject(secret) || registered.secretVersion == secret.ResourceVersion {
+			continue
+		}
+
+		rebuilt, err := r.buildFromSecret(ctx, registered.oktaOrg, secret)
+		if err != nil {
+			log.Log.Error(err, "unable to rebuild Okta client after its Secret changed", "oktaOrg", orgName)
+			delete(r.clients, orgName)
+			continue
+		}
+		r.clients[orgName] = rebuilt
+		log.Log.Info("Rebuilt Okta client after its Secret changed", "oktaOrg", orgName, "secret", rebuilt.secret)
+	}
+}
+
+// secretDeleted drops the clients of every OktaOrg backed by the deleted Secret.
+func (r *OktaClientRegistry) secretDeleted(obj interface{}) {
+	secret, ok := unwrapDeleted(obj).(*corev1.Secret)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for orgName, registered := range r.clients {
+		if registered.secret == client.ObjectKeyFromObject(secret) {
+			delete(r.clients, orgName)
+		}
+	}
+}
+
+func unwrapDeleted(obj interface{}) interface{} {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
+
+func secretValue(secret *corev1.Secret, key string) (string, error) {
+	value, ok := secret.Data[key]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("secret %s/%s has no %q key", secret.Namespace, secret.Name, key)
+	}
+	return string(value), nil
+}
@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+func newTestOktaOrg(name string, secret *corev1.Secret) *accessmanagerv1.OktaOrg {
+	return &accessmanagerv1.OktaOrg{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: accessmanagerv1.OktaOrgSpec{
+			SecretRef: accessmanagerv1.SecretReference{
+				Name:      secret.Name,
+				Namespace: secret.Namespace,
+			},
+		},
+	}
+}
+
+func newTestOktaSecret(name, orgURL, token string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "access-manager-operator"},
+		Data: map[string][]byte{
+			accessmanagerv1.OktaOrgSecretOrgURLKey: []byte(orgURL),
+			accessmanagerv1.OktaOrgSecretTokenKey:  []byte(token),
+		},
+	}
+}
+
+func TestOktaClientRegistry_SharesOneClientPerOrg(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	prodSecret := newTestOktaSecret("okta-prod", "https://prod.okta.com", "prod-token")
+	previewSecret := newTestOktaSecret("okta-preview", "https://preview.oktapreview.com", "preview-token")
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		prodSecret, previewSecret,
+		newTestOktaOrg("prod", prodSecret),
+		newTestOktaOrg("preview", previewSecret),
+	).Build()
+
+	registry := NewOktaClientRegistry(fakeClient)
+
+	prodClient, err := registry.Client(ctx, "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://prod.okta.com", prodClient.GetConfig().Okta.Client.OrgUrl)
+	assert.Equal(t, "prod-token", prodClient.GetConfig().Okta.Client.Token)
+
+	previewClient, err := registry.Client(ctx, "preview")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://preview.oktapreview.com", previewClient.GetConfig().Okta.Client.OrgUrl)
+
+	// Every reconcile of the same org gets the same client
+	sharedClient, err := registry.Client(ctx, "prod")
+	assert.NoError(t, err)
+	assert.Same(t, prodClient, sharedClient)
+}
+
+func TestOktaClientRegistry_RotatesClientWhenSecretChanges(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	prodSecret := newTestOktaSecret("okta-prod", "https://prod.okta.com", "prod-token")
+	prodOrg := newTestOktaOrg("prod", prodSecret)
+	fakeClient := fake.NewClientBuilder().WithObjects(prodSecret, prodOrg).Build()
+
+	registry := NewOktaClientRegistry(fakeClient)
+
+	prodClient, err := registry.Client(ctx, "prod")
+	assert.NoError(t, err)
+
+	// Resyncs of an unchanged Secret keep the client
+	registry.secretUpdated(ctx, prodSecret)
+	sameClient, err := registry.Client(ctx, "prod")
+	assert.NoError(t, err)
+	assert.Same(t, prodClient, sameClient)
+
+	// Rotating the token swaps in a new client
+	prodSecret.Data[accessmanagerv1.OktaOrgSecretTokenKey] = []byte("rotated-token")
+	assert.NoError(t, fakeClient.Update(ctx, prodSecret))
+	registry.secretUpdated(ctx, prodSecret)
+
+	rotatedClient, err := registry.Client(ctx, "prod")
+	assert.NoError(t, err)
+	assert.NotSame(t, prodClient, rotatedClient)
+	assert.Equal(t, "rotated-token", rotatedClient.GetConfig().Okta.Client.Token)
+
+	// Unusable credentials drop the client so that the next reconcile reports the error
+	delete(prodSecret.Data, accessmanagerv1.OktaOrgSecretTokenKey)
+	assert.NoError(t, fakeClient.Update(ctx, prodSecret))
+	registry.secretUpdated(ctx, prodSecret)
+
+	_, err = registry.Client(ctx, "prod")
+	assert.ErrorContains(t, err, `has no "client-token" key`)
+
+	// Pointing the OktaOrg at another Secret rebuilds the client from it
+	newSecret := newTestOktaSecret("okta-prod-v2", "https://prod.okta.com", "new-secret-token")
+	assert.NoError(t, fakeClient.Create(ctx, newSecret))
+	prodOrg.Spec.SecretRef.Name = newSecret.Name
+	assert.NoError(t, fakeClient.Update(ctx, prodOrg))
+	registry.oktaOrgChanged(prodOrg)
+
+	newClient, err := registry.Client(ctx, "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "new-secret-token", newClient.GetConfig().Okta.Client.Token)
+
+	// Deleting the Secret drops the client
+	registry.secretDeleted(newSecret)
+	assert.NoError(t, fakeClient.Delete(ctx, newSecret))
+
+	_, err = registry.Client(ctx, "prod")
+	assert.ErrorContains(t, err, "unable to fetch Secret access-manager-operator/okta-prod-v2")
+}
+
+func TestOktaClientRegistry_Errors(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	incompleteSecret := newTestOktaSecret("okta-incomplete", "https://prod.okta.com", "")
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		incompleteSecret,
+		newTestOktaOrg("incomplete", incompleteSecret),
+		newTestOktaOrg("no-secret", newTestOktaSecret("missing", "", "")),
+	).Build()
+
+	registry := NewOktaClientRegistry(fakeClient)
+
+	_, err := registry.Client(ctx, "unknown")
+	assert.ErrorContains(t, err, `unable to fetch OktaOrg "unknown"`)
+
+	_, err = registry.Client(ctx, "no-secret")
+	assert.ErrorContains(t, err, "unable to fetch Secret access-manager-operator/missing")
+
+	_, err = registry.Client(ctx, "incomplete")
+	assert.ErrorContains(t, err, `has no "client-token" key`)
+
+	// The Secrets of other namespaces aren't cached, so they aren't read at all
+	registry = NewOktaClientRegistry(fakeClient)
+	registry.SecretNamespace = "access-manager-operator-system"
+	_, err = registry.Client(ctx, "incomplete")
+	assert.ErrorIs(t, err, errSecretNamespaceForbidden)
+	assert.ErrorContains(t, err, `the Secret access-manager-operator/okta-incomplete of OktaOrg "incomplete" must be in the namespace access-manager-operator-system`)
+	assert.Equal(t, accessmanagerv1.OktaGroupReasonSecretNamespaceForbidden, credentialsReason(err))
+}
+
+func newTestPrivateKeyPEM(t *testing.T) []byte {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+}
+
+func TestOktaClientRegistry_PrivateKeyAuthorization(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "okta-service-app", Namespace: "access-manager-operator"},
+		Data: map[string][]byte{
+			accessmanagerv1.OktaOrgSecretOrgURLKey:       []byte("https://prod.okta.com"),
+			accessmanagerv1.OktaOrgSecretPrivateKeyKey:   newTestPrivateKeyPEM(t),
+			accessmanagerv1.OktaOrgSecretPrivateKeyIDKey: []byte("kid-1"),
+		},
+	}
+	serviceAppOrg := newTestOktaOrg("service-app", secret)
+	serviceAppOrg.Spec.AuthorizationMode = accessmanagerv1.OktaAuthorizationModePrivateKey
+	serviceAppOrg.Spec.ClientID = "0oa-service-app"
+	serviceAppOrg.Spec.Scopes = []string{"okta.groups.manage", "okta.users.read"}
+
+	missingScopeOrg := serviceAppOrg.DeepCopy()
+	missingScopeOrg.Name = "missing-scope"
+	missingScopeOrg.Spec.Scopes = []string{"okta.groups.manage"}
+
+	missingClientIDOrg := serviceAppOrg.DeepCopy()
+	missingClientIDOrg.Name = "missing-client-id"
+	missingClientIDOrg.Spec.ClientID = ""
+
+	invalidKeySecret := secret.DeepCopy()
+	invalidKeySecret.Name = "okta-invalid-key"
+	invalidKeySecret.Data[accessmanagerv1.OktaOrgSecretPrivateKeyKey] = []byte("not a key")
+	invalidKeyOrg := serviceAppOrg.DeepCopy()
+	invalidKeyOrg.Name = "invalid-key"
+	invalidKeyOrg.Spec.SecretRef.Name = invalidKeySecret.Name
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		secret, invalidKeySecret, serviceAppOrg, missingScopeOrg, missingClientIDOrg, invalidKeyOrg,
+	).Build()
+
+	registry := NewOktaClientRegistry(fakeClient)
+
+	serviceAppClient, err := registry.Client(ctx, "service-app")
+	assert.NoError(t, err)
+	config := serviceAppClient.GetConfig()
+	assert.Equal(t, "PrivateKey", config.Okta.Client.AuthorizationMode)
+	assert.Equal(t, "0oa-service-app", config.Okta.Client.ClientId)
+	assert.Equal(t, []string{"okta.groups.manage", "okta.users.read"}, config.Okta.Client.Scopes)
+	assert.NotNil(t, config.PrivateKeySigner)
+
+	_, err = registry.Client(ctx, "missing-scope")
+	assert.ErrorContains(t, err, `invalid credentials of OktaOrg "missing-scope": missing required scopes: okta.users.read`)
+
+	_, err = registry.Client(ctx, "missing-client-id")
+	assert.ErrorContains(t, err, "the PrivateKey authorization mode requires a clientId")
+
+	_, err = registry.Client(ctx, "invalid-key")
+	assert.ErrorContains(t, err, "unable to parse the private key of Secret access-manager-operator/okta-invalid-key")
+}
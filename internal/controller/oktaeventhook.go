@@ -0,0 +1,296 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+const (
+	// oktaGroupIDIndex indexes the OktaGroups by the ID of their Okta group.
+	oktaGroupIDIndex = "status.id"
+	// oktaGroupUserIndex indexes the OktaGroups by the Okta user IDs and the lowercase
+	// logins and emails of their members.
+	oktaGroupUserIndex = "status.members"
+
+	// OktaEventHookPath is the path of the event hook of the environment's org, the
+	// event hook of an OktaOrg is served under OktaEventHookPath/<OktaOrg name>.
+	OktaEventHookPath = "/event-hook"
+	// oktaVerificationChallengeHeader is the header of the one-time verification
+	// request Okta sends when the event hook is registered.
+	oktaVerificationChallengeHeader = "X-Okta-Verification-Challenge"
+	// maxOktaEventHookBodySize is the maximum size of an event hook request, Okta
+	// sends at most 50 events per request.
+	maxOktaEventHookBodySize = 1 << 20
+
+	// OktaEventHookLeaderLabel labels the pod of the operator while it serves the
+	// event hooks, so that the Service in front of them only selects the leader.
+	OktaEventHookLeaderLabel = "access-manager.github.com/event-hook-leader"
+)
+
+// OktaEventHookServer receives the Event Hooks of the Okta orgs, and triggers the
+// reconcile of the OktaGroups affected by each event so that changes made in Okta
+// are reverted within seconds instead of at the next resync.
+type OktaEventHookServer struct {
+	client client.Client
+
+	// BindAddress is the address the event hook endpoint listens on.
+	BindAddress string
+	// AuthHeader is the header Okta sends the shared secret in.
+	AuthHeader string
+	// Secret is the shared secret configured in the event hooks of Okta.
+	Secret string
+	// OktaUsers is the user resolver whose cached users are dropped when they change in Okta.
+	OktaUsers *OktaUserResolver
+	// Pod is the pod of the operator, labeled with OktaEventHookLeaderLabel while it
+	// serves the event hooks. The pod isn't labeled without it.
+	Pod types.NamespacedName
+
+	events chan event.GenericEvent
+}
+
+// oktaEventHookRequest is the body of an event hook request.
+type oktaEventHookRequest struct {
+	Data struct {
+		Events []oktaEvent `json:"events"`
+	} `json:"data"`
+}
+
+type oktaEvent struct {
+	EventType string            `json:"eventType"`
+	Target    []oktaEventTarget `json:"target"`
+}
+
+type oktaEventTarget struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	AlternateID string `json:"alternateId"`
+}
+
+// NewOktaEventHookServer creates an event hook server that looks up the OktaGroups
+// with the given client.
+func NewOktaEventHookServer(k8sClient client.Client) *OktaEventHookServer {
+	return &OktaEventHookServer{
+		client: k8sClient,
+		events: make(chan event.GenericEvent, 100),
+	}
+}
+
+// Events returns the channel the OktaGroups affected by the events are sent to.
+func (s *OktaEventHookServer) Events() <-chan event.GenericEvent {
+	return s.events
+}
+
+// SetupWithManager indexes the OktaGroups by Okta group and user, and adds the
+// server to the Manager. The pod starts without the leader label, which it can
+// still have if its container restarted after losing the leadership.
+func (s *OktaEventHookServer) SetupWithManager(mgr ctrl.Manager) error {
+	ctx := context.Background()
+	if err := s.labelPod(ctx, false); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &accessmanagerv1.OktaGroup{}, oktaGroupIDIndex, indexOktaGroupID); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &accessmanagerv1.OktaGroup{}, oktaGroupUserIndex, indexOktaGroupUsers); err != nil {
+		return err
+	}
+	return mgr.Add(s)
+}
+
+func indexOktaGroupID(obj client.Object) []string {
+	oktaGroup := obj.(*accessmanagerv1.OktaGroup)
+	if oktaGroup.Status.Id == "" {
+		return nil
+	}
+	return []string{oktaGroup.Status.Id}
+}
+
+func indexOktaGroupUsers(obj client.Object) []string {
+	oktaGroup := obj.(*accessmanagerv1.OktaGroup)
+	var keys []string
+	for _, member := range oktaGroup.Status.Members {
+		if member.Email != "" {
+			keys = append(keys, strings.ToLower(member.Email))
+		}
+		if member.Login != "" {
+			keys = append(keys, strings.ToLower(member.Login))
+		}
+		if member.UserID != "" {
+			keys = append(keys, member.UserID)
+		}
+	}
+	return keys
+}
+
+// NeedLeaderElection makes only the leader receive events, as only its controller
+// consumes them.
+func (s *OktaEventHookServer) NeedLeaderElection() bool {
+	return true
+}
+
+//+kubebuilder:rbac:groups="",namespace=system,resources=pods,verbs=patch
+
+// labelPod adds the leader label to the pod of the operator, or removes it.
+func (s *OktaEventHookServer) labelPod(ctx context.Context, leader bool) error {
+	if s.Pod.Name == "" {
+		return nil
+	}
+	value := "null"
+	if leader {
+		value = `"true"`
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: s.Pod.Namespace, Name: s.Pod.Name}}
+	patch := fmt.Sprintf(`{"metadata": {"labels": {%q: %s}}}`, OktaEventHookLeaderLabel, value)
+	if err := s.client.Patch(ctx, pod, client.RawPatch(types.MergePatchType, []byte(patch))); err != nil {
+		return fmt.Errorf("unable to label pod %s as event hook leader: %w", s.Pod, err)
+	}
+	return nil
+}
+
+// Start serves the event hook endpoint until the context is done.
+func (s *OktaEventHookServer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(OktaEventHookPath, s)
+	mux.Handle(OktaEventHookPath+"/", s)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	listener, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return err
+	}
+	// The Service of the event hooks only selects the pod once it listens
+	if err := s.labelPod(ctx, true); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Log.Error(err, "unable to shut down the Okta event hook server")
+		}
+	}()
+
+	log.Log.Info("Serving Okta event hooks", "address", listener.Addr().String())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP answers the verification challenge of the event hooks and enqueues the
+// OktaGroups affected by the events of the org named by the path.
+func (s *OktaEventHookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(s.AuthHeader)), []byte(s.Secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	orgName := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, OktaEventHookPath), "/")
+
+	switch r.Method {
+	case http.MethodGet:
+		// One-time verification of the event hook
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"verification": r.Header.Get(oktaVerificationChallengeHeader)})
+
+	case http.MethodPost:
+		request := &oktaEventHookRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOktaEventHookBodySize)).Decode(request); err != nil {
+			http.Error(w, "invalid event hook request", http.StatusBadRequest)
+			return
+		}
+		changed := map[string]*accessmanagerv1.OktaGroup{}
+		for _, oktaEvent := range request.Data.Events {
+			if err := s.changedOktaGroups(r.Context(), orgName, oktaEvent, changed); err != nil {
+				log.Log.Error(err, "unable to handle Okta event", "oktaOrg", orgName, "eventType", oktaEvent.EventType)
+				http.Error(w, "unable to handle event", http.StatusInternalServerError)
+				return
+			}
+		}
+		for _, oktaGroup := range changed {
+			select {
+			case s.events <- event.GenericEvent{Object: oktaGroup}:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		// Okta only waits 3 seconds for the response, the reconciles happen after it
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// changedOktaGroups adds the OktaGroups of the org affected by an event to changed.
+func (s *OktaEventHookServer) changedOktaGroups(ctx context.Context, orgName string, oktaEvent oktaEvent, changed map[string]*accessmanagerv1.OktaGroup) error {
+	var keys []client.MatchingFields
+
+	switch {
+	case oktaEvent.EventType == "group.user_membership.add",
+		oktaEvent.EventType == "group.user_membership.remove",
+		oktaEvent.EventType == "group.profile.updated":
+		for _, target := range oktaEvent.Target {
+			if target.Type == "UserGroup" {
+				keys = append(keys, client.MatchingFields{oktaGroupIDIndex: target.ID})
+			}
+		}
+
+	case strings.HasPrefix(oktaEvent.EventType, "user.lifecycle."):
+		// The user changed, so its status and email must be looked up again by the
+		// groups it is a member of, or by the ones waiting for it to be created
+		for _, target := range oktaEvent.Target {
+			if target.Type != "User" {
+				continue
+			}
+			if s.OktaUsers != nil {
+				s.OktaUsers.InvalidateUser(orgName, target.ID)
+				s.OktaUsers.Invalidate(orgName, target.AlternateID)
+			}
+			keys = append(keys, client.MatchingFields{oktaGroupUserIndex: target.ID})
+			if target.AlternateID != "" {
+				keys = append(keys, client.MatchingFields{oktaGroupUserIndex: strings.ToLower(target.AlternateID)})
+			}
+		}
+	}
+
+	for _, key := range keys {
+		oktaGroups := &accessmanagerv1.OktaGroupList{}
+		if err := s.client.List(ctx, oktaGroups, key); err != nil {
+			return err
+		}
+		for i := range oktaGroups.Items {
+			oktaGroup := &oktaGroups.Items[i]
+			if oktaGroup.Spec.OktaOrgRef != orgName {
+				continue
+			}
+			if _, ok := changed[oktaGroup.Name]; !ok {
+				log.Log.Info("Okta event changed OktaGroup", "oktaGroup", oktaGroup.Name, "eventType", oktaEvent.EventType)
+			}
+			changed[oktaGroup.Name] = oktaGroup
+		}
+	}
+	return nil
+}
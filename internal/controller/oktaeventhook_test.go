@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+func newTestOktaEventHookServer(objects ...*accessmanagerv1.OktaGroup) *OktaEventHookServer {
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	builder := fake.NewClientBuilder().
+		WithIndex(&accessmanagerv1.OktaGroup{}, oktaGroupIDIndex, indexOktaGroupID).
+		WithIndex(&accessmanagerv1.OktaGroup{}, oktaGroupUserIndex, indexOktaGroupUsers)
+	for _, obj := range objects {
+		builder = builder.WithObjects(obj)
+	}
+
+	server := NewOktaEventHookServer(builder.Build())
+	server.AuthHeader = "Authorization"
+	server.Secret = "s3cr3t"
+	server.OktaUsers = NewOktaUserResolver(time.Hour)
+	return server
+}
+
+func newTestOktaGroupWithMembers(name, orgName, groupID string, members ...accessmanagerv1.OktaGroupMemberStatus) *accessmanagerv1.OktaGroup {
+	return &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       accessmanagerv1.OktaGroupSpec{OktaOrgRef: orgName},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: groupID, Members: members},
+	}
+}
+
+func postTestOktaEvents(server *OktaEventHookServer, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"data": {"events": [`+body+`]}}`))
+	req.Header.Set("Authorization", "s3cr3t")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
+
+func receivedOktaGroups(server *OktaEventHookServer) []string {
+	var names []string
+	for {
+		select {
+		case evt := <-server.Events():
+			names = append(names, evt.Object.GetName())
+		default:
+			return names
+		}
+	}
+}
+
+func TestOktaEventHookServer_Verification(t *testing.T) {
+	server := newTestOktaEventHookServer()
+
+	req := httptest.NewRequest(http.MethodGet, OktaEventHookPath+"/prod", nil)
+	req.Header.Set("Authorization", "s3cr3t")
+	req.Header.Set(oktaVerificationChallengeHeader, "challenge")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"verification": "challenge"}`, rec.Body.String())
+
+	req.Header.Set("Authorization", "wrong")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestOktaEventHookServer_RejectsWrongSecret(t *testing.T) {
+	server := newTestOktaEventHookServer(newTestOktaGroupWithMembers("team-a", "", "00g1"))
+
+	for _, secret := range []string{"wrong", ""} {
+		req := httptest.NewRequest(http.MethodPost, OktaEventHookPath, strings.NewReader(
+			`{"data": {"events": [{"eventType": "group.profile.updated", "target": [{"id": "00g1", "type": "UserGroup"}]}]}}`))
+		req.Header.Set("Authorization", secret)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, receivedOktaGroups(server))
+	}
+
+	rec := postTestOktaEvents(server, OktaEventHookPath, `{
+		"eventType": "group.profile.updated",
+		"target": [{"id": "00g1", "type": "UserGroup"}]
+	}`)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"team-a"}, receivedOktaGroups(server))
+}
+
+func TestOktaEventHookServer_LabelsLeaderPod(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "system",
+		Name:      "manager-0",
+		Labels:    map[string]string{"control-plane": "controller-manager", OktaEventHookLeaderLabel: "true"},
+	}}
+	k8sClient := fake.NewClientBuilder().WithObjects(pod).Build()
+	server := NewOktaEventHookServer(k8sClient)
+	server.BindAddress = "127.0.0.1:0"
+	server.Pod = types.NamespacedName{Namespace: "system", Name: "manager-0"}
+
+	leaderLabel := func() string {
+		if err := k8sClient.Get(context.Background(), server.Pod, pod); err != nil {
+			t.Fatal(err)
+		}
+		return pod.Labels[OktaEventHookLeaderLabel]
+	}
+
+	// A restarted pod doesn't keep the label of its former leadership
+	if err := server.labelPod(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, leaderLabel())
+	assert.Equal(t, "controller-manager", pod.Labels["control-plane"])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.Start(ctx) }()
+	assert.Eventually(t, func() bool { return leaderLabel() == "true" }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestOktaEventHookServer_EnqueuesChangedGroups(t *testing.T) {
+	server := newTestOktaEventHookServer(
+		newTestOktaGroupWithMembers("developers", "prod", "00g1"),
+		newTestOktaGroupWithMembers("admins", "prod", "00g2"),
+		newTestOktaGroupWithMembers("preview-developers", "preview", "00g1"),
+	)
+
+	rec := postTestOktaEvents(server, OktaEventHookPath+"/prod", `{
+		"eventType": "group.user_membership.add",
+		"target": [{"id": "00u1", "type": "User"}, {"id": "00g1", "type": "UserGroup"}]
+	}, {
+		"eventType": "group.profile.updated",
+		"target": [{"id": "00g1", "type": "UserGroup"}]
+	}, {
+		"eventType": "application.lifecycle.update",
+		"target": [{"id": "00g2", "type": "UserGroup"}]
+	}`)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"developers"}, receivedOktaGroups(server))
+
+	rec = postTestOktaEvents(server, OktaEventHookPath+"/preview", `{
+		"eventType": "group.user_membership.remove",
+		"target": [{"id": "00g1", "type": "UserGroup"}]
+	}`)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, []string{"preview-developers"}, receivedOktaGroups(server))
+}
+
+func TestOktaEventHookServer_UserLifecycle(t *testing.T) {
+	server := newTestOktaEventHookServer(
+		newTestOktaGroupWithMembers("developers", "", "00g1",
+			accessmanagerv1.OktaGroupMemberStatus{Email: "john@example.com", UserID: "00u1", State: accessmanagerv1.OktaGroupMemberStateMember}),
+		newTestOktaGroupWithMembers("admins", "", "00g2",
+			accessmanagerv1.OktaGroupMemberStatus{Email: "Jane@example.com", State: accessmanagerv1.OktaGroupMemberStateNotFound}),
+		newTestOktaGroupWithMembers("readers", "", "00g3"),
+	)
+	server.OktaUsers.Seed(environmentOktaOrg, []*okta.User{{Id: "00u1", Profile: &okta.UserProfile{"email": "john@example.com"}}})
+
+	rec := postTestOktaEvents(server, OktaEventHookPath, `{
+		"eventType": "user.lifecycle.suspend",
+		"target": [{"id": "00u1", "type": "User", "alternateId": "john@example.com"}]
+	}, {
+		"eventType": "user.lifecycle.create",
+		"target": [{"id": "00u2", "type": "User", "alternateId": "jane@example.com"}]
+	}`)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.ElementsMatch(t, []string{"developers", "admins"}, receivedOktaGroups(server))
+	assert.Empty(t, server.OktaUsers.users)
+}
@@ -18,28 +18,91 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlbuilder "sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
-	"github.com/okta/okta-sdk-golang/v2/okta"
 )
 
 // OktaGroupReconciler reconciles a OktaGroup object
 type OktaGroupReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// OktaClients hands out the shared Okta client of each OktaOrg.
+	OktaClients *OktaClientRegistry
+
+	// Recorder emits an event on the OktaGroup for every change made in Okta.
+	Recorder record.EventRecorder
+
+	// OktaPageSize is the number of items requested per page when listing Okta
+	// users and groups, 0 uses Okta's default.
+	OktaPageSize int64
+
+	// OktaUsers resolves the emails of every OktaGroup to Okta users, caching them
+	// across reconciles.
+	OktaUsers *OktaUserResolver
+
+	// ResyncInterval is how often every OktaGroup is synced again to revert the
+	// changes made outside of the operator, 0 disables the resync. It can be
+	// overridden by the spec of each OktaGroup.
+	ResyncInterval time.Duration
+
+	// DeletionPolicy is what happens to the Okta group of an OktaGroup that is deleted,
+	// unless its spec sets one. It defaults to Delete.
+	DeletionPolicy accessmanagerv1.OktaGroupDeletionPolicy
+
+	// ManagerID marks the Okta groups managed by this instance of the operator.
+	ManagerID string
+
+	// OIDCGroupsPrefix is prepended to the names of the Okta groups in the subjects
+	// of their RoleBindings, like the --oidc-groups-prefix of the kube-apiserver.
+	OIDCGroupsPrefix string
+
+	// RolesAuthorized is true when the validating webhook checks that whoever edits
+	// an OktaGroup may bind the roles of its kubernetesRBAC. Otherwise anyone editing
+	// OktaGroups could bind any role through the operator, so the roles aren't bound.
+	RolesAuthorized bool
+
+	// DryRun computes the changes to the Okta groups without making them, unless
+	// the dry-run annotation of an OktaGroup says otherwise.
+	DryRun bool
+
+	// OktaEvents receives the OktaGroups changed in Okta, as reported by its event
+	// hooks. It is optional.
+	OktaEvents <-chan event.GenericEvent
 }
 
 //+kubebuilder:rbac:groups=access-manager.github.com,resources=oktagroups,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=access-manager.github.com,resources=oktagroups/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=access-manager.github.com,resources=oktagroups/finalizers,verbs=update
+//+kubebuilder:rbac:groups=access-manager.github.com,resources=oktaorgs,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",namespace=system,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 const (
 	ConstOktaGroupFinalizer = "franciscoprin.access-manager-operator.finalizer"
@@ -55,23 +118,49 @@ const (
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.0/pkg/reconcile
 func (r *OktaGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracer.Start(ctx, "OktaGroupReconciler.Reconcile", trace.WithAttributes(oktaGroupKey.String(req.Name)))
+	result, err := r.reconcile(ctx, req)
+	endSpan(span, err)
+	return result, err
+}
 
+// reconcile syncs an OktaGroup within the span of its reconcile.
+func (r *OktaGroupReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	// Get Okta group object
 	oktaGroupCRD := &accessmanagerv1.OktaGroup{}
 	if err := r.Get(ctx, req.NamespacedName, oktaGroupCRD); err != nil {
 		log.Log.Error(err, "unable to fetch OktaGroup")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	trace.SpanFromContext(ctx).SetAttributes(oktaOrgKey.String(oktaGroupCRD.Spec.OktaOrgRef))
 
-	// Create the Okta client
-	_, oktaClient, err := okta.NewClient(ctx, okta.WithCache(false))
+	// Get the shared Okta client of the org the group belongs to
+	oktaClient, err := r.OktaClients.Client(ctx, oktaGroupCRD.Spec.OktaOrgRef)
 	if err != nil {
 		log.Log.Error(err, "unable to create Okta client")
+		return r.failReconcile(ctx, oktaGroupCRD, accessmanagerv1.OktaGroupConditionCredentialsValid, credentialsReason(err), err)
+	}
+
+	// Get the members granted by the approved access requests
+	granted, err := grantedMembers(ctx, r.Client, oktaGroupCRD)
+	if err != nil {
+		log.Log.Error(err, "unable to list OktaAccessRequests")
 		return ctrl.Result{}, err
 	}
 
+	// Get the members of the included and excluded OktaGroups, which the deletion of
+	// the OktaGroup doesn't need
+	composition := &OktaGroupComposition{}
+	if oktaGroupCRD.ObjectMeta.DeletionTimestamp.IsZero() {
+		if composition, err = composeMembers(ctx, r.Client, oktaGroupCRD); err != nil {
+			log.Log.Error(err, "unable to compose the members of the OktaGroup")
+			return r.failReconcile(ctx, oktaGroupCRD, accessmanagerv1.OktaGroupConditionMembersSynced, compositionReason(err), err)
+		}
+	}
+
 	// Set up the OktaGroup manager
-	oktaManager, err := NewOktaGroupManager(ctx, oktaGroupCRD, oktaClient)
+	dryRun := r.dryRun(oktaGroupCRD)
+	oktaManager, err := NewOktaGroupManager(ctx, oktaGroupCRD, NewOktaAPI(oktaClient), r.Recorder, WithPageSize(r.OktaPageSize), WithUserResolver(r.OktaUsers), WithManagerID(r.ManagerID), WithGrantedMembers(granted), WithComposition(composition), WithDryRun(dryRun))
 	if err != nil {
 		log.Log.Error(err, "unable to create OktaGroup manager")
 		return ctrl.Result{}, err
@@ -91,13 +180,26 @@ func (r *OktaGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	} else {
 		// The object is being deleted
 		if controllerutil.ContainsFinalizer(oktaGroupCRD, ConstOktaGroupFinalizer) {
-			// Delete the Okta group. If the deletion fails, don't remove the finalizer so
+			// Delete or orphan the Okta group. If it fails, don't remove the finalizer so
 			// that we can retry during the next reconciliation.
-			if err := oktaManager.DeleteOktaGroup(); err != nil {
-				log.Log.Error(err, "unable to delete OktaGroupAPI")
+			if err := r.finalizeOktaGroup(oktaManager, oktaGroupCRD); err != nil {
+				log.Log.Error(err, "unable to finalize OktaGroupAPI")
 				return ctrl.Result{}, err
 			}
 
+			// In dry-run the finalizer stays until the planned deletion or orphaning
+			// of the Okta group is made
+			if planned := oktaManager.PlannedChanges(); len(planned) > 0 {
+				oktaGroupCRD.Status.PlannedChanges = planned
+				setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionReady, metav1.ConditionFalse, accessmanagerv1.OktaGroupReasonDryRun,
+					fmt.Sprintf("%d changes to the Okta group are planned on deletion, but not made in dry-run", len(planned)))
+				if err := r.updateStatus(ctx, oktaGroupCRD); err != nil {
+					log.Log.Error(err, "unable to update OktaGroupCRD status")
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{}, nil
+			}
+
 			// Remove ConstOktaGroupFinalizer. Once all finalizers have been
 			// removed, the object will be deleted.
 			controllerutil.RemoveFinalizer(oktaGroupCRD, ConstOktaGroupFinalizer)
@@ -105,53 +207,291 @@ func (r *OktaGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				log.Log.Error(err, "unable to delete after removing finalizer OktaGroupAPI")
 				return ctrl.Result{}, err
 			}
+			forgetOktaGroup(oktaGroupCRD)
 		}
 
 		// Stop reconciliation as the item is being deleted
 		return ctrl.Result{}, nil
 	}
 
+	setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionCredentialsValid, metav1.ConditionTrue,
+		accessmanagerv1.OktaGroupReasonCredentialsLoaded, "Okta client is ready")
+
+	// Bind the roles of the spec to the Okta group in the cluster
+	var changedBindings int
+	if oktaGroupCRD.Spec.KubernetesRBAC == nil || r.RolesAuthorized {
+		changedBindings, err = r.syncRoleBindings(ctx, oktaGroupCRD, dryRun)
+		if err != nil {
+			log.Log.Error(err, "unable to sync the role bindings of the OktaGroup")
+			return r.failReconcile(ctx, oktaGroupCRD, accessmanagerv1.OktaGroupConditionRBACSynced, accessmanagerv1.OktaGroupReasonRBACSyncFailed, err)
+		}
+	}
+	if !r.RolesAuthorized && oktaGroupCRD.Spec.KubernetesRBAC != nil {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionRBACSynced, metav1.ConditionFalse, accessmanagerv1.OktaGroupReasonRBACUnchecked,
+			"the roles aren't bound while the webhook checking who may bind them is disabled")
+	} else if dryRun && changedBindings > 0 {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionRBACSynced, metav1.ConditionFalse, accessmanagerv1.OktaGroupReasonDryRun,
+			fmt.Sprintf("%d role bindings would be created, updated or deleted, but not in dry-run", changedBindings))
+	} else {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionRBACSynced, metav1.ConditionTrue,
+			accessmanagerv1.OktaGroupReasonSynced, "Role bindings match the spec")
+	}
+
 	// Upsert the Okta group
 	oktaGroupAPI, err := oktaManager.UpsertOktaGroup()
 	if err != nil {
 		log.Log.Error(err, "unable to upsert OktaGroupAPI")
-		return ctrl.Result{}, err
+		return r.failReconcile(ctx, oktaGroupCRD, accessmanagerv1.OktaGroupConditionGroupSynced, accessmanagerv1.OktaGroupReasonGroupSyncFailed, err)
+	}
+	// A group whose creation or adoption is only planned isn't the one of the OktaGroup
+	if !oktaManager.GroupPlanned() {
+		oktaGroupCRD.Status.Id = oktaGroupAPI.Id
+	}
+	if oktaManager.Adopted() {
+		now := metav1.Now()
+		oktaGroupCRD.Status.AdoptedAt = &now
+	}
+	if planned := oktaManager.PlannedGroupChanges(); planned > 0 {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionGroupSynced, metav1.ConditionFalse, accessmanagerv1.OktaGroupReasonDryRun,
+			fmt.Sprintf("%d changes to the Okta group are planned, but not made in dry-run", planned))
+	} else {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionGroupSynced, metav1.ConditionTrue,
+			accessmanagerv1.OktaGroupReasonSynced, "Okta group matches the spec")
 	}
 
 	// Add users to the Okta group API
-	if err = oktaManager.UpsertUsersToOktaGroup(oktaGroupAPI); err != nil {
-		log.Log.Error(err, "unable to upsert users to OktaGroupAPI")
-		return ctrl.Result{}, err
+	members, err := oktaManager.UpsertUsersToOktaGroup(oktaGroupAPI)
+	if members != nil {
+		setMembers(oktaGroupCRD, members)
 	}
-
-	// Refresh the group by using the Id
-	oktaGroupAPI, err = oktaManager.SearchOktaGroup(oktaGroupAPI.Id)
 	if err != nil {
-		log.Log.Error(err, "unable to get OktaGroupAPI")
-		return ctrl.Result{}, err
+		log.Log.Error(err, "unable to upsert users to OktaGroupAPI")
+		return r.failReconcile(ctx, oktaGroupCRD, accessmanagerv1.OktaGroupConditionMembersSynced, accessmanagerv1.OktaGroupReasonMembersSyncFailed, err)
+	}
+	if unresolved := unresolvedMembers(members); unresolved != "" {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionMembersSynced, metav1.ConditionFalse,
+			accessmanagerv1.OktaGroupReasonMembersUnresolved, unresolved)
+	} else if planned := oktaManager.PlannedMemberChanges(); planned > 0 {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionMembersSynced, metav1.ConditionFalse, accessmanagerv1.OktaGroupReasonDryRun,
+			fmt.Sprintf("%d changes to the Okta group members are planned, but not made in dry-run", planned))
+	} else {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionMembersSynced, metav1.ConditionTrue,
+			accessmanagerv1.OktaGroupReasonSynced, "Okta group members match the spec")
 	}
 
-	// Update the OktaGroup status
-	oktaGroupCRD.Status = accessmanagerv1.OktaGroupStatus{
-		Id:      oktaGroupAPI.Id,
-		Created: metav1.NewTime(oktaGroupAPI.Created.UTC()),
+	// Refresh the group by using the Id, unless its creation or adoption was only planned
+	if oktaGroupAPI.Id != "" && !oktaManager.GroupPlanned() {
+		oktaGroupAPI, err = oktaManager.SearchOktaGroup(oktaGroupAPI.Id)
+		if err != nil {
+			log.Log.Error(err, "unable to get OktaGroupAPI")
+			return r.failReconcile(ctx, oktaGroupCRD, accessmanagerv1.OktaGroupConditionGroupSynced, accessmanagerv1.OktaGroupReasonGroupSyncFailed, err)
+		}
+
+		// Update the OktaGroup status, keeping the conditions set above
+		oktaGroupCRD.Status.Id = oktaGroupAPI.Id
+		oktaGroupCRD.Status.Created = metav1.NewTime(oktaGroupAPI.Created.UTC())
 
 		// Convert the time.Time pointers to metav1.Time, with UTC timezone
-		LastMembershipUpdated: metav1.NewTime(oktaGroupAPI.LastMembershipUpdated.UTC()),
-		LastUpdated:           metav1.NewTime(oktaGroupAPI.LastUpdated.UTC()),
+		oktaGroupCRD.Status.LastMembershipUpdated = metav1.NewTime(oktaGroupAPI.LastMembershipUpdated.UTC())
+		oktaGroupCRD.Status.LastUpdated = metav1.NewTime(oktaGroupAPI.LastUpdated.UTC())
+	}
+
+	if membersSynced := meta.FindStatusCondition(oktaGroupCRD.Status.Conditions, accessmanagerv1.OktaGroupConditionMembersSynced); membersSynced.Status != metav1.ConditionTrue {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionReady, metav1.ConditionFalse, membersSynced.Reason, membersSynced.Message)
+	} else {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionReady, metav1.ConditionTrue,
+			accessmanagerv1.OktaGroupReasonSynced, "Okta group and its members are in sync")
+	}
+	// In dry-run the Okta group isn't ready until the planned changes are made
+	oktaGroupCRD.Status.PlannedChanges = oktaManager.PlannedChanges()
+	if planned := len(oktaGroupCRD.Status.PlannedChanges); planned > 0 {
+		setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionReady, metav1.ConditionFalse, accessmanagerv1.OktaGroupReasonDryRun,
+			fmt.Sprintf("%d changes to the Okta group are planned, but not made in dry-run", planned))
 	}
+	oktaGroupCRD.Status.Drift = oktaManager.Drift()
+	oktaGroupCRD.Status.ObservedGeneration = oktaGroupCRD.Generation
 
-	if err := r.Status().Update(ctx, oktaGroupCRD); err != nil {
+	if err := r.updateStatus(ctx, oktaGroupCRD); err != nil {
 		log.Log.Error(err, "unable to update OktaGroupCRD status")
 		return ctrl.Result{}, err
 	}
+	driftedOktaGroups.set(oktaGroupCRD.Name, oktaGroupCRD.Status.Drift != nil)
+	ready := meta.FindStatusCondition(oktaGroupCRD.Status.Conditions, accessmanagerv1.OktaGroupConditionReady)
+	observeReconcile(oktaGroupCRD, ready.Reason)
+	trace.SpanFromContext(ctx).SetAttributes(oktaGroupReasonKey.String(ready.Reason))
+
+	// Sync again later to revert the changes made outside of the operator, or to
+	// remove the next expired member
+	return ctrl.Result{RequeueAfter: r.requeueAfter(oktaGroupCRD, time.Now())}, nil
+}
+
+// finalizeOktaGroup applies the deletion policy of a deleted OktaGroup to its Okta group.
+func (r *OktaGroupReconciler) finalizeOktaGroup(oktaManager *OktaGroupManager, oktaGroupCRD *accessmanagerv1.OktaGroup) error {
+	switch r.deletionPolicy(oktaGroupCRD) {
+	case accessmanagerv1.OktaGroupDeletionPolicyOrphan:
+		return oktaManager.OrphanOktaGroup(false)
+	case accessmanagerv1.OktaGroupDeletionPolicyRemoveMembers:
+		return oktaManager.OrphanOktaGroup(true)
+	default:
+		return oktaManager.DeleteOktaGroup()
+	}
+}
+
+// dryRun returns whether the changes to the Okta group of the OktaGroup are only
+// planned: as set by its dry-run annotation, or by DryRun without one.
+func (r *OktaGroupReconciler) dryRun(oktaGroupCRD *accessmanagerv1.OktaGroup) bool {
+	if value, ok := oktaGroupCRD.Annotations[accessmanagerv1.OktaGroupDryRunAnnotation]; ok {
+		if dryRun, err := strconv.ParseBool(value); err == nil {
+			return dryRun
+		}
+		log.Log.Info("Ignoring invalid dry-run annotation", "oktaGroup", oktaGroupCRD.Name, "value", value)
+	}
+	return r.DryRun
+}
+
+// deletionPolicy returns what happens to the Okta group when the OktaGroup is deleted.
+func (r *OktaGroupReconciler) deletionPolicy(oktaGroupCRD *accessmanagerv1.OktaGroup) accessmanagerv1.OktaGroupDeletionPolicy {
+	if oktaGroupCRD.Spec.DeletionPolicy != "" {
+		return oktaGroupCRD.Spec.DeletionPolicy
+	}
+	if r.DeletionPolicy != "" {
+		return r.DeletionPolicy
+	}
+	return accessmanagerv1.OktaGroupDeletionPolicyDelete
+}
 
-	return ctrl.Result{}, nil
+// resyncInterval returns how often the OktaGroup is synced again, 0 if never.
+func (r *OktaGroupReconciler) resyncInterval(oktaGroupCRD *accessmanagerv1.OktaGroup) time.Duration {
+	if oktaGroupCRD.Spec.ResyncInterval != nil {
+		return max(oktaGroupCRD.Spec.ResyncInterval.Duration, 0)
+	}
+	return r.ResyncInterval
+}
+
+// requeueAfter returns when the OktaGroup is synced again: after its resync
+// interval, or when the next membership expires if that is sooner. 0 if never.
+func (r *OktaGroupReconciler) requeueAfter(oktaGroupCRD *accessmanagerv1.OktaGroup, now time.Time) time.Duration {
+	requeueAfter := r.resyncInterval(oktaGroupCRD)
+	if upcoming := oktaGroupCRD.Status.UpcomingExpirations; len(upcoming) > 0 {
+		untilExpiry := max(upcoming[0].ExpiresAt.Sub(now), time.Second)
+		if requeueAfter == 0 || untilExpiry < requeueAfter {
+			requeueAfter = untilExpiry
+		}
+	}
+	return requeueAfter
+}
+
+// failReconcile marks the failed condition and Ready as False with the error as
+// message, saves the status so the failure is visible on the object, and returns
+// the original error so that the request is retried. If the Okta org's rate limit
+// was exhausted, the request is requeued once it is reset instead.
+func (r *OktaGroupReconciler) failReconcile(ctx context.Context, oktaGroupCRD *accessmanagerv1.OktaGroup, conditionType, reason string, err error) (ctrl.Result, error) {
+	var rateLimited *RateLimitedError
+	if errors.As(err, &rateLimited) {
+		reason = accessmanagerv1.OktaGroupReasonRateLimited
+	}
+
+	setCondition(oktaGroupCRD, conditionType, metav1.ConditionFalse, reason, err.Error())
+	setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionReady, metav1.ConditionFalse, reason, err.Error())
+	oktaGroupCRD.Status.ObservedGeneration = oktaGroupCRD.Generation
+
+	if updateErr := r.updateStatus(ctx, oktaGroupCRD); updateErr != nil {
+		log.Log.Error(updateErr, "unable to update OktaGroupCRD status")
+	}
+	observeReconcile(oktaGroupCRD, reason)
+	// The rate limited requests are requeued without an error, so mark the span here
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(oktaGroupReasonKey.String(reason))
+	span.SetStatus(codes.Error, err.Error())
+
+	if rateLimited != nil {
+		log.Log.Info("Okta rate limit exhausted, requeuing", "endpoint", rateLimited.Endpoint, "reset", rateLimited.Reset)
+		return ctrl.Result{RequeueAfter: rateLimited.RetryAfter(time.Now())}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// updateStatus saves the status of the OktaGroup within a span, since it can take
+// as long as the Okta API calls when the API server is busy.
+func (r *OktaGroupReconciler) updateStatus(ctx context.Context, oktaGroupCRD *accessmanagerv1.OktaGroup) error {
+	ctx, span := tracer.Start(ctx, "OktaGroupReconciler.updateStatus")
+	err := r.Status().Update(ctx, oktaGroupCRD)
+	endSpan(span, err)
+	return err
+}
+
+// setCondition sets a condition of the OktaGroup status for its current generation.
+// The transition time only changes when the status of the condition does.
+func setCondition(oktaGroupCRD *accessmanagerv1.OktaGroup, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&oktaGroupCRD.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: oktaGroupCRD.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setMembers saves the sync result of every user in the status, together with
+// the number of users that are and aren't members of the Okta group.
+func setMembers(oktaGroupCRD *accessmanagerv1.OktaGroup, members []accessmanagerv1.OktaGroupMemberStatus) {
+	oktaGroupCRD.Status.Members = members
+	oktaGroupCRD.Status.SyncedMembers = 0
+	oktaGroupCRD.Status.UnresolvedMembers = 0
+	oktaGroupCRD.Status.UpcomingExpirations = nil
+	for _, member := range members {
+		switch member.State {
+		case accessmanagerv1.OktaGroupMemberStateMember:
+			oktaGroupCRD.Status.SyncedMembers++
+		case accessmanagerv1.OktaGroupMemberStateExpired, accessmanagerv1.OktaGroupMemberStateExcluded:
+			continue
+		default:
+			oktaGroupCRD.Status.UnresolvedMembers++
+		}
+		if member.ExpiresAt != nil {
+			oktaGroupCRD.Status.UpcomingExpirations = append(oktaGroupCRD.Status.UpcomingExpirations,
+				accessmanagerv1.OktaGroupMemberExpiration{Member: memberIdentifier(member), ExpiresAt: *member.ExpiresAt})
+		}
+	}
+	slices.SortStableFunc(oktaGroupCRD.Status.UpcomingExpirations, func(a, b accessmanagerv1.OktaGroupMemberExpiration) int {
+		return a.ExpiresAt.Time.Compare(b.ExpiresAt.Time)
+	})
+}
+
+// unresolvedMembers describes the users that don't match exactly one Okta user,
+// or returns an empty string if there are none. Inactive and pending users aren't
+// reported, as keeping them out of the group is expected.
+func unresolvedMembers(members []accessmanagerv1.OktaGroupMemberStatus) string {
+	var unresolved []string
+	for _, member := range members {
+		switch member.State {
+		case accessmanagerv1.OktaGroupMemberStateNotFound, accessmanagerv1.OktaGroupMemberStateAmbiguous:
+			unresolved = append(unresolved, fmt.Sprintf("%s (%s)", memberIdentifier(member), member.State))
+		}
+	}
+	if len(unresolved) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d users don't match exactly one Okta user: %s", len(unresolved), strings.Join(unresolved, ", "))
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *OktaGroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&accessmanagerv1.OktaGroup{}).
-		Complete(r)
+		Owns(&rbacv1.RoleBinding{}).
+		Owns(&rbacv1.ClusterRoleBinding{}).
+		Watches(&accessmanagerv1.OktaAccessRequest{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				accessRequest := obj.(*accessmanagerv1.OktaAccessRequest)
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: accessRequest.Spec.OktaGroupRef}}}
+			})).
+		// The status updates of the included and excluded OktaGroups only change their
+		// members when the expiries of the members do
+		Watches(&accessmanagerv1.OktaGroup{}, handler.EnqueueRequestsFromMapFunc(r.composingOktaGroups),
+			ctrlbuilder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, memberExpiriesChanged)))
+	if r.OktaEvents != nil {
+		builder = builder.WatchesRawSource(source.Channel(r.OktaEvents, &handler.EnqueueRequestForObject{}))
+	}
+	return builder.Complete(r)
 }
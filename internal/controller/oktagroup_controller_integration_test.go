@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"math/rand"
+	"os"
 	"testing"
 	"time"
 
@@ -18,8 +19,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1" // Adjust the import path
+	"github.com/franciscoprin/access-manager-operator/internal/oktafake"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -28,6 +31,7 @@ const (
 	charSetNumeric    = "0123456789"
 	charSetSpecial    = "!@#$%&*"
 	testPrefix        = "AMO_TEST_" // Access Manager Operator
+	liveOktaEnv       = testPrefix + "LIVE_OKTA"
 	allCharSet        = charSetAlphaLower + charSetAlphaUpper + charSetNumeric + charSetSpecial
 	passwordLength    = 10
 	emailDomain       = "@example.com"
@@ -105,17 +109,31 @@ func configureLogger() {
 	log.SetLogger(zap.New(zap.UseDevMode(true)))
 }
 
+// initializeOktaClient returns a client of a fake Okta org, or of the Okta tenant
+// configured in the environment when AMO_TEST_LIVE_OKTA is set.
 func initializeOktaClient(ctx context.Context, t *testing.T) (*okta.Client, context.Context) {
-	ctx, oktaClient, err := tests.NewClient(ctx, okta.WithCache(false))
+	if os.Getenv(liveOktaEnv) != "" {
+		ctx, oktaClient, err := tests.NewClient(ctx, okta.WithCache(false))
+		assert.NoError(t, err)
+		return oktaClient, ctx
+	}
+
+	server := oktafake.NewServer()
+	t.Cleanup(server.Close)
+	oktaClient, err := server.Client(ctx)
 	assert.NoError(t, err)
 	return oktaClient, ctx
 }
 
-func executeReconciler(ctx context.Context, t *testing.T, oktaGroupCRD *accessmanagerv1.OktaGroup) (client.Client, *OktaGroupReconciler, error) {
+func executeReconciler(ctx context.Context, t *testing.T, oktaClient *okta.Client, oktaGroupCRD *accessmanagerv1.OktaGroup) (client.Client, *OktaGroupReconciler, error) {
 	fakeClient := fake.NewClientBuilder().WithRuntimeObjects(oktaGroupCRD).WithStatusSubresource(oktaGroupCRD).Build()
+	oktaClients := NewOktaClientRegistry(fakeClient)
+	oktaClients.Register("", oktaClient)
 	reconciler := &OktaGroupReconciler{
-		Client: fakeClient,
-		Scheme: scheme.Scheme,
+		Client:      fakeClient,
+		Scheme:      scheme.Scheme,
+		OktaClients: oktaClients,
+		Recorder:    record.NewFakeRecorder(100),
 	}
 
 	res, err := reconciler.Reconcile(ctx, reconcile.Request{
@@ -133,14 +151,6 @@ func executeReconciler(ctx context.Context, t *testing.T, oktaGroupCRD *accessma
 	return fakeClient, reconciler, err
 }
 
-func extractOktaGroupCRDEmails(oktaUsersCRD []accessmanagerv1.OktaUserSpec) []string {
-	emails := make([]string, len(oktaUsersCRD))
-	for i, user := range oktaUsersCRD {
-		emails[i] = user.Email
-	}
-	return emails
-}
-
 func removeOktaGroup(ctx context.Context, oktaClient *okta.Client, groupID string) {
 	if _, err := oktaClient.Group.DeleteGroup(ctx, groupID); err != nil {
 		log.Log.Info("Unable to delete Okta group", "groupID", groupID)
@@ -165,17 +175,6 @@ func getGroupUserEmails(ctx context.Context, oktaClient *okta.Client, groupID st
 	return groupUserEmails, nil
 }
 
-func createOktaUserSpecs(userEmails []string) []accessmanagerv1.OktaUserSpec {
-	oktaUsers := make([]accessmanagerv1.OktaUserSpec, len(userEmails))
-	for i, email := range userEmails {
-		oktaUsers[i] = accessmanagerv1.OktaUserSpec{
-			Email: email,
-			Role:  "member",
-		}
-	}
-	return oktaUsers
-}
-
 func TestOktaGroupReconciler_HappyPath(t *testing.T) {
 	configureLogger()
 	ctx := context.TODO()
@@ -196,21 +195,15 @@ func TestOktaGroupReconciler_HappyPath(t *testing.T) {
 		},
 		Spec: accessmanagerv1.OktaGroupSpec{
 			Description: "Test Okta Group",
-			Users: []accessmanagerv1.OktaUserSpec{
-				{
-					Email: (*user1.Profile)["email"].(string),
-					Role:  "member",
-				},
-				{
-					Email: (*user2.Profile)["email"].(string),
-					Role:  "member",
-				},
+			Users: []string{
+				(*user1.Profile)["email"].(string),
+				(*user2.Profile)["email"].(string),
 			},
 		},
 	}
 
 	// Call Reconcile to create the Okta group
-	_, _, err := executeReconciler(ctx, t, oktaGroup)
+	_, _, err := executeReconciler(ctx, t, oktaClient, oktaGroup)
 
 	group, _, err := oktaClient.Group.GetGroup(ctx, oktaGroup.Status.Id)
 
@@ -220,7 +213,7 @@ func TestOktaGroupReconciler_HappyPath(t *testing.T) {
 	// Validate the Okta group CRD status
 	assert.NoError(t, err)
 	assert.Equal(t, oktaGroup.ObjectMeta.Name, group.Profile.Name)
-	assert.Equal(t, oktaGroup.Spec.Description, group.Profile.Description)
+	assert.Equal(t, managedDescription(oktaGroup.Spec.Description, DefaultManagerID), group.Profile.Description)
 	assert.Equal(t, metav1.NewTime(oktaGroup.Status.Created.UTC()), metav1.NewTime(group.Created.UTC()))
 	assert.Equal(t, oktaGroup.Status.Id, group.Id)
 	assert.Equal(t, metav1.NewTime(oktaGroup.Status.LastMembershipUpdated.UTC()), metav1.NewTime(group.LastMembershipUpdated.UTC()))
@@ -232,12 +225,20 @@ func TestOktaGroupReconciler_HappyPath(t *testing.T) {
 	// Validate the users were added to the Okta group
 	groupUserEmails, err := getGroupUserEmails(ctx, oktaClient, group.Id)
 	assert.NoError(t, err)
-	assert.ElementsMatch(t, extractOktaGroupCRDEmails(oktaGroup.Spec.Users), groupUserEmails)
+	assert.ElementsMatch(t, oktaGroup.Spec.Users, groupUserEmails)
+
+	// Validate the members are reported in the status
+	assert.Equal(t, 2, oktaGroup.Status.SyncedMembers)
+	assert.Equal(t, 0, oktaGroup.Status.UnresolvedMembers)
+	for _, member := range oktaGroup.Status.Members {
+		assert.Equal(t, accessmanagerv1.OktaGroupMemberStateMember, member.State)
+		assert.Contains(t, []string{user1.Id, user2.Id}, member.UserID)
+	}
 
 	// Trigger deletion by setting the DeletionTimestamp
 	oktaGroup.ObjectMeta.DeletionTimestamp = &metav1.Time{Time: time.Now()}
 
-	fakeClient, _, _ := executeReconciler(ctx, t, oktaGroup)
+	fakeClient, _, _ := executeReconciler(ctx, t, oktaClient, oktaGroup)
 
 	// Ensure that Okta group CRD was deleted
 	oktaGroupCRDList := &accessmanagerv1.OktaGroupList{}
@@ -273,20 +274,14 @@ func TestOktaGroupReconciler_UsersUpsert(t *testing.T) {
 		},
 		Spec: accessmanagerv1.OktaGroupSpec{
 			Description: "Test Okta Group",
-			Users: []accessmanagerv1.OktaUserSpec{
-				{
-					Email: (*user1.Profile)["email"].(string),
-					Role:  "member",
-				},
-				{
-					Email: (*user2.Profile)["email"].(string),
-					Role:  "member",
-				},
+			Users: []string{
+				(*user1.Profile)["email"].(string),
+				(*user2.Profile)["email"].(string),
 			},
 		},
 	}
 
-	_, _, err := executeReconciler(ctx, t, oktaGroup)
+	_, _, err := executeReconciler(ctx, t, oktaClient, oktaGroup)
 	assert.NoError(t, err)
 
 	group, _, err := oktaClient.Group.GetGroup(ctx, oktaGroup.Status.Id)
@@ -300,24 +295,18 @@ func TestOktaGroupReconciler_UsersUpsert(t *testing.T) {
 	assert.ElementsMatch(t, []string{(*user1.Profile)["email"].(string), (*user2.Profile)["email"].(string)}, groupUserEmails)
 
 	// Update the group by adding user3 and removing user2
-	oktaGroup.Spec.Users = []accessmanagerv1.OktaUserSpec{
-		{
-			Email: (*user1.Profile)["email"].(string),
-			Role:  "member",
-		},
-		{
-			Email: (*user3.Profile)["email"].(string),
-			Role:  "member",
-		},
+	oktaGroup.Spec.Users = []string{
+		(*user1.Profile)["email"].(string),
+		(*user3.Profile)["email"].(string),
 	}
 
-	_, _, err = executeReconciler(ctx, t, oktaGroup)
+	_, _, err = executeReconciler(ctx, t, oktaClient, oktaGroup)
 	assert.NoError(t, err)
 
 	// Validate updated group users
 	groupUserEmails, err = getGroupUserEmails(ctx, oktaClient, group.Id)
 	assert.NoError(t, err)
-	assert.ElementsMatch(t, extractOktaGroupCRDEmails(oktaGroup.Spec.Users), groupUserEmails)
+	assert.ElementsMatch(t, oktaGroup.Spec.Users, groupUserEmails)
 	assert.ElementsMatch(t, []string{(*user1.Profile)["email"].(string), (*user3.Profile)["email"].(string)}, groupUserEmails)
 }
 
@@ -349,21 +338,15 @@ func TestOktaGroupReconciler_IgnoreDisableUsers(t *testing.T) {
 		},
 		Spec: accessmanagerv1.OktaGroupSpec{
 			Description: "Test Okta Group",
-			Users: []accessmanagerv1.OktaUserSpec{
-				{
-					Email: (*user1.Profile)["email"].(string),
-					Role:  "member",
-				},
-				{
-					Email: (*user2.Profile)["email"].(string),
-					Role:  "member",
-				},
+			Users: []string{
+				(*user1.Profile)["email"].(string),
+				(*user2.Profile)["email"].(string),
 			},
 		},
 	}
 
 	// Call Reconcile to create the Okta group with initial users
-	_, _, err := executeReconciler(ctx, t, oktaGroup)
+	_, _, err := executeReconciler(ctx, t, oktaClient, oktaGroup)
 
 	group, _, err := oktaClient.Group.GetGroup(ctx, oktaGroup.Status.Id)
 	defer removeOktaGroup(ctx, oktaClient, group.Id)
@@ -379,23 +362,14 @@ func TestOktaGroupReconciler_IgnoreDisableUsers(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	oktaGroup.Spec.Users = []accessmanagerv1.OktaUserSpec{
-		{
-			Email: (*user1.Profile)["email"].(string),
-			Role:  "member",
-		},
-		{
-			Email: (*user2.Profile)["email"].(string),
-			Role:  "member",
-		},
-		{
-			Email: (*user3.Profile)["email"].(string),
-			Role:  "member",
-		},
+	oktaGroup.Spec.Users = []string{
+		(*user1.Profile)["email"].(string),
+		(*user2.Profile)["email"].(string),
+		(*user3.Profile)["email"].(string),
 	}
 
 	// Call Reconcile to update the Okta group after user1 is deactivated and user3 is added
-	_, _, err = executeReconciler(ctx, t, oktaGroup)
+	_, _, err = executeReconciler(ctx, t, oktaClient, oktaGroup)
 	assert.NoError(t, err)
 
 	// Validate both users are added to the group
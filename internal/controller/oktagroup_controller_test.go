@@ -0,0 +1,352 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+	"github.com/franciscoprin/access-manager-operator/internal/oktafake"
+)
+
+func TestOktaGroupConditions_InvalidCredentials(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "missing-org", Generation: 3},
+		Spec: accessmanagerv1.OktaGroupSpec{
+			OktaOrgRef: "missing",
+			Users:      []string{"john@example.com"},
+		},
+	}
+	fakeClient := fake.NewClientBuilder().
+		WithObjects(oktaGroupCRD).
+		WithStatusSubresource(oktaGroupCRD).
+		Build()
+	reconciler := &OktaGroupReconciler{
+		Client:      fakeClient,
+		Scheme:      scheme.Scheme,
+		OktaClients: NewOktaClientRegistry(fakeClient),
+		Recorder:    record.NewFakeRecorder(100),
+	}
+
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(oktaGroupCRD)})
+	assert.ErrorContains(t, err, `unable to fetch OktaOrg "missing"`)
+
+	updated := &accessmanagerv1.OktaGroup{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(oktaGroupCRD), updated))
+	assert.Equal(t, updated.Generation, updated.Status.ObservedGeneration)
+
+	credentialsValid := meta.FindStatusCondition(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionCredentialsValid)
+	if assert.NotNil(t, credentialsValid) {
+		assert.Equal(t, metav1.ConditionFalse, credentialsValid.Status)
+		assert.Equal(t, accessmanagerv1.OktaGroupReasonCredentialsInvalid, credentialsValid.Reason)
+		assert.Contains(t, credentialsValid.Message, `unable to fetch OktaOrg "missing"`)
+	}
+	assert.True(t, meta.IsStatusConditionFalse(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionReady))
+	assert.Nil(t, meta.FindStatusCondition(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionGroupSynced))
+}
+
+func TestSetCondition_KeepsTransitionTimeUntilStatusChanges(t *testing.T) {
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{ObjectMeta: metav1.ObjectMeta{Generation: 1}}
+
+	setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionReady, metav1.ConditionFalse, accessmanagerv1.OktaGroupReasonGroupSyncFailed, "boom")
+	firstTransition := oktaGroupCRD.Status.Conditions[0].LastTransitionTime
+	firstTransition.Time = firstTransition.Add(-time.Minute)
+	oktaGroupCRD.Status.Conditions[0].LastTransitionTime = firstTransition
+
+	oktaGroupCRD.Generation = 2
+	setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionReady, metav1.ConditionFalse, accessmanagerv1.OktaGroupReasonMembersSyncFailed, "still failing")
+	ready := meta.FindStatusCondition(oktaGroupCRD.Status.Conditions, accessmanagerv1.OktaGroupConditionReady)
+	assert.Equal(t, firstTransition, ready.LastTransitionTime)
+	assert.Equal(t, int64(2), ready.ObservedGeneration)
+	assert.Equal(t, accessmanagerv1.OktaGroupReasonMembersSyncFailed, ready.Reason)
+
+	setCondition(oktaGroupCRD, accessmanagerv1.OktaGroupConditionReady, metav1.ConditionTrue, accessmanagerv1.OktaGroupReasonSynced, "in sync")
+	ready = meta.FindStatusCondition(oktaGroupCRD.Status.Conditions, accessmanagerv1.OktaGroupConditionReady)
+	assert.NotEqual(t, firstTransition, ready.LastTransitionTime)
+	assert.Len(t, oktaGroupCRD.Status.Conditions, 1)
+}
+
+func TestSetMembers_CountsSyncedAndUnresolvedMembers(t *testing.T) {
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{}
+	members := []accessmanagerv1.OktaGroupMemberStatus{
+		{Email: "member@example.com", State: accessmanagerv1.OktaGroupMemberStateMember, UserID: "00u1"},
+		{Email: "staged@example.com", State: accessmanagerv1.OktaGroupMemberStatePending, UserID: "00u2"},
+		{Email: "missing@example.com", State: accessmanagerv1.OktaGroupMemberStateNotFound},
+		{Email: "twice@example.com", State: accessmanagerv1.OktaGroupMemberStateAmbiguous},
+	}
+
+	setMembers(oktaGroupCRD, members)
+
+	assert.Equal(t, members, oktaGroupCRD.Status.Members)
+	assert.Equal(t, 1, oktaGroupCRD.Status.SyncedMembers)
+	assert.Equal(t, 3, oktaGroupCRD.Status.UnresolvedMembers)
+	assert.Equal(t,
+		"2 users don't match exactly one Okta user: missing@example.com (NotFound), twice@example.com (Ambiguous)",
+		unresolvedMembers(members))
+	assert.Empty(t, unresolvedMembers(members[:2]))
+}
+
+func TestOktaGroupReconciler_RequeuesWhenRateLimited(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{ObjectMeta: metav1.ObjectMeta{Name: "developers"}}
+	fakeClient := fake.NewClientBuilder().WithObjects(oktaGroupCRD).WithStatusSubresource(oktaGroupCRD).Build()
+	reconciler := &OktaGroupReconciler{Client: fakeClient, Scheme: scheme.Scheme}
+
+	rateLimited := &RateLimitedError{Endpoint: "/api/v1/groups/{id}/users", Reset: time.Now().Add(time.Minute)}
+	res, err := reconciler.failReconcile(ctx, oktaGroupCRD, accessmanagerv1.OktaGroupConditionMembersSynced,
+		accessmanagerv1.OktaGroupReasonMembersSyncFailed, fmt.Errorf("unable to add user: %w", rateLimited))
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Minute, res.RequeueAfter, float64(5*time.Second))
+
+	membersSynced := meta.FindStatusCondition(oktaGroupCRD.Status.Conditions, accessmanagerv1.OktaGroupConditionMembersSynced)
+	assert.Equal(t, accessmanagerv1.OktaGroupReasonRateLimited, membersSynced.Reason)
+}
+
+func TestOktaGroupReconciler_ResyncInterval(t *testing.T) {
+	reconciler := &OktaGroupReconciler{ResyncInterval: 10 * time.Minute}
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{}
+	assert.Equal(t, 10*time.Minute, reconciler.resyncInterval(oktaGroupCRD))
+
+	oktaGroupCRD.Spec.ResyncInterval = &metav1.Duration{Duration: time.Minute}
+	assert.Equal(t, time.Minute, reconciler.resyncInterval(oktaGroupCRD))
+
+	oktaGroupCRD.Spec.ResyncInterval = &metav1.Duration{}
+	assert.Zero(t, reconciler.resyncInterval(oktaGroupCRD))
+}
+
+func TestOktaGroupReconciler_RequeuesAtNextExpiry(t *testing.T) {
+	now := time.Now()
+	soon, later := metav1.NewTime(now.Add(time.Minute)), metav1.NewTime(now.Add(time.Hour))
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{}
+	setMembers(oktaGroupCRD, []accessmanagerv1.OktaGroupMemberStatus{
+		{Member: "later@example.com", State: accessmanagerv1.OktaGroupMemberStateMember, ExpiresAt: &later},
+		{Member: "soon@example.com", State: accessmanagerv1.OktaGroupMemberStateNotFound, ExpiresAt: &soon},
+		{Member: "expired@example.com", State: accessmanagerv1.OktaGroupMemberStateExpired, ExpiresAt: &metav1.Time{}},
+		{Member: "forever@example.com", State: accessmanagerv1.OktaGroupMemberStateMember},
+	})
+	assert.Equal(t, 2, oktaGroupCRD.Status.SyncedMembers)
+	assert.Equal(t, 1, oktaGroupCRD.Status.UnresolvedMembers)
+	assert.Equal(t, []accessmanagerv1.OktaGroupMemberExpiration{
+		{Member: "soon@example.com", ExpiresAt: soon},
+		{Member: "later@example.com", ExpiresAt: later},
+	}, oktaGroupCRD.Status.UpcomingExpirations)
+
+	reconciler := &OktaGroupReconciler{ResyncInterval: 10 * time.Minute}
+	assert.Equal(t, time.Minute, reconciler.requeueAfter(oktaGroupCRD, now))
+	assert.Equal(t, 10*time.Minute, reconciler.requeueAfter(&accessmanagerv1.OktaGroup{}, now))
+
+	// Memberships are still removed when they expire with the resync disabled
+	reconciler.ResyncInterval = 0
+	assert.Equal(t, time.Second, reconciler.requeueAfter(oktaGroupCRD, now.Add(time.Hour)))
+}
+
+func TestOktaGroupReconciler_DeletionPolicy(t *testing.T) {
+	reconciler := &OktaGroupReconciler{}
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{}
+	assert.Equal(t, accessmanagerv1.OktaGroupDeletionPolicyDelete, reconciler.deletionPolicy(oktaGroupCRD))
+
+	reconciler.DeletionPolicy = accessmanagerv1.OktaGroupDeletionPolicyOrphan
+	assert.Equal(t, accessmanagerv1.OktaGroupDeletionPolicyOrphan, reconciler.deletionPolicy(oktaGroupCRD))
+
+	oktaGroupCRD.Spec.DeletionPolicy = accessmanagerv1.OktaGroupDeletionPolicyRemoveMembers
+	assert.Equal(t, accessmanagerv1.OktaGroupDeletionPolicyRemoveMembers, reconciler.deletionPolicy(oktaGroupCRD))
+}
+
+func TestOktaGroupReconciler_DryRun(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	server := oktafake.NewServer()
+	defer server.Close()
+	oktaClient, err := server.Client(ctx)
+	assert.NoError(t, err)
+
+	john := server.AddUser("john", "john@example.com", oktafake.StatusActive)
+	jane := server.AddUser("jane", "jane@example.com", oktafake.StatusActive)
+	adam := server.AddUser("adam", "adam@example.com", oktafake.StatusActive)
+	group := server.AddGroup("developers", managedDescription("Developers", DefaultManagerID), john.Id, adam.Id)
+
+	sync := func(reconciler *OktaGroupReconciler, oktaGroupCRD *accessmanagerv1.OktaGroup) *accessmanagerv1.OktaGroup {
+		fakeClient := fake.NewClientBuilder().WithObjects(oktaGroupCRD).WithStatusSubresource(oktaGroupCRD).Build()
+		reconciler.Client = fakeClient
+		reconciler.Scheme = scheme.Scheme
+		reconciler.OktaClients = NewOktaClientRegistry(fakeClient)
+		reconciler.OktaClients.Register("", oktaClient)
+		reconciler.Recorder = record.NewFakeRecorder(100)
+
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(oktaGroupCRD)})
+		assert.NoError(t, err)
+		updated := &accessmanagerv1.OktaGroup{}
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(oktaGroupCRD), updated))
+		return updated
+	}
+	mutatingRequests := func() (mutating []string) {
+		for _, request := range server.Requests() {
+			if !strings.HasPrefix(request, "GET ") {
+				mutating = append(mutating, request)
+			}
+		}
+		return mutating
+	}
+
+	// The annotation plans the changes to an existing group
+	developers := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers", Annotations: map[string]string{accessmanagerv1.OktaGroupDryRunAnnotation: "true"}},
+		Spec:       accessmanagerv1.OktaGroupSpec{Description: "Developers", Users: []string{"john@example.com", "jane@example.com"}},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: group.Id},
+	}
+	updated := sync(&OktaGroupReconciler{}, developers)
+	assert.Empty(t, mutatingRequests())
+	assert.Equal(t, []string{john.Id, adam.Id}, server.GroupUsers(group.Id))
+
+	var actions []string
+	for _, change := range updated.Status.PlannedChanges {
+		actions = append(actions, fmt.Sprintf("%s %s", change.Action, change.Member))
+	}
+	assert.Equal(t, []string{"AddMember jane@example.com", "RemoveMember adam@example.com"}, actions)
+	ready := meta.FindStatusCondition(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionReady)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, accessmanagerv1.OktaGroupReasonDryRun, ready.Reason)
+
+	// The flag plans the creation of a new group
+	admins := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "admins"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: []string{"john@example.com"}},
+	}
+	updated = sync(&OktaGroupReconciler{DryRun: true}, admins)
+	assert.Empty(t, mutatingRequests())
+	assert.Empty(t, updated.Status.Id)
+	if assert.Len(t, updated.Status.PlannedChanges, 2) {
+		assert.Equal(t, accessmanagerv1.OktaGroupChangeCreateGroup, updated.Status.PlannedChanges[0].Action)
+		assert.Equal(t, accessmanagerv1.OktaGroupPlannedChange{
+			Action: accessmanagerv1.OktaGroupChangeAddMember, Member: "john@example.com", UserID: john.Id,
+			Message: fmt.Sprintf("Would add user john@example.com (%s) to Okta group", john.Id),
+		}, updated.Status.PlannedChanges[1])
+	}
+	groupSynced := meta.FindStatusCondition(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionGroupSynced)
+	assert.Equal(t, metav1.ConditionFalse, groupSynced.Status)
+	assert.Equal(t, accessmanagerv1.OktaGroupReasonDryRun, groupSynced.Reason)
+
+	// A planned adoption doesn't make the existing group the one of the OktaGroup
+	platform := server.AddGroup("platform", "Platform", john.Id)
+	updated = sync(&OktaGroupReconciler{DryRun: true}, &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "platform"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: []string{"john@example.com"}, AdoptionPolicy: accessmanagerv1.OktaGroupAdoptionPolicyIfUnmanaged},
+	})
+	assert.Empty(t, mutatingRequests())
+	assert.Empty(t, updated.Status.Id)
+	assert.Nil(t, updated.Status.AdoptedAt)
+	assert.Equal(t, accessmanagerv1.OktaGroupChangeAdoptGroup, updated.Status.PlannedChanges[0].Action)
+
+	// A planned deletion keeps the finalizer, so that the deletion is made once
+	// dry-run is turned off
+	now := metav1.Now()
+	updated = sync(&OktaGroupReconciler{DryRun: true}, &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "platform", DeletionTimestamp: &now, Finalizers: []string{ConstOktaGroupFinalizer}},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: platform.Id},
+	})
+	assert.Empty(t, mutatingRequests())
+	assert.Contains(t, updated.Finalizers, ConstOktaGroupFinalizer)
+	assert.Equal(t, accessmanagerv1.OktaGroupChangeDeleteGroup, updated.Status.PlannedChanges[0].Action)
+
+	// The annotation overrides the flag, and the changes are made
+	developers.Annotations[accessmanagerv1.OktaGroupDryRunAnnotation] = "false"
+	updated = sync(&OktaGroupReconciler{DryRun: true}, developers)
+	assert.NotEmpty(t, mutatingRequests())
+	assert.Equal(t, []string{john.Id, jane.Id}, server.GroupUsers(group.Id))
+	assert.Empty(t, updated.Status.PlannedChanges)
+	assert.True(t, meta.IsStatusConditionTrue(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionReady))
+}
+
+func TestOktaGroupReconciler_ComposesMembers(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	server := oktafake.NewServer()
+	defer server.Close()
+	oktaClient, err := server.Client(ctx)
+	assert.NoError(t, err)
+
+	john := server.AddUser("john", "john@example.com", oktafake.StatusActive)
+	jane := server.AddUser("jane", "jane@example.com", oktafake.StatusActive)
+	adam := server.AddUser("adam", "adam@example.com", oktafake.StatusActive)
+	boss := server.AddUser("boss", "boss@example.com", oktafake.StatusActive)
+	group := server.AddGroup("platform-all", managedDescription("", DefaultManagerID), john.Id, adam.Id)
+
+	// The contractor listed by email in the spec is excluded by login
+	platformAll := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "platform-all"},
+		Spec: accessmanagerv1.OktaGroupSpec{
+			Users:         []string{"adam@example.com", "boss@example.com"},
+			IncludeGroups: []string{"platform-sre"},
+			ExcludeGroups: []string{"contractors"},
+		},
+		Status: accessmanagerv1.OktaGroupStatus{Id: group.Id},
+	}
+	platformSRE := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "platform-sre"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: []string{"john@example.com", "jane@example.com"}},
+	}
+	contractors := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "contractors"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Members: []accessmanagerv1.OktaGroupMember{{Login: "adam"}}},
+	}
+	fakeClient := fake.NewClientBuilder().WithObjects(platformAll, platformSRE, contractors).WithStatusSubresource(platformAll).Build()
+	reconciler := &OktaGroupReconciler{
+		Client:      fakeClient,
+		Scheme:      scheme.Scheme,
+		OktaClients: NewOktaClientRegistry(fakeClient),
+		Recorder:    record.NewFakeRecorder(100),
+	}
+	reconciler.OktaClients.Register("", oktaClient)
+
+	_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(platformAll)})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{john.Id, jane.Id, boss.Id}, server.GroupUsers(group.Id))
+
+	updated := &accessmanagerv1.OktaGroup{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(platformAll), updated))
+	assert.True(t, meta.IsStatusConditionTrue(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionReady))
+	assert.Equal(t, 3, updated.Status.SyncedMembers)
+	assert.Equal(t, 0, updated.Status.UnresolvedMembers)
+	states := map[string]string{}
+	for _, member := range updated.Status.Members {
+		states[member.Member] = fmt.Sprintf("%s %v %s", member.State, member.IncludedFrom, member.Message)
+	}
+	assert.Equal(t, map[string]string{
+		"adam@example.com": "Excluded [] excluded by OktaGroup contractors",
+		"boss@example.com": "Member [] ",
+		"jane@example.com": "Member [platform-sre] ",
+		"john@example.com": "Member [platform-sre] ",
+	}, states)
+
+	// A cycle stops the sync until it is fixed
+	platformSRE.Spec.IncludeGroups = []string{"platform-all"}
+	assert.NoError(t, fakeClient.Update(ctx, platformSRE))
+	requests := len(server.Requests())
+	_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(platformAll)})
+	assert.ErrorContains(t, err, "platform-all -> platform-sre -> platform-all")
+	assert.Len(t, server.Requests(), requests)
+	assert.ElementsMatch(t, []string{john.Id, jane.Id, boss.Id}, server.GroupUsers(group.Id))
+
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(platformAll), updated))
+	ready := meta.FindStatusCondition(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionReady)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, accessmanagerv1.OktaGroupReasonCompositionCycle, ready.Reason)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+	"github.com/franciscoprin/access-manager-operator/internal/oktafake"
+)
+
+var _ = Describe("OktaGroup controller", func() {
+	Context("when the Okta group has more members than fit in a page", func() {
+		It("syncs the members of every page", func() {
+			ctx := context.Background()
+			server := oktafake.NewServer()
+			DeferCleanup(server.Close)
+			oktaClient, err := server.Client(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			var userIDs []string
+			for i := 1; i <= 5; i++ {
+				user := server.AddUser(fmt.Sprintf("user%d", i), fmt.Sprintf("user%d@example.com", i), oktafake.StatusActive)
+				userIDs = append(userIDs, user.Id)
+			}
+			group := server.AddGroup("paginated-developers", "", userIDs[:4]...)
+
+			reconciler := &OktaGroupReconciler{
+				Client:       k8sClient,
+				Scheme:       scheme.Scheme,
+				OktaClients:  NewOktaClientRegistry(k8sClient),
+				Recorder:     record.NewFakeRecorder(100),
+				OktaPageSize: 2,
+			}
+			reconciler.OktaClients.Register("", oktaClient)
+
+			oktaGroup := &accessmanagerv1.OktaGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "paginated-developers"},
+				Spec: accessmanagerv1.OktaGroupSpec{
+					Users:          []string{"user1@example.com", "user5@example.com"},
+					AdoptionPolicy: accessmanagerv1.OktaGroupAdoptionPolicyAlways,
+				},
+			}
+			Expect(k8sClient.Create(ctx, oktaGroup)).To(Succeed())
+			request := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(oktaGroup)}
+
+			By("reconciling the OktaGroup until its members are synced")
+			Eventually(func(g Gomega) {
+				_, err := reconciler.Reconcile(ctx, request)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(server.GroupUsers(group.Id)).To(Equal([]string{userIDs[0], userIDs[4]}))
+			}).Should(Succeed())
+
+			synced := &accessmanagerv1.OktaGroup{}
+			Expect(k8sClient.Get(ctx, request.NamespacedName, synced)).To(Succeed())
+			Expect(synced.Status.Id).To(Equal(group.Id))
+			var paginated bool
+			for _, uri := range server.Requests() {
+				paginated = paginated || strings.Contains(uri, "/users?after=")
+			}
+			Expect(paginated).To(BeTrue(), "the members past the first page must be listed")
+
+			By("deleting the OktaGroup")
+			Expect(k8sClient.Delete(ctx, oktaGroup)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.Group(group.Id)).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,258 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+var (
+	errCompositionCycle   = errors.New("the included groups form a cycle")
+	errCompositionInvalid = errors.New("invalid included or excluded group")
+)
+
+// OktaGroupComposition is the membership an OktaGroup gets from the OktaGroups it
+// includes and excludes.
+type OktaGroupComposition struct {
+	// Included are the members of the included OktaGroups.
+	Included []accessmanagerv1.OktaGroupMember
+	// IncludedFrom maps the identifier of an included member to the names of the
+	// included OktaGroups it is a member of.
+	IncludedFrom map[string][]string
+	// Excluded are the members of the excluded OktaGroups.
+	Excluded []accessmanagerv1.OktaGroupMember
+	// ExcludedBy maps the identifier of an excluded member to the names of the
+	// excluded OktaGroups it is a member of.
+	ExcludedBy map[string][]string
+}
+
+// composeMembers returns the members of the OktaGroups included and excluded by an
+// OktaGroup. The members of an OktaGroup are the ones of its spec and of the
+// OktaGroups it includes, without the ones of the OktaGroups it excludes, matched by
+// identifier. Every OktaGroup reachable this way must exist and belong to the same
+// Okta org, and the included OktaGroups must not lead to a cycle.
+func composeMembers(ctx context.Context, c client.Reader, oktaGroupCRD *accessmanagerv1.OktaGroup) (*OktaGroupComposition, error) {
+	composition := &OktaGroupComposition{IncludedFrom: map[string][]string{}, ExcludedBy: map[string][]string{}}
+	if len(oktaGroupCRD.Spec.ComposedGroups()) == 0 {
+		return composition, nil
+	}
+
+	oktaGroups := &accessmanagerv1.OktaGroupList{}
+	if err := c.List(ctx, oktaGroups); err != nil {
+		return nil, err
+	}
+	byName := map[string]*accessmanagerv1.OktaGroup{oktaGroupCRD.Name: oktaGroupCRD}
+	for i := range oktaGroups.Items {
+		if oktaGroup := &oktaGroups.Items[i]; oktaGroup.Name != oktaGroupCRD.Name {
+			byName[oktaGroup.Name] = oktaGroup
+		}
+	}
+
+	if cycle := accessmanagerv1.CompositionCycle(oktaGroupCRD.Name, func(name string) *accessmanagerv1.OktaGroupSpec {
+		if oktaGroup, ok := byName[name]; ok {
+			return &oktaGroup.Spec
+		}
+		return nil
+	}); cycle != nil {
+		return nil, fmt.Errorf("%w: %s", errCompositionCycle, strings.Join(cycle, " -> "))
+	}
+
+	lookup := func(name string) (*accessmanagerv1.OktaGroup, error) {
+		oktaGroup, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: OktaGroup %s not found", errCompositionInvalid, name)
+		}
+		if oktaGroup.Spec.OktaOrgRef != oktaGroupCRD.Spec.OktaOrgRef {
+			return nil, fmt.Errorf("%w: OktaGroup %s belongs to another Okta org", errCompositionInvalid, name)
+		}
+		return oktaGroup, nil
+	}
+
+	// includedMembers returns the members of an OktaGroup without its exclusions. It
+	// ends as the included OktaGroups don't form a cycle.
+	var includedMembers func(name string) ([]accessmanagerv1.OktaGroupMember, error)
+	includedMembers = func(name string) ([]accessmanagerv1.OktaGroupMember, error) {
+		oktaGroup, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		members := inheritedMembers(oktaGroup)
+		for _, included := range oktaGroup.Spec.IncludeGroups {
+			includedMembers, err := includedMembers(included)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, includedMembers...)
+		}
+		return accessmanagerv1.NormalizeMembers(members), nil
+	}
+
+	expanded := map[string][]accessmanagerv1.OktaGroupMember{}
+	expanding := map[string]bool{oktaGroupCRD.Name: true}
+	var expand func(name string) ([]accessmanagerv1.OktaGroupMember, error)
+	expand = func(name string) ([]accessmanagerv1.OktaGroupMember, error) {
+		if members, ok := expanded[name]; ok {
+			return members, nil
+		}
+		// An OktaGroup excluded while it is being expanded, such as two OktaGroups
+		// excluding each other, is expanded without its exclusions
+		if expanding[name] {
+			return includedMembers(name)
+		}
+		oktaGroup, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		expanding[name] = true
+		defer delete(expanding, name)
+
+		members := inheritedMembers(oktaGroup)
+		for _, included := range oktaGroup.Spec.IncludeGroups {
+			includedMembers, err := expand(included)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, includedMembers...)
+		}
+		excluded := map[string]bool{}
+		for _, excludedGroup := range oktaGroup.Spec.ExcludeGroups {
+			excludedMembers, err := expand(excludedGroup)
+			if err != nil {
+				return nil, err
+			}
+			for _, member := range excludedMembers {
+				excluded[member.Identifier()] = true
+			}
+		}
+		members = slices.DeleteFunc(accessmanagerv1.NormalizeMembers(members), func(member accessmanagerv1.OktaGroupMember) bool {
+			return excluded[member.Identifier()]
+		})
+		expanded[name] = members
+		return members, nil
+	}
+
+	// The members of the OktaGroup itself are excluded by the manager, which also
+	// matches them by Okta user
+	for _, name := range oktaGroupCRD.Spec.IncludeGroups {
+		members, err := expand(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			composition.IncludedFrom[member.Identifier()] = append(composition.IncludedFrom[member.Identifier()], name)
+		}
+		composition.Included = append(composition.Included, members...)
+	}
+	for _, name := range oktaGroupCRD.Spec.ExcludeGroups {
+		members, err := expand(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			composition.ExcludedBy[member.Identifier()] = append(composition.ExcludedBy[member.Identifier()], name)
+		}
+		composition.Excluded = append(composition.Excluded, members...)
+	}
+	composition.Included = accessmanagerv1.NormalizeMembers(composition.Included)
+	composition.Excluded = accessmanagerv1.NormalizeMembers(composition.Excluded)
+	return composition, nil
+}
+
+// inheritedMembers returns the members of the spec of an OktaGroup as they are
+// passed on to the OktaGroups composed of it. The duration of a member starts when
+// its user is first in the Okta group of that OktaGroup, so the member gets the
+// expiry from its status instead, and is left out until its duration started.
+func inheritedMembers(oktaGroup *accessmanagerv1.OktaGroup) []accessmanagerv1.OktaGroupMember {
+	var members []accessmanagerv1.OktaGroupMember
+	for _, member := range oktaGroup.Spec.AllMembers() {
+		if member.ExpiresAt == nil && member.Duration != nil {
+			if member.ExpiresAt = durationExpiry(oktaGroup.Status.Members, member); member.ExpiresAt == nil {
+				continue
+			}
+			member.Duration = nil
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// memberExpiriesChanged passes the status updates of an OktaGroup that change the
+// expiries of its members, which the OktaGroups composed of it inherit.
+var memberExpiriesChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldGroup, ok := e.ObjectOld.(*accessmanagerv1.OktaGroup)
+		newGroup, ok2 := e.ObjectNew.(*accessmanagerv1.OktaGroup)
+		if !ok || !ok2 {
+			return false
+		}
+		return !maps.Equal(memberExpiries(oldGroup), memberExpiries(newGroup))
+	},
+}
+
+// memberExpiries maps the identifiers of the members of an OktaGroup to their expiry.
+func memberExpiries(oktaGroup *accessmanagerv1.OktaGroup) map[string]time.Time {
+	expiries := map[string]time.Time{}
+	for _, member := range oktaGroup.Status.Members {
+		if member.ExpiresAt != nil {
+			expiries[memberIdentifier(member)] = member.ExpiresAt.Time
+		}
+	}
+	return expiries
+}
+
+// compositionReason returns the condition reason of an error of composeMembers.
+func compositionReason(err error) string {
+	switch {
+	case errors.Is(err, errCompositionCycle):
+		return accessmanagerv1.OktaGroupReasonCompositionCycle
+	case errors.Is(err, errCompositionInvalid):
+		return accessmanagerv1.OktaGroupReasonCompositionInvalid
+	default:
+		return accessmanagerv1.OktaGroupReasonMembersSyncFailed
+	}
+}
+
+// composingOktaGroups enqueues the OktaGroups that include or exclude an OktaGroup
+// when it changes, directly or through other OktaGroups.
+func (r *OktaGroupReconciler) composingOktaGroups(ctx context.Context, obj client.Object) []reconcile.Request {
+	oktaGroups := &accessmanagerv1.OktaGroupList{}
+	if err := r.List(ctx, oktaGroups); err != nil {
+		log.Log.Error(err, "unable to list OktaGroups")
+		return nil
+	}
+	composedBy := map[string][]string{}
+	for _, oktaGroup := range oktaGroups.Items {
+		for _, name := range oktaGroup.Spec.ComposedGroups() {
+			composedBy[name] = append(composedBy[name], oktaGroup.Name)
+		}
+	}
+
+	var requests []reconcile.Request
+	seen := map[string]bool{obj.GetName(): true}
+	queue := []string{obj.GetName()}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, composing := range composedBy[name] {
+			if seen[composing] {
+				continue
+			}
+			seen[composing] = true
+			queue = append(queue, composing)
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: composing}})
+		}
+	}
+	return requests
+}
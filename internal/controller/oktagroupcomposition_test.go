@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+func newTestComposedOktaGroup(name string, include, exclude []string, users ...string) *accessmanagerv1.OktaGroup {
+	return &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: users, IncludeGroups: include, ExcludeGroups: exclude},
+	}
+}
+
+func identifiers(members []accessmanagerv1.OktaGroupMember) []string {
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.Identifier())
+	}
+	return ids
+}
+
+func TestComposeMembers(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	platformAll := newTestComposedOktaGroup("platform-all", []string{"platform-sre", "platform-dev"}, []string{"contractors"}, "boss@example.com")
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		platformAll,
+		newTestComposedOktaGroup("platform-sre", nil, nil, "john@example.com", "jane@example.com"),
+		newTestComposedOktaGroup("platform-dev", []string{"interns"}, []string{"leavers"}, "jane@example.com", "adam@example.com", "eve@example.com"),
+		newTestComposedOktaGroup("interns", nil, nil, "ian@example.com", "lea@example.com"),
+		newTestComposedOktaGroup("leavers", nil, nil, "eve@example.com", "lea@example.com"),
+		newTestComposedOktaGroup("contractors", nil, nil, "adam@example.com", "carl@example.com"),
+	).Build()
+
+	// The included groups are expanded recursively, without the members they exclude
+	composition, err := composeMembers(ctx, fakeClient, platformAll)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"adam@example.com", "ian@example.com", "jane@example.com", "john@example.com"}, identifiers(composition.Included))
+	assert.Equal(t, []string{"platform-sre", "platform-dev"}, composition.IncludedFrom["jane@example.com"])
+	assert.Equal(t, []string{"platform-dev"}, composition.IncludedFrom["ian@example.com"])
+	assert.Equal(t, []string{"adam@example.com", "carl@example.com"}, identifiers(composition.Excluded))
+	assert.Equal(t, []string{"contractors"}, composition.ExcludedBy["adam@example.com"])
+
+	// An OktaGroup without included and excluded groups has no composition
+	composition, err = composeMembers(ctx, fakeClient, newTestComposedOktaGroup("admins", nil, nil, "john@example.com"))
+	assert.NoError(t, err)
+	assert.Empty(t, composition.Included)
+	assert.Empty(t, composition.Excluded)
+}
+
+func TestComposeMembers_MutualExclusion(t *testing.T) {
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	platformAll := newTestComposedOktaGroup("platform-all", []string{"employees"}, []string{"contractors"})
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		platformAll,
+		newTestComposedOktaGroup("employees", nil, []string{"contractors"}, "john@example.com", "adam@example.com"),
+		newTestComposedOktaGroup("contractors", nil, []string{"employees"}, "adam@example.com", "carl@example.com"),
+	).Build()
+
+	// The OktaGroups excluding each other aren't a cycle, the one expanded last
+	// excludes the members of the other one without its exclusions
+	composition, err := composeMembers(context.TODO(), fakeClient, platformAll)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"adam@example.com", "john@example.com"}, identifiers(composition.Included))
+	assert.Equal(t, []string{"carl@example.com"}, identifiers(composition.Excluded))
+}
+
+func TestComposeMembers_InheritsExpiries(t *testing.T) {
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	expiresAt := metav1.NewTime(time.Now().Add(30 * time.Minute).Truncate(time.Second))
+	hour := &metav1.Duration{Duration: time.Hour}
+	platformSRE := newTestComposedOktaGroup("platform-sre", nil, nil, "john@example.com")
+	platformSRE.Spec.Members = []accessmanagerv1.OktaGroupMember{
+		{Email: "jane@example.com", Duration: hour},
+		{Email: "adam@example.com", Duration: hour},
+		{Email: "eve@example.com", Duration: &metav1.Duration{Duration: 2 * time.Hour}},
+	}
+	platformSRE.Status.Members = []accessmanagerv1.OktaGroupMemberStatus{
+		{Member: "jane@example.com", ExpiresAt: &expiresAt, Duration: hour},
+		{Member: "eve@example.com", ExpiresAt: &expiresAt, Duration: hour},
+	}
+	platformAll := newTestComposedOktaGroup("platform-all", []string{"platform-sre"}, nil)
+	fakeClient := fake.NewClientBuilder().WithObjects(platformAll, platformSRE).Build()
+
+	// The duration of jane started in platform-sre, the ones of adam and eve didn't
+	composition, err := composeMembers(context.TODO(), fakeClient, platformAll)
+	assert.NoError(t, err)
+	assert.Equal(t, []accessmanagerv1.OktaGroupMember{
+		{Email: "jane@example.com", ExpiresAt: &expiresAt},
+		{Email: "john@example.com"},
+	}, composition.Included)
+}
+
+func TestMemberExpiriesChanged(t *testing.T) {
+	expiresAt := metav1.NewTime(time.Now().Truncate(time.Second))
+	oldGroup := newTestComposedOktaGroup("platform-sre", nil, nil, "john@example.com")
+	newGroup := oldGroup.DeepCopy()
+	newGroup.Status.SyncedMembers = 1
+	assert.False(t, memberExpiriesChanged.Update(event.UpdateEvent{ObjectOld: oldGroup, ObjectNew: newGroup}))
+
+	newGroup.Status.Members = []accessmanagerv1.OktaGroupMemberStatus{{Member: "john@example.com", ExpiresAt: &expiresAt}}
+	assert.True(t, memberExpiriesChanged.Update(event.UpdateEvent{ObjectOld: oldGroup, ObjectNew: newGroup}))
+}
+
+func TestComposeMembers_RejectsInvalidCompositions(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	preview := newTestComposedOktaGroup("preview", nil, nil, "john@example.com")
+	preview.Spec.OktaOrgRef = "preview"
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		preview,
+		newTestComposedOktaGroup("platform-sre", []string{"platform-oncall"}, nil, "john@example.com"),
+		newTestComposedOktaGroup("platform-oncall", []string{"platform-sre"}, nil, "jane@example.com"),
+	).Build()
+
+	for name, tc := range map[string]struct {
+		oktaGroup *accessmanagerv1.OktaGroup
+		reason    string
+		message   string
+	}{
+		"cycle": {
+			oktaGroup: newTestComposedOktaGroup("platform-all", []string{"platform-sre"}, nil),
+			reason:    accessmanagerv1.OktaGroupReasonCompositionCycle,
+			message:   "the included groups form a cycle: platform-sre -> platform-oncall -> platform-sre",
+		},
+		"missing group": {
+			oktaGroup: newTestComposedOktaGroup("platform-all", []string{"platform-dev"}, nil),
+			reason:    accessmanagerv1.OktaGroupReasonCompositionInvalid,
+			message:   "invalid included or excluded group: OktaGroup platform-dev not found",
+		},
+		"other org": {
+			oktaGroup: newTestComposedOktaGroup("platform-all", nil, []string{"preview"}, "john@example.com"),
+			reason:    accessmanagerv1.OktaGroupReasonCompositionInvalid,
+			message:   "invalid included or excluded group: OktaGroup preview belongs to another Okta org",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := composeMembers(ctx, fakeClient, tc.oktaGroup)
+			assert.EqualError(t, err, tc.message)
+			assert.Equal(t, tc.reason, compositionReason(err))
+		})
+	}
+}
+
+func TestOktaGroupReconciler_ComposingOktaGroups(t *testing.T) {
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	fakeClient := fake.NewClientBuilder().WithObjects(
+		newTestComposedOktaGroup("platform-all", []string{"platform-sre", "platform-dev"}, nil),
+		newTestComposedOktaGroup("platform-dev", []string{"interns"}, nil, "jane@example.com"),
+		newTestComposedOktaGroup("platform-sre", nil, []string{"interns"}, "john@example.com"),
+		newTestComposedOktaGroup("interns", nil, nil, "ian@example.com"),
+		newTestComposedOktaGroup("admins", nil, nil, "john@example.com"),
+	).Build()
+	reconciler := &OktaGroupReconciler{Client: fakeClient}
+
+	// A change propagates to every OktaGroup that includes or excludes it, once
+	var names []string
+	for _, request := range reconciler.composingOktaGroups(context.TODO(), newTestComposedOktaGroup("interns", nil, nil)) {
+		names = append(names, request.Name)
+	}
+	assert.ElementsMatch(t, []string{"platform-dev", "platform-sre", "platform-all"}, names)
+
+	assert.Empty(t, reconciler.composingOktaGroups(context.TODO(), newTestComposedOktaGroup("admins", nil, nil)))
+	assert.Equal(t, []reconcile.Request{{NamespacedName: client.ObjectKey{Name: "platform-all"}}},
+		reconciler.composingOktaGroups(context.TODO(), newTestComposedOktaGroup("platform-sre", nil, nil)))
+}
@@ -4,60 +4,254 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/okta/okta-sdk-golang/v2/okta"
 	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
 )
 
+// Reasons of the events emitted on an OktaGroup for every change made in Okta.
+const (
+	EventReasonGroupCreated       = "GroupCreated"
+	EventReasonGroupCreateFailed  = "GroupCreateFailed"
+	EventReasonGroupUpdated       = "GroupUpdated"
+	EventReasonGroupUpdateFailed  = "GroupUpdateFailed"
+	EventReasonGroupDeleted       = "GroupDeleted"
+	EventReasonGroupDeleteFailed  = "GroupDeleteFailed"
+	EventReasonMemberAdded        = "MemberAdded"
+	EventReasonMemberAddFailed    = "MemberAddFailed"
+	EventReasonMemberRemoved      = "MemberRemoved"
+	EventReasonMemberRemoveFailed = "MemberRemoveFailed"
+	EventReasonDriftDetected      = "DriftDetected"
+	EventReasonGroupAdopted       = "GroupAdopted"
+	EventReasonGroupOrphaned      = "GroupOrphaned"
+	EventReasonChangePlanned      = "ChangePlanned"
+)
+
+// DefaultManagerID identifies the Okta groups managed by the operator when no
+// manager ID is configured.
+const DefaultManagerID = "access-manager-operator"
+
+// managedMarkerPrefix starts the marker appended to the description of the Okta
+// groups managed by the operator, followed by the manager ID.
+const managedMarkerPrefix = "[managed by access-manager-operator: "
+
+var (
+	errGroupNotFound  = errors.New("Okta group not found")
+	errAmbiguousGroup = errors.New("more than one Okta group has this name")
+)
+
 type OktaGroupManager struct {
 	ctx          context.Context
-	client       *okta.Client
+	client       OktaAPI
+	recorder     record.EventRecorder
 	oktaGroupCRD *accessmanagerv1.OktaGroup
+
+	// pageSize is the number of items requested per page by the list calls, 0 uses Okta's default.
+	pageSize int64
+	// users resolves the emails of the spec to Okta users.
+	users *OktaUserResolver
+	// granted are the members granted by approved OktaAccessRequests.
+	granted []accessmanagerv1.OktaGroupMember
+	// composition are the members of the included and excluded OktaGroups.
+	composition *OktaGroupComposition
+
+	// managerID is written in the description of the Okta groups to mark them as
+	// managed by this instance of the operator.
+	managerID string
+
+	// drift collects the changes made outside of the operator found while syncing.
+	drift accessmanagerv1.OktaGroupDrift
+	// adopted is true if UpsertOktaGroup adopted an existing Okta group.
+	adopted bool
+
+	// dryRun computes the changes to the Okta group without making them, they are
+	// collected in plan instead.
+	dryRun bool
+	plan   []accessmanagerv1.OktaGroupPlannedChange
 }
 
-func NewOktaGroupManager(ctx context.Context, oktaGroupCRD *accessmanagerv1.OktaGroup, oktaClient *okta.Client) (*OktaGroupManager, error) {
-	return &OktaGroupManager{
+// OktaGroupManagerOption configures an OktaGroupManager.
+type OktaGroupManagerOption func(*OktaGroupManager)
+
+// WithPageSize sets the number of items requested per page by the list calls.
+// A size of 0 uses Okta's default.
+func WithPageSize(pageSize int64) OktaGroupManagerOption {
+	return func(m *OktaGroupManager) {
+		m.pageSize = pageSize
+	}
+}
+
+// WithUserResolver sets the resolver shared by every OktaGroup to look up users,
+// by default the users are looked up without caching.
+func WithUserResolver(resolver *OktaUserResolver) OktaGroupManagerOption {
+	return func(m *OktaGroupManager) {
+		m.users = resolver
+	}
+}
+
+// WithGrantedMembers adds the members granted by approved OktaAccessRequests to
+// the members of the spec. The members of the spec take precedence.
+func WithGrantedMembers(members []accessmanagerv1.OktaGroupMember) OktaGroupManagerOption {
+	return func(m *OktaGroupManager) {
+		m.granted = members
+	}
+}
+
+// WithComposition adds the members of the included OktaGroups to the members of the
+// spec, and keeps the members of the excluded OktaGroups out of the Okta group.
+func WithComposition(composition *OktaGroupComposition) OktaGroupManagerOption {
+	return func(m *OktaGroupManager) {
+		m.composition = composition
+	}
+}
+
+// WithManagerID sets the ID that marks the Okta groups managed by this instance of
+// the operator, DefaultManagerID by default.
+func WithManagerID(managerID string) OktaGroupManagerOption {
+	return func(m *OktaGroupManager) {
+		m.managerID = managerID
+	}
+}
+
+// WithDryRun makes the manager compute the changes to the Okta group without
+// making them. They are reported as events and returned by PlannedChanges.
+func WithDryRun(dryRun bool) OktaGroupManagerOption {
+	return func(m *OktaGroupManager) {
+		m.dryRun = dryRun
+	}
+}
+
+func NewOktaGroupManager(ctx context.Context, oktaGroupCRD *accessmanagerv1.OktaGroup, oktaAPI OktaAPI, recorder record.EventRecorder, opts ...OktaGroupManagerOption) (*OktaGroupManager, error) {
+	m := &OktaGroupManager{
 		ctx:          ctx,
-		client:       oktaClient,
+		client:       oktaAPI,
+		recorder:     recorder,
 		oktaGroupCRD: oktaGroupCRD,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.users == nil {
+		m.users = NewOktaUserResolver(0)
+	}
+	if m.composition == nil {
+		m.composition = &OktaGroupComposition{}
+	}
+	if m.managerID == "" {
+		m.managerID = DefaultManagerID
+	}
+	return m, nil
 }
 
-func (m *OktaGroupManager) UpsertOktaGroup() (*okta.Group, error) {
+// allPages follows the "next" links of a list response and returns the items of
+// every page, starting with the ones of the first response.
+func allPages[T any](ctx context.Context, items []T, resp *okta.Response, err error) ([]T, error) {
+	if err != nil {
+		return nil, err
+	}
+	for resp != nil && resp.HasNextPage() {
+		var page []T
+		if resp, err = resp.Next(ctx, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+	}
+	return items, nil
+}
+
+func (m *OktaGroupManager) UpsertOktaGroup() (_ *okta.Group, err error) {
+	ctx, span := startSpan(m.ctx, "UpsertOktaGroup")
+	defer func() { endSpan(span, err) }()
+
 	groupProfile := &okta.GroupProfile{
 		Name:        m.oktaGroupCRD.Name,
-		Description: m.oktaGroupCRD.Spec.Description,
+		Description: managedDescription(m.oktaGroupCRD.Spec.Description, m.managerID),
 	}
 
 	groupToUpsert := &okta.Group{
 		Profile: groupProfile,
 	}
 
-	// Search for the group by name
-	group, _ := m.SearchOktaGroup(m.oktaGroupCRD.Status.Id)
+	// Search for the group by Id, only create a new one if it doesn't exist anymore
+	group, err := m.searchOktaGroup(ctx, m.oktaGroupCRD.Status.Id)
+	if err != nil && !errors.Is(err, errGroupNotFound) {
+		return nil, err
+	}
 
-	// If the group is found, update it
+	// Adopt the existing group with the same name, if the policy allows it
+	if group == nil {
+		if group, err = m.adoptOktaGroup(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// The marker is only added to the groups that are created or adopted, the groups
+	// synced before it existed keep their description as is
+	if group != nil && !m.adopted && group.Profile != nil {
+		if _, managed := groupManager(group); !managed {
+			groupProfile.Description = m.oktaGroupCRD.Spec.Description
+		}
+	}
+
+	// If the group is found and its profile is up to date, there is nothing to do
+	if group != nil && group.Profile != nil &&
+		group.Profile.Name == groupProfile.Name && group.Profile.Description == groupProfile.Description {
+		return group, nil
+	}
+
+	// If the group is found, update it. The profile drifted if it changed while the
+	// spec didn't since the last sync, adding the marker isn't a change.
 	if group != nil {
-		group, resp, err := m.client.Group.UpdateGroup(m.ctx, group.Id, *groupToUpsert)
+		if m.oktaGroupCRD.Generation == m.oktaGroupCRD.Status.ObservedGeneration && !m.adopted &&
+			(group.Profile == nil || group.Profile.Name != groupProfile.Name ||
+				unmanagedDescription(group.Profile.Description) != m.oktaGroupCRD.Spec.Description) {
+			m.drift.Profile = true
+		}
+		if m.dryRun {
+			m.planChange(accessmanagerv1.OktaGroupChangeUpdateGroup, "", "",
+				"Would update Okta group %s (%s) to name %q and description %q", group.Profile.Name, group.Id, groupProfile.Name, groupProfile.Description)
+			return group, nil
+		}
+		group, resp, err := m.client.Group.UpdateGroup(ctx, group.Id, *groupToUpsert)
 		if err != nil {
 			log.Log.Error(err, "unable to update Okta group")
+			m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeWarning, EventReasonGroupUpdateFailed,
+				"Unable to update Okta group %s: %v", groupProfile.Name, err)
 			return nil, err
 		}
 		log.Log.Info("Updated Okta group", "group", group, "resp", resp)
+		m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonGroupUpdated,
+			"Updated Okta group %s (%s)", groupProfile.Name, group.Id)
 		return group, nil
 	}
 
-	// If the group is not found, create it
-	group, resp, err := m.client.Group.CreateGroup(m.ctx, *groupToUpsert)
+	// If the group is not found, create it. In dry-run the group to create is
+	// returned, without an ID.
+	if m.dryRun {
+		m.planChange(accessmanagerv1.OktaGroupChangeCreateGroup, "", "", "Would create Okta group %s", groupProfile.Name)
+		return groupToUpsert, nil
+	}
+	group, resp, err := m.client.Group.CreateGroup(ctx, *groupToUpsert)
 	if err != nil {
 		log.Log.Error(err, "unable to create Okta group")
+		m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeWarning, EventReasonGroupCreateFailed,
+			"Unable to create Okta group %s: %v", groupProfile.Name, err)
 		return nil, err
 	}
 
 	log.Log.Info("Created Okta group", "group", group, "resp", resp)
+	m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonGroupCreated,
+		"Created Okta group %s (%s)", groupProfile.Name, group.Id)
 
 	return group, nil
 }
@@ -71,96 +265,548 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-func (m *OktaGroupManager) searchUserByEmail(email string) (*okta.User, error) {
-	filter := fmt.Sprintf(`profile.email eq "%s"`, email)
-	queryParams := &query.Params{
-		Filter: filter,
+var errAmbiguousUser = errors.New("more than one Okta user has this email")
+
+// memberState maps the status of an Okta user to its sync state. Only active users
+// are added to the group.
+func memberState(user *okta.User) accessmanagerv1.OktaGroupMemberState {
+	switch user.Status {
+	case "ACTIVE":
+		return accessmanagerv1.OktaGroupMemberStateMember
+	case "STAGED", "PROVISIONED":
+		return accessmanagerv1.OktaGroupMemberStatePending
+	default:
+		return accessmanagerv1.OktaGroupMemberStateInactive
 	}
+}
 
-	users, _, err := m.client.User.ListUsers(m.ctx, queryParams)
-	if err != nil || len(users) == 0 {
-		e := errors.New("User not found")
-		log.Log.Error(err, e.Error(), "email", email)
-		return nil, e
+func userEmail(user *okta.User) string {
+	if user.Profile == nil {
+		return ""
 	}
-	if len(users) > 1 {
-		e := errors.New("more than one user found with that email")
-		log.Log.Error(err, e.Error(), "email", email)
-		return nil, e
+	email, _ := (*user.Profile)["email"].(string)
+	return email
+}
+
+func userLogin(user *okta.User) string {
+	if user.Profile == nil {
+		return ""
 	}
-	return users[0], nil
+	login, _ := (*user.Profile)["login"].(string)
+	return login
 }
 
-func (m *OktaGroupManager) UpsertUsersToOktaGroup(group *okta.Group) error {
+// UpsertUsersToOktaGroup adds the active users of the spec to the Okta group and
+// removes everyone else. It returns the sync result of every member of the spec; a
+// member that can't be looked up or added doesn't stop the others from being synced,
+// but makes it return an error so that the request is retried.
+func (m *OktaGroupManager) UpsertUsersToOktaGroup(group *okta.Group) (_ []accessmanagerv1.OktaGroupMemberStatus, err error) {
+	ctx, span := startSpan(m.ctx, "UpsertUsersToOktaGroup")
+	defer func() { endSpan(span, err) }()
+
 	if group == nil {
-		return errors.New("group is nil")
+		return nil, errors.New("group is nil")
 	}
 
-	oktaGroupUsersCRD := m.oktaGroupCRD.Spec.Users
+	// Okta compares emails and logins regardless of case, so do the comparisons below
+	membersCRD := accessmanagerv1.NormalizeMembers(slices.Concat(m.oktaGroupCRD.Spec.AllMembers(), m.granted, m.composition.Included))
 
-	groupUsers, _, err := m.client.Group.ListGroupUsers(m.ctx, group.Id, nil)
+	// A group whose creation was only planned has no users yet
+	var groupUsers []*okta.User
+	if group.Id != "" {
+		var resp *okta.Response
+		var err error
+		groupUsers, resp, err = m.client.Group.ListGroupUsers(ctx, group.Id, &query.Params{Limit: m.pageSize})
+		groupUsers, err = allPages(ctx, groupUsers, resp, err)
+		if err != nil {
+			log.Log.Error(err, "unable to list group users")
+			return nil, err
+		}
+	}
+
+	// The members were listed anyway, so their IDs and logins don't need to be looked
+	// up again, neither by this group nor by the others sharing the resolver
+	m.users.Seed(m.oktaGroupCRD.Spec.OktaOrgRef, groupUsers)
+
+	var errs []error
+	resolved, err := m.resolveMembers(ctx, accessmanagerv1.NormalizeMembers(slices.Concat(membersCRD, m.composition.Excluded)), groupUsers)
 	if err != nil {
-		log.Log.Error(err, "unable to list group users")
-		return err
+		log.Log.Error(err, "unable to search users")
+		errs = append(errs, err)
 	}
 
-	oktaGroupUsers := make([]string, len(groupUsers))
-	for i, user := range groupUsers {
-		oktaGroupUsers[i] = (*user.Profile)["email"].(string)
+	// The users of the excluded members are excluded too, whatever identifies them
+	excludedBy := map[string][]string{}
+	for _, member := range m.composition.Excluded {
+		for _, user := range resolved[member.Identifier()] {
+			excludedBy[user.Id] = append(excludedBy[user.Id], m.composition.ExcludedBy[member.Identifier()]...)
+		}
 	}
 
+	inGroup := map[string]bool{}
+	for _, user := range groupUsers {
+		inGroup[user.Id] = true
+	}
+	// keep are the IDs of the group users that are active members of the spec, and
+	// expired the ones whose membership expired
+	keep := map[string]bool{}
+	expired := map[string]bool{}
+	now := time.Now()
+
+	members := make([]accessmanagerv1.OktaGroupMemberStatus, 0, len(membersCRD))
+
 	// Add the users that are not in the Okta Group but were added to the Okta Group CRD
-	for _, userEmailCRD := range oktaGroupUsersCRD {
-		user, err := m.searchUserByEmail(userEmailCRD)
-		if err != nil {
-			continue
+	for _, memberCRD := range membersCRD {
+		identifier := memberCRD.Identifier()
+		member := accessmanagerv1.OktaGroupMemberStatus{Member: identifier, Email: memberCRD.Email, Login: memberCRD.Login}
+
+		var user *okta.User
+		matches, ok := resolved[identifier]
+		switch {
+		case !ok:
+			member.State = accessmanagerv1.OktaGroupMemberStateError
+			member.Message = fmt.Sprintf("unable to search user: %v", err)
+		case len(matches) == 0:
+			member.State = accessmanagerv1.OktaGroupMemberStateNotFound
+			member.Message = fmt.Sprintf("no Okta user has this %s", identifierKind(memberCRD))
+		case len(matches) > 1:
+			member.State = accessmanagerv1.OktaGroupMemberStateAmbiguous
+			member.Message = errAmbiguousUser.Error()
+		default:
+			user = matches[0]
+			member.UserID = user.Id
+			member.Email = strings.ToLower(userEmail(user))
+			member.Login = strings.ToLower(userLogin(user))
+			member.State = memberState(user)
 		}
 
-		if contains(oktaGroupUsers, userEmailCRD) {
-			log.Log.Info("User is already in Okta group", "user", user)
-			continue
+		// The user of an expired membership is removed, whatever its state
+		if expiresAt := m.memberExpiry(memberCRD); expiresAt != nil {
+			member.ExpiresAt = expiresAt
+			member.Duration = memberCRD.Duration
+			if !now.Before(expiresAt.Time) {
+				if user != nil {
+					expired[user.Id] = true
+				}
+				member.State = accessmanagerv1.OktaGroupMemberStateExpired
+				member.Message = fmt.Sprintf("membership expired at %s", expiresAt.UTC().Format(time.RFC3339))
+			}
 		}
 
-		// Skip if the user is not active
-		if user.Status != "ACTIVE" {
-			log.Log.Info("User is not active", "user", user)
-			continue
+		// The user of an excluded member is kept out, whatever its state
+		member.IncludedFrom = m.composition.IncludedFrom[identifier]
+		excludingGroups := m.composition.ExcludedBy[identifier]
+		if user != nil {
+			excludingGroups = append(slices.Clone(excludingGroups), excludedBy[user.Id]...)
+		}
+		if len(excludingGroups) > 0 {
+			member.State = accessmanagerv1.OktaGroupMemberStateExcluded
+			member.Message = fmt.Sprintf("excluded by OktaGroup %s", joinGroupNames(excludingGroups))
 		}
 
-		_, err = m.client.Group.AddUserToGroup(m.ctx, group.Id, user.Id)
-		if err != nil {
-			log.Log.Error(err, "unable to add user to Okta group")
-			return err
+		switch {
+		case member.State == accessmanagerv1.OktaGroupMemberStatePending || member.State == accessmanagerv1.OktaGroupMemberStateInactive:
+			// Skip if the user is not active
+			log.Log.Info("User is not active", "user", user)
+			member.Message = fmt.Sprintf("Okta user is %s", user.Status)
+
+		case member.State == accessmanagerv1.OktaGroupMemberStateMember && inGroup[user.Id]:
+			keep[user.Id] = true
+			m.startDuration(&member, memberCRD, now)
+			log.Log.Info("User is already in Okta group", "user", user)
+
+		case member.State == accessmanagerv1.OktaGroupMemberStateMember:
+			if m.wasMember(identifier) {
+				m.drift.RemovedUsers = append(m.drift.RemovedUsers, identifier)
+			}
+			if m.dryRun {
+				m.planChange(accessmanagerv1.OktaGroupChangeAddMember, identifier, user.Id,
+					"Would add user %s (%s) to Okta group", identifier, user.Id)
+				member.Message = "not added to the Okta group yet, the OktaGroup is synced in dry-run"
+				break
+			}
+			if _, err := m.client.Group.AddUserToGroup(ctx, group.Id, user.Id); err != nil {
+				log.Log.Error(err, "unable to add user to Okta group")
+				m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeWarning, EventReasonMemberAddFailed,
+					"Unable to add user %s (%s) to Okta group: %v", identifier, user.Id, err)
+				member.State = accessmanagerv1.OktaGroupMemberStateError
+				member.Message = err.Error()
+				errs = append(errs, fmt.Errorf("unable to add user %s to Okta group: %w", identifier, err))
+				break
+			}
+			m.startDuration(&member, memberCRD, now)
+			log.Log.Info("Added user to Okta group", "group", group, "user", user)
+			oktaGroupMembersAdded.WithLabelValues(m.oktaGroupCRD.Spec.OktaOrgRef, m.oktaGroupCRD.Name).Inc()
+			m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonMemberAdded,
+				"Added user %s (%s) to Okta group", identifier, user.Id)
 		}
-		log.Log.Info("Added user to Okta group", "group", group, "user", user)
+
+		members = append(members, m.withTransitionTime(member))
 	}
 
 	// Remove the users that are in the Okta Group but were removed from the Okta Group CRD
 	// Also remove those users that are not active
-	for _, userEmail := range oktaGroupUsers {
-		user, err := m.searchUserByEmail(userEmail)
-		if err != nil {
+	for _, user := range groupUsers {
+		if keep[user.Id] {
 			continue
 		}
+		// An active user nobody asked for was added outside of the operator, unless
+		// it was just removed from the spec
+		if user.Status == "ACTIVE" && !m.wasMemberUser(user) {
+			m.drift.AddedUsers = append(m.drift.AddedUsers, strings.ToLower(userEmail(user)))
+		}
 
-		if contains(oktaGroupUsersCRD, userEmail) && user.Status == "ACTIVE" {
+		cause := removalCause(user)
+		if expired[user.Id] {
+			cause = "its membership expired"
+		}
+		if excludingGroups := excludedBy[user.Id]; len(excludingGroups) > 0 {
+			cause = fmt.Sprintf("it is excluded by OktaGroup %s", joinGroupNames(excludingGroups))
+		}
+		if m.dryRun {
+			m.planChange(accessmanagerv1.OktaGroupChangeRemoveMember, strings.ToLower(userEmail(user)), user.Id,
+				"Would remove user %s (%s) from Okta group, %s", userEmail(user), user.Id, cause)
 			continue
 		}
 
-		_, err = m.client.Group.RemoveUserFromGroup(m.ctx, group.Id, user.Id)
+		_, err = m.client.Group.RemoveUserFromGroup(ctx, group.Id, user.Id)
 		if err != nil {
 			log.Log.Error(err, "unable to remove user from Okta group")
-			return err
+			m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeWarning, EventReasonMemberRemoveFailed,
+				"Unable to remove user %s (%s) from Okta group: %v", userEmail(user), user.Id, err)
+			errs = append(errs, fmt.Errorf("unable to remove user %s from Okta group: %w", userEmail(user), err))
+			continue
 		}
 		log.Log.Info("Removed user from Okta group", "group", group, "user", user)
+		oktaGroupMembersRemoved.WithLabelValues(m.oktaGroupCRD.Spec.OktaOrgRef, m.oktaGroupCRD.Name).Inc()
+		m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonMemberRemoved,
+			"Removed user %s (%s) from Okta group, %s", userEmail(user), user.Id, cause)
 	}
 
+	return members, errors.Join(errs...)
+}
+
+// planChange records a change that dry-run doesn't make, and reports it as an event.
+func (m *OktaGroupManager) planChange(action accessmanagerv1.OktaGroupChangeAction, member, userID, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	m.plan = append(m.plan, accessmanagerv1.OktaGroupPlannedChange{Action: action, Member: member, UserID: userID, Message: message})
+	log.Log.Info("Planned change to Okta group", "oktaGroup", m.oktaGroupCRD.Name, "change", message)
+	m.recorder.Event(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonChangePlanned, message)
+}
+
+// PlannedChanges returns the changes to the Okta group that weren't made because
+// the manager runs in dry-run, in the order they were computed.
+func (m *OktaGroupManager) PlannedChanges() []accessmanagerv1.OktaGroupPlannedChange {
+	return m.plan
+}
+
+// plannedChanges returns the number of planned changes with one of the actions.
+func (m *OktaGroupManager) plannedChanges(actions ...accessmanagerv1.OktaGroupChangeAction) int {
+	planned := 0
+	for _, change := range m.plan {
+		if slices.Contains(actions, change.Action) {
+			planned++
+		}
+	}
+	return planned
+}
+
+// PlannedGroupChanges returns the number of planned changes to the Okta group
+// itself, rather than to its members.
+func (m *OktaGroupManager) PlannedGroupChanges() int {
+	return m.plannedChanges(accessmanagerv1.OktaGroupChangeCreateGroup, accessmanagerv1.OktaGroupChangeAdoptGroup,
+		accessmanagerv1.OktaGroupChangeUpdateGroup, accessmanagerv1.OktaGroupChangeDeleteGroup, accessmanagerv1.OktaGroupChangeOrphanGroup)
+}
+
+// PlannedMemberChanges returns the number of planned changes to the members of the
+// Okta group.
+func (m *OktaGroupManager) PlannedMemberChanges() int {
+	return m.plannedChanges(accessmanagerv1.OktaGroupChangeAddMember, accessmanagerv1.OktaGroupChangeRemoveMember)
+}
+
+// GroupPlanned returns whether the creation or adoption of the Okta group returned
+// by UpsertOktaGroup was only planned in dry-run, so it isn't the Okta group of the
+// OktaGroup yet.
+func (m *OktaGroupManager) GroupPlanned() bool {
+	return m.plannedChanges(accessmanagerv1.OktaGroupChangeCreateGroup, accessmanagerv1.OktaGroupChangeAdoptGroup) > 0
+}
+
+// memberExpiry returns when the membership of a member expires, or nil if it never
+// does or its duration hasn't started yet. The duration of a member starts when its
+// user is first in the Okta group, and its expiry is kept from the last sync until
+// the duration changes.
+func (m *OktaGroupManager) memberExpiry(member accessmanagerv1.OktaGroupMember) *metav1.Time {
+	switch {
+	case member.ExpiresAt != nil:
+		return member.ExpiresAt
+	case member.Duration != nil:
+		return durationExpiry(m.oktaGroupCRD.Status.Members, member)
+	}
 	return nil
 }
 
-func (m *OktaGroupManager) DeleteOktaGroup() error {
+// durationExpiry returns the expiry of a member with a duration recorded in the
+// status members of its OktaGroup, or nil if its duration hasn't started yet or
+// changed since.
+func durationExpiry(statusMembers []accessmanagerv1.OktaGroupMemberStatus, member accessmanagerv1.OktaGroupMember) *metav1.Time {
+	for _, previous := range statusMembers {
+		if memberIdentifier(previous) == member.Identifier() && previous.ExpiresAt != nil &&
+			previous.Duration != nil && previous.Duration.Duration == member.Duration.Duration {
+			return previous.ExpiresAt
+		}
+	}
+	return nil
+}
+
+// startDuration sets the expiry of a member with a duration whose user is in the
+// Okta group, unless its duration already started.
+func (m *OktaGroupManager) startDuration(member *accessmanagerv1.OktaGroupMemberStatus, memberCRD accessmanagerv1.OktaGroupMember, now time.Time) {
+	if memberCRD.Duration == nil || member.ExpiresAt != nil {
+		return
+	}
+	expiresAt := metav1.NewTime(now.Add(memberCRD.Duration.Duration).Truncate(time.Second))
+	member.ExpiresAt = &expiresAt
+	member.Duration = memberCRD.Duration
+}
+
+// resolveMembers returns the Okta users that match each member of the spec, keyed
+// by its identifier, looking each one up by its most precise identifier. The members
+// already in the group are resolved from its users by ID or login, but emails are
+// always looked up, as users outside the group can share them. A member is missing
+// from the result if its lookup failed.
+func (m *OktaGroupManager) resolveMembers(ctx context.Context, membersCRD []accessmanagerv1.OktaGroupMember, groupUsers []*okta.User) (_ map[string][]*okta.User, err error) {
+	ctx, span := startSpan(ctx, "resolveMembers")
+	defer func() { endSpan(span, err) }()
+
+	byID := map[string]*okta.User{}
+	byLogin := map[string]*okta.User{}
+	for _, user := range groupUsers {
+		byID[user.Id] = user
+		byLogin[strings.ToLower(userLogin(user))] = user
+	}
+
+	resolved := map[string][]*okta.User{}
+	var ids, logins, emails []string
+	for _, member := range membersCRD {
+		switch {
+		case member.ID != "":
+			if user, ok := byID[member.ID]; ok {
+				resolved[member.Identifier()] = []*okta.User{user}
+				continue
+			}
+			ids = append(ids, member.ID)
+		case member.Login != "":
+			if user, ok := byLogin[member.Login]; ok {
+				resolved[member.Identifier()] = []*okta.User{user}
+				continue
+			}
+			logins = append(logins, member.Login)
+		default:
+			emails = append(emails, member.Email)
+		}
+	}
+
+	orgName := m.oktaGroupCRD.Spec.OktaOrgRef
+	foundIDs, idsErr := m.users.ResolveIDs(ctx, m.client.User, orgName, ids, m.pageSize)
+	for id, users := range foundIDs {
+		resolved[accessmanagerv1.OktaGroupMember{ID: id}.Identifier()] = users
+	}
+	foundLogins, loginsErr := m.users.ResolveLogins(ctx, m.client.User, orgName, logins, m.pageSize)
+	for login, users := range foundLogins {
+		resolved[accessmanagerv1.OktaGroupMember{Login: login}.Identifier()] = users
+	}
+	foundEmails, emailsErr := m.users.Resolve(ctx, m.client.User, orgName, emails, m.pageSize)
+	for email, users := range foundEmails {
+		resolved[accessmanagerv1.OktaGroupMember{Email: email}.Identifier()] = users
+	}
+	return resolved, errors.Join(idsErr, loginsErr, emailsErr)
+}
+
+// identifierKind names the identifier a member is looked up by.
+func identifierKind(member accessmanagerv1.OktaGroupMember) string {
+	switch {
+	case member.ID != "":
+		return "ID"
+	case member.Login != "":
+		return "login"
+	default:
+		return "email"
+	}
+}
+
+// adoptOktaGroup returns the existing Okta group with the name of the OktaGroup if
+// the adoption policy allows adopting it, or nil if it must be created.
+func (m *OktaGroupManager) adoptOktaGroup(ctx context.Context) (_ *okta.Group, err error) {
+	ctx, span := startSpan(ctx, "adoptOktaGroup")
+	defer func() { endSpan(span, err) }()
+
+	policy := m.oktaGroupCRD.Spec.AdoptionPolicy
+	if policy == "" || policy == accessmanagerv1.OktaGroupAdoptionPolicyNever {
+		return nil, nil
+	}
+
+	group, err := m.searchOktaGroupByName(ctx)
+	if errors.Is(err, errGroupNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	managerID, managed := groupManager(group)
+	if managed && managerID != m.managerID && policy == accessmanagerv1.OktaGroupAdoptionPolicyIfUnmanaged {
+		return nil, fmt.Errorf("Okta group %s (%s) is managed by %q, set the adoptionPolicy to Always to adopt it",
+			group.Profile.Name, group.Id, managerID)
+	}
+
+	m.adopted = true
+	if m.dryRun {
+		m.planChange(accessmanagerv1.OktaGroupChangeAdoptGroup, "", "",
+			"Would adopt existing Okta group %s (%s)", group.Profile.Name, group.Id)
+		return group, nil
+	}
+	log.Log.Info("Adopted Okta group", "group", group, "previousManager", managerID)
+	m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonGroupAdopted,
+		"Adopted existing Okta group %s (%s)", group.Profile.Name, group.Id)
+	return group, nil
+}
+
+// Adopted returns whether UpsertOktaGroup adopted an existing Okta group. A group
+// whose adoption was only planned in dry-run isn't adopted.
+func (m *OktaGroupManager) Adopted() bool {
+	return m.adopted && !m.dryRun
+}
+
+// managedDescription returns the description of an Okta group with the marker of
+// the given manager appended.
+func managedDescription(description, managerID string) string {
+	marker := managedMarkerPrefix + managerID + "]"
+	if description == "" {
+		return marker
+	}
+	return description + " " + marker
+}
+
+// unmanagedDescription returns the description of an Okta group without the marker
+// of its manager.
+func unmanagedDescription(description string) string {
+	if start := strings.LastIndex(description, managedMarkerPrefix); start >= 0 && strings.HasSuffix(description, "]") {
+		return strings.TrimSuffix(description[:start], " ")
+	}
+	return description
+}
+
+// groupManager returns the manager ID in the marker of the description of an Okta
+// group, and whether there is one.
+func groupManager(group *okta.Group) (string, bool) {
+	if group.Profile == nil {
+		return "", false
+	}
+	start := strings.LastIndex(group.Profile.Description, managedMarkerPrefix)
+	if start < 0 || !strings.HasSuffix(group.Profile.Description, "]") {
+		return "", false
+	}
+	return strings.TrimSuffix(group.Profile.Description[start+len(managedMarkerPrefix):], "]"), true
+}
+
+// Drift returns the changes made outside of the operator that were found and
+// reverted by UpsertOktaGroup and UpsertUsersToOktaGroup, or nil if there were none.
+// In dry-run they are only found, their revert is planned. It emits an event
+// describing them.
+func (m *OktaGroupManager) Drift() *accessmanagerv1.OktaGroupDrift {
+	// The differences of an adopted group are expected, they aren't drift
+	if m.adopted {
+		return nil
+	}
+	if !m.drift.Profile && len(m.drift.AddedUsers) == 0 && len(m.drift.RemovedUsers) == 0 {
+		return nil
+	}
+
+	drift := m.drift.DeepCopy()
+	drift.DetectedAt = metav1.Now()
+	action := "Reverted"
+	if m.dryRun {
+		action = "Found, but didn't revert in dry-run,"
+	}
+	m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeWarning, EventReasonDriftDetected,
+		"%s changes made outside of the operator: %d users added, %d users removed, profile changed: %t",
+		action, len(drift.AddedUsers), len(drift.RemovedUsers), drift.Profile)
+	return drift
+}
+
+// wasMember returns whether the last sync left the user of the member with the
+// given identifier in the group.
+func (m *OktaGroupManager) wasMember(identifier string) bool {
+	for _, previous := range m.oktaGroupCRD.Status.Members {
+		if memberIdentifier(previous) == identifier {
+			return previous.State == accessmanagerv1.OktaGroupMemberStateMember
+		}
+	}
+	return false
+}
+
+// wasMemberUser returns whether the last sync left the given user in the group, as
+// the user of any member of the spec.
+func (m *OktaGroupManager) wasMemberUser(user *okta.User) bool {
+	for _, previous := range m.oktaGroupCRD.Status.Members {
+		if previous.State != accessmanagerv1.OktaGroupMemberStateMember {
+			continue
+		}
+		if previous.UserID == user.Id || previous.UserID == "" && strings.EqualFold(previous.Email, userEmail(user)) {
+			return true
+		}
+	}
+	return false
+}
+
+// memberIdentifier returns the identifier of the member of a status, which is its
+// email in the statuses written before members could have other identifiers.
+func memberIdentifier(member accessmanagerv1.OktaGroupMemberStatus) string {
+	if member.Member == "" {
+		return strings.ToLower(member.Email)
+	}
+	return member.Member
+}
+
+// joinGroupNames lists the names of OktaGroups sorted and without duplicates.
+func joinGroupNames(names []string) string {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	return strings.Join(slices.Compact(names), ", ")
+}
+
+// removalCause explains why a user was removed from the Okta group.
+func removalCause(user *okta.User) string {
+	if user.Status != "ACTIVE" {
+		return fmt.Sprintf("the Okta user is %s", user.Status)
+	}
+	return "it isn't listed in the spec"
+}
+
+// withTransitionTime keeps the transition time of the previous status of the member
+// if its state didn't change.
+func (m *OktaGroupManager) withTransitionTime(member accessmanagerv1.OktaGroupMemberStatus) accessmanagerv1.OktaGroupMemberStatus {
+	for _, previous := range m.oktaGroupCRD.Status.Members {
+		if memberIdentifier(previous) == memberIdentifier(member) && previous.State == member.State {
+			member.LastTransitionTime = previous.LastTransitionTime
+			return member
+		}
+	}
+	member.LastTransitionTime = metav1.Now()
+	return member
+}
+
+func (m *OktaGroupManager) DeleteOktaGroup() (err error) {
+	ctx, span := startSpan(m.ctx, "DeleteOktaGroup")
+	defer func() { endSpan(span, err) }()
+
 	// Search for the group by name
-	group, err := m.SearchOktaGroup(m.oktaGroupCRD.Status.Id)
+	group, err := m.searchOktaGroup(ctx, m.oktaGroupCRD.Status.Id)
+
+	// If the group was never created or is already deleted, there is nothing to do
+	if errors.Is(err, errGroupNotFound) {
+		return nil
+	}
 
 	// If there is an error, log it and return it
 	if err != nil {
@@ -169,44 +815,157 @@ func (m *OktaGroupManager) DeleteOktaGroup() error {
 	}
 
 	// If the group is found, delete it
+	if group != nil && m.dryRun {
+		m.planChange(accessmanagerv1.OktaGroupChangeDeleteGroup, "", "",
+			"Would delete Okta group %s (%s)", group.Profile.Name, group.Id)
+		return nil
+	}
 	if group != nil {
-		resp, err := m.client.Group.DeleteGroup(m.ctx, group.Id)
+		resp, err := m.client.Group.DeleteGroup(ctx, group.Id)
 		if err != nil {
 			log.Log.Error(err, "unable to delete Okta group")
+			m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeWarning, EventReasonGroupDeleteFailed,
+				"Unable to delete Okta group %s (%s): %v", group.Profile.Name, group.Id, err)
 			return err
 		}
 
 		log.Log.Info("Deleted Okta group", "group", group, "resp", resp)
+		m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonGroupDeleted,
+			"Deleted Okta group %s (%s)", group.Profile.Name, group.Id)
+	}
+
+	return nil
+}
+
+// OrphanOktaGroup leaves the Okta group in Okta, removing its members first if
+// removeMembers is true. The marker of the operator is removed from its
+// description, so that it can be adopted again.
+func (m *OktaGroupManager) OrphanOktaGroup(removeMembers bool) (err error) {
+	ctx, span := startSpan(m.ctx, "OrphanOktaGroup", oktaRemoveMembersKey.Bool(removeMembers))
+	defer func() { endSpan(span, err) }()
+
+	group, err := m.searchOktaGroup(ctx, m.oktaGroupCRD.Status.Id)
+	if errors.Is(err, errGroupNotFound) {
+		return nil
+	}
+	if err != nil {
+		log.Log.Error(err, "unable to search Okta group")
+		return err
 	}
 
+	groupUsers, resp, err := m.client.Group.ListGroupUsers(ctx, group.Id, &query.Params{Limit: m.pageSize})
+	groupUsers, err = allPages(ctx, groupUsers, resp, err)
+	if err != nil {
+		log.Log.Error(err, "unable to list users of Okta group")
+		return err
+	}
+
+	if m.dryRun {
+		for _, user := range groupUsers {
+			if removeMembers {
+				m.planChange(accessmanagerv1.OktaGroupChangeRemoveMember, strings.ToLower(userEmail(user)), user.Id,
+					"Would remove user %s (%s) from Okta group before leaving it in Okta", userEmail(user), user.Id)
+			}
+		}
+		m.planChange(accessmanagerv1.OktaGroupChangeOrphanGroup, "", "",
+			"Would leave Okta group %s (%s) in Okta", group.Profile.Name, group.Id)
+		return nil
+	}
+
+	if removeMembers {
+		var errs []error
+		for _, user := range groupUsers {
+			if _, err := m.client.Group.RemoveUserFromGroup(ctx, group.Id, user.Id); err != nil {
+				log.Log.Error(err, "unable to remove user from Okta group")
+				m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeWarning, EventReasonMemberRemoveFailed,
+					"Unable to remove user %s (%s) from Okta group: %v", userEmail(user), user.Id, err)
+				errs = append(errs, fmt.Errorf("unable to remove user %s from Okta group: %w", userEmail(user), err))
+				continue
+			}
+			oktaGroupMembersRemoved.WithLabelValues(m.oktaGroupCRD.Spec.OktaOrgRef, m.oktaGroupCRD.Name).Inc()
+		}
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+	}
+
+	if group.Profile != nil && unmanagedDescription(group.Profile.Description) != group.Profile.Description {
+		profile := *group.Profile
+		profile.Description = unmanagedDescription(profile.Description)
+		if _, _, err := m.client.Group.UpdateGroup(ctx, group.Id, okta.Group{Profile: &profile}); err != nil {
+			log.Log.Error(err, "unable to update Okta group")
+			m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeWarning, EventReasonGroupUpdateFailed,
+				"Unable to update Okta group %s: %v", profile.Name, err)
+			return err
+		}
+	}
+
+	log.Log.Info("Orphaned Okta group", "group", group, "removeMembers", removeMembers)
+	if removeMembers {
+		m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonGroupOrphaned,
+			"Removed the %d members of Okta group %s (%s) and left the empty group in Okta", len(groupUsers), group.Profile.Name, group.Id)
+	} else {
+		m.recorder.Eventf(m.oktaGroupCRD, corev1.EventTypeNormal, EventReasonGroupOrphaned,
+			"Left Okta group %s (%s) and its %d members in Okta", group.Profile.Name, group.Id, len(groupUsers))
+	}
 	return nil
 }
 
+// SearchOktaGroupByName returns the Okta group with the name of the OktaGroup, or
+// errGroupNotFound if there is none.
 func (m *OktaGroupManager) SearchOktaGroupByName() (*okta.Group, error) {
+	return m.searchOktaGroupByName(m.ctx)
+}
+
+func (m *OktaGroupManager) searchOktaGroupByName(ctx context.Context) (_ *okta.Group, err error) {
+	ctx, span := startSpan(ctx, "SearchOktaGroupByName")
+	defer func() { endSpan(span, err) }()
+
 	// Search for the group by name
-	groups, _, err := m.client.Group.ListGroups(m.ctx, &query.Params{Q: m.oktaGroupCRD.Name})
+	groups, resp, err := m.client.Group.ListGroups(ctx, &query.Params{Q: m.oktaGroupCRD.Name, Limit: m.pageSize})
+	groups, err = allPages(ctx, groups, resp, err)
 	if err != nil {
 		log.Log.Error(err, "unable to list Okta groups")
 		return nil, err
 	}
 
-	// If the group is found, return it
+	// The search matches prefixes, only a group with the exact name is returned
+	var found *okta.Group
 	for _, group := range groups {
-		if group.Profile.Name == m.oktaGroupCRD.Name {
-			return group, nil
+		if group.Profile == nil || group.Profile.Name != m.oktaGroupCRD.Name {
+			continue
+		}
+		if found != nil {
+			return nil, errAmbiguousGroup
 		}
+		found = group
 	}
 
 	// If the group is not found, return an error
-	return nil, errors.New("group not found")
+	if found == nil {
+		return nil, errGroupNotFound
+	}
+	return found, nil
 }
 
+// SearchOktaGroup returns the Okta group with the given Id, or an error wrapping
+// errGroupNotFound if there is none.
 func (m *OktaGroupManager) SearchOktaGroup(Id string) (*okta.Group, error) {
+	return m.searchOktaGroup(m.ctx, Id)
+}
+
+func (m *OktaGroupManager) searchOktaGroup(ctx context.Context, Id string) (_ *okta.Group, err error) {
+	ctx, span := startSpan(ctx, "SearchOktaGroup", oktaGroupIDKey.String(Id))
+	defer func() { endSpan(span, err) }()
+
 	if Id == "" {
-		return nil, errors.New("Id is empty")
+		return nil, fmt.Errorf("Id is empty: %w", errGroupNotFound)
 	}
 
-	oktaGroupAPI, _, err := m.client.Group.GetGroup(m.ctx, Id)
+	oktaGroupAPI, resp, err := m.client.Group.GetGroup(ctx, Id)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("Okta group %s: %w", Id, errGroupNotFound)
+	}
 	if err != nil {
 		log.Log.Error(err, "unable to get OktaGroupAPI")
 		return nil, err
@@ -0,0 +1,707 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+	"github.com/franciscoprin/access-manager-operator/internal/oktafake"
+)
+
+// newTestOktaAPI returns the Okta API of a client that sends its requests to the given handler.
+func newTestOktaAPI(t *testing.T, handler http.Handler) OktaAPI {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	_, oktaClient, err := okta.NewClient(context.TODO(),
+		okta.WithCache(false),
+		okta.WithOrgUrl(server.URL),
+		okta.WithToken("test-token"),
+		okta.WithTestingDisableHttpsCheck(true),
+		okta.WithRateLimitMaxRetries(0),
+	)
+	assert.NoError(t, err)
+	return NewOktaAPI(oktaClient)
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// writeTestMatchingUsers answers a ListUsers request with the given users, as JSON,
+// whose email is in its filter.
+func writeTestMatchingUsers(w http.ResponseWriter, r *http.Request, users ...string) {
+	var matches []string
+	for _, user := range users {
+		var decoded okta.User
+		json.Unmarshal([]byte(user), &decoded)
+		if strings.Contains(r.URL.Query().Get("filter"), fmt.Sprintf("%q", userEmail(&decoded))) {
+			matches = append(matches, user)
+		}
+	}
+	writeTestJSON(w, http.StatusOK, "["+strings.Join(matches, ",")+"]")
+}
+
+func TestMemberState(t *testing.T) {
+	for status, expected := range map[string]accessmanagerv1.OktaGroupMemberState{
+		"ACTIVE":        accessmanagerv1.OktaGroupMemberStateMember,
+		"STAGED":        accessmanagerv1.OktaGroupMemberStatePending,
+		"PROVISIONED":   accessmanagerv1.OktaGroupMemberStatePending,
+		"SUSPENDED":     accessmanagerv1.OktaGroupMemberStateInactive,
+		"DEPROVISIONED": accessmanagerv1.OktaGroupMemberStateInactive,
+	} {
+		assert.Equal(t, expected, memberState(&okta.User{Status: status}), status)
+	}
+}
+
+func TestOktaGroupManager_WithTransitionTime(t *testing.T) {
+	lastHour := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	manager := &OktaGroupManager{oktaGroupCRD: &accessmanagerv1.OktaGroup{
+		Status: accessmanagerv1.OktaGroupStatus{
+			Members: []accessmanagerv1.OktaGroupMemberStatus{
+				{Email: "john@example.com", State: accessmanagerv1.OktaGroupMemberStateMember, LastTransitionTime: lastHour},
+				{Email: "jane@example.com", State: accessmanagerv1.OktaGroupMemberStatePending, LastTransitionTime: lastHour},
+			},
+		},
+	}}
+
+	unchanged := manager.withTransitionTime(accessmanagerv1.OktaGroupMemberStatus{
+		Email: "john@example.com", State: accessmanagerv1.OktaGroupMemberStateMember,
+	})
+	assert.Equal(t, lastHour, unchanged.LastTransitionTime)
+
+	activated := manager.withTransitionTime(accessmanagerv1.OktaGroupMemberStatus{
+		Email: "jane@example.com", State: accessmanagerv1.OktaGroupMemberStateMember,
+	})
+	assert.True(t, activated.LastTransitionTime.After(lastHour.Time))
+}
+
+func TestOktaGroupManager_EmitsEvents(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/groups", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `{"id": "00g1", "profile": {"name": "developers"}}`)
+	})
+	mux.HandleFunc("GET /api/v1/groups/00g1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[{"id": "00u2", "status": "ACTIVE", "profile": {"email": "former@example.com"}}]`)
+	})
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[{"id": "00u1", "status": "ACTIVE", "profile": {"email": "john@example.com"}}]`)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1/users/00u1", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1/users/00u2", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusForbidden, `{"errorCode": "E0000006", "errorSummary": "You do not have permission"}`)
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: []string{"john@example.com"}},
+	}
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), recorder)
+
+	group, err := manager.UpsertOktaGroup()
+	assert.NoError(t, err)
+	_, err = manager.UpsertUsersToOktaGroup(group)
+	assert.ErrorContains(t, err, "unable to remove user former@example.com")
+
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	assert.Equal(t, []string{
+		"Normal GroupCreated Created Okta group developers (00g1)",
+		"Normal MemberAdded Added user john@example.com (00u1) to Okta group",
+	}, events[:2])
+	assert.Contains(t, events[2], "Warning MemberRemoveFailed Unable to remove user former@example.com (00u2) from Okta group: ")
+}
+
+func TestOktaGroupManager_SkipsUpdateOfUnchangedGroup(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `{"id": "00g1", "profile": {"name": "developers", "description": "Developers [managed by access-manager-operator: access-manager-operator]"}}`)
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Description: "Developers"},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: "00g1"},
+	}
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), recorder)
+
+	group, err := manager.UpsertOktaGroup()
+	assert.NoError(t, err)
+	assert.Equal(t, "00g1", group.Id)
+	assert.Empty(t, recorder.Events)
+}
+
+func TestOktaGroupManager_FollowsPagination(t *testing.T) {
+	var groupUsers []string
+	for i := 1; i <= 5; i++ {
+		groupUsers = append(groupUsers, fmt.Sprintf(`{"id": "00u%d", "status": "ACTIVE", "profile": {"email": "user%d@example.com"}}`, i, i))
+	}
+
+	var added, removed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1/users", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "2", r.URL.Query().Get("limit"))
+		after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		end := min(after+2, len(groupUsers))
+		if end < len(groupUsers) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v1/groups/00g1/users?after=%d&limit=2>; rel="next"`, "http://"+r.Host, end))
+		}
+		writeTestJSON(w, http.StatusOK, "["+strings.Join(groupUsers[after:end], ",")+"]")
+	})
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestMatchingUsers(w, r, groupUsers...)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		added = append(added, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		removed = append(removed, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: []string{"user1@example.com", "user5@example.com"}},
+	}
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), record.NewFakeRecorder(10), WithPageSize(2))
+
+	members, err := manager.UpsertUsersToOktaGroup(&okta.Group{Id: "00g1"})
+	assert.NoError(t, err)
+	assert.Empty(t, added, "members of later pages must not be added again")
+	assert.Equal(t, []string{"00u2", "00u3", "00u4"}, removed)
+	for _, member := range members {
+		assert.Equal(t, accessmanagerv1.OktaGroupMemberStateMember, member.State)
+	}
+}
+
+func TestOktaGroupManager_DetectsDrift(t *testing.T) {
+	var added, removed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `{"id": "00g1", "profile": {"name": "developers", "description": "Changed in the console"}}`)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `{"id": "00g1", "profile": {"name": "developers", "description": "Developers"}}`)
+	})
+	mux.HandleFunc("GET /api/v1/groups/00g1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[
+			{"id": "00u2", "status": "ACTIVE", "profile": {"email": "jane@example.com"}},
+			{"id": "00u3", "status": "ACTIVE", "profile": {"email": "former@example.com"}},
+			{"id": "00u4", "status": "SUSPENDED", "profile": {"email": "suspended@example.com"}}
+		]`)
+	})
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[
+			{"id": "00u1", "status": "ACTIVE", "profile": {"email": "john@example.com"}},
+			{"id": "00u5", "status": "ACTIVE", "profile": {"email": "new@example.com"}}
+		]`)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		added = append(added, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		removed = append(removed, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// john was synced before and removed in Okta, new was just added to the spec and
+	// former just removed from it, while jane was added in Okta
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers", Generation: 2},
+		Spec: accessmanagerv1.OktaGroupSpec{
+			Description: "Developers",
+			Users:       []string{"john@example.com", "new@example.com"},
+		},
+		Status: accessmanagerv1.OktaGroupStatus{
+			Id:                 "00g1",
+			ObservedGeneration: 2,
+			Members: []accessmanagerv1.OktaGroupMemberStatus{
+				{Email: "john@example.com", State: accessmanagerv1.OktaGroupMemberStateMember},
+				{Email: "former@example.com", State: accessmanagerv1.OktaGroupMemberStateMember},
+			},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), recorder)
+
+	group, err := manager.UpsertOktaGroup()
+	assert.NoError(t, err)
+	_, err = manager.UpsertUsersToOktaGroup(group)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"00u1", "00u5"}, added)
+	assert.ElementsMatch(t, []string{"00u2", "00u3", "00u4"}, removed)
+
+	drift := manager.Drift()
+	if assert.NotNil(t, drift) {
+		assert.Equal(t, []string{"jane@example.com"}, drift.AddedUsers)
+		assert.Equal(t, []string{"john@example.com"}, drift.RemovedUsers)
+		assert.True(t, drift.Profile)
+		assert.False(t, drift.DetectedAt.IsZero())
+	}
+
+	// In dry-run the drift is found, but not reverted
+	added, removed = nil, nil
+	recorder = record.NewFakeRecorder(20)
+	manager, _ = NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), recorder, WithDryRun(true))
+	group, err = manager.UpsertOktaGroup()
+	assert.NoError(t, err)
+	_, err = manager.UpsertUsersToOktaGroup(group)
+	assert.NoError(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.NotNil(t, manager.Drift())
+	var event string
+	for len(recorder.Events) > 0 {
+		event = <-recorder.Events
+	}
+	assert.Equal(t, "Warning DriftDetected Found, but didn't revert in dry-run, changes made outside of the operator: 1 users added, 1 users removed, profile changed: true", event)
+
+	// Changes of the spec aren't drift
+	oktaGroupCRD.Generation = 3
+	manager, _ = NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), recorder)
+	_, err = manager.UpsertOktaGroup()
+	assert.NoError(t, err)
+	assert.Nil(t, manager.Drift())
+}
+
+func TestOktaGroupManager_AdoptsExistingGroups(t *testing.T) {
+	var created, updated int
+	groups := map[string]string{
+		"developers": `{"id": "00g1", "profile": {"name": "developers", "description": "Created in the console"}}`,
+		"admins":     `{"id": "00g2", "profile": {"name": "admins", "description": "[managed by access-manager-operator: other-cluster]"}}`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/{groupId}", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusNotFound, `{"errorCode": "E0000007", "errorSummary": "Not found: Resource not found"}`)
+	})
+	mux.HandleFunc("GET /api/v1/groups", func(w http.ResponseWriter, r *http.Request) {
+		group, ok := groups[r.URL.Query().Get("q")]
+		if !ok {
+			writeTestJSON(w, http.StatusOK, "[]")
+			return
+		}
+		// The search matches prefixes
+		writeTestJSON(w, http.StatusOK, `[`+group+`, {"id": "00g9", "profile": {"name": "`+r.URL.Query().Get("q")+`-old"}}]`)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/{groupId}", func(w http.ResponseWriter, r *http.Request) {
+		updated++
+		writeTestJSON(w, http.StatusOK, `{"id": "`+r.PathValue("groupId")+`", "profile": {"name": "adopted"}}`)
+	})
+	mux.HandleFunc("POST /api/v1/groups", func(w http.ResponseWriter, r *http.Request) {
+		created++
+		writeTestJSON(w, http.StatusOK, `{"id": "00g3", "profile": {"name": "created"}}`)
+	})
+	oktaAPI := newTestOktaAPI(t, mux)
+
+	upsert := func(name string, policy accessmanagerv1.OktaGroupAdoptionPolicy) (*OktaGroupManager, *okta.Group, error) {
+		oktaGroupCRD := &accessmanagerv1.OktaGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       accessmanagerv1.OktaGroupSpec{AdoptionPolicy: policy},
+			// The group was deleted from Okta since the last sync
+			Status: accessmanagerv1.OktaGroupStatus{Id: "00g0"},
+		}
+		manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, oktaAPI, record.NewFakeRecorder(10))
+		group, err := manager.UpsertOktaGroup()
+		return manager, group, err
+	}
+
+	// Never creates the group, even if one has the same name
+	manager, group, err := upsert("developers", accessmanagerv1.OktaGroupAdoptionPolicyNever)
+	assert.NoError(t, err)
+	assert.Equal(t, "00g3", group.Id)
+	assert.False(t, manager.Adopted())
+	assert.Equal(t, 1, created)
+
+	manager, group, err = upsert("developers", accessmanagerv1.OktaGroupAdoptionPolicyIfUnmanaged)
+	assert.NoError(t, err)
+	assert.Equal(t, "00g1", group.Id)
+	assert.True(t, manager.Adopted())
+	assert.Nil(t, manager.Drift())
+	assert.Equal(t, 1, updated)
+
+	_, _, err = upsert("admins", accessmanagerv1.OktaGroupAdoptionPolicyIfUnmanaged)
+	assert.ErrorContains(t, err, `is managed by "other-cluster"`)
+	assert.Equal(t, 1, updated)
+
+	manager, group, err = upsert("admins", accessmanagerv1.OktaGroupAdoptionPolicyAlways)
+	assert.NoError(t, err)
+	assert.Equal(t, "00g2", group.Id)
+	assert.True(t, manager.Adopted())
+
+	// Without a group with the same name, the group is created
+	manager, group, err = upsert("readers", accessmanagerv1.OktaGroupAdoptionPolicyAlways)
+	assert.NoError(t, err)
+	assert.Equal(t, "00g3", group.Id)
+	assert.False(t, manager.Adopted())
+	assert.Equal(t, 2, created)
+}
+
+func TestManagedDescription(t *testing.T) {
+	description := managedDescription("Developers", "prod-cluster")
+	assert.Equal(t, "Developers [managed by access-manager-operator: prod-cluster]", description)
+	assert.Equal(t, "Developers", unmanagedDescription(description))
+
+	managerID, managed := groupManager(&okta.Group{Profile: &okta.GroupProfile{Description: description}})
+	assert.True(t, managed)
+	assert.Equal(t, "prod-cluster", managerID)
+
+	_, managed = groupManager(&okta.Group{Profile: &okta.GroupProfile{Description: "Developers"}})
+	assert.False(t, managed)
+	assert.Equal(t, "[managed by access-manager-operator: prod-cluster]", managedDescription("", "prod-cluster"))
+}
+
+func TestOktaGroupManager_OrphansGroup(t *testing.T) {
+	var removed []string
+	var description string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `{"id": "00g1", "profile": {"name": "developers", "description": "Developers [managed by access-manager-operator: access-manager-operator]"}}`)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1", func(w http.ResponseWriter, r *http.Request) {
+		group := &okta.Group{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(group))
+		description = group.Profile.Description
+		writeTestJSON(w, http.StatusOK, `{"id": "00g1", "profile": {"name": "developers"}}`)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the orphaned Okta group must not be deleted")
+	})
+	mux.HandleFunc("GET /api/v1/groups/00g1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[
+			{"id": "00u1", "status": "ACTIVE", "profile": {"email": "john@example.com"}},
+			{"id": "00u2", "status": "ACTIVE", "profile": {"email": "jane@example.com"}}
+		]`)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		removed = append(removed, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	oktaAPI := newTestOktaAPI(t, mux)
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: "00g1"},
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, oktaAPI, recorder)
+	assert.NoError(t, manager.OrphanOktaGroup(false))
+	assert.Empty(t, removed)
+	assert.Equal(t, "Developers", description, "the marker must be removed so that the group can be adopted")
+	assert.Equal(t, "Normal GroupOrphaned Left Okta group developers (00g1) and its 2 members in Okta", <-recorder.Events)
+
+	assert.NoError(t, manager.OrphanOktaGroup(true))
+	assert.Equal(t, []string{"00u1", "00u2"}, removed)
+	assert.Equal(t, "Normal GroupOrphaned Removed the 2 members of Okta group developers (00g1) and left the empty group in Okta", <-recorder.Events)
+
+	// A group that was never created has nothing to clean up
+	manager, _ = NewOktaGroupManager(context.TODO(), &accessmanagerv1.OktaGroup{}, oktaAPI, recorder)
+	assert.NoError(t, manager.DeleteOktaGroup())
+	assert.NoError(t, manager.OrphanOktaGroup(true))
+	assert.Empty(t, recorder.Events)
+}
+
+func TestOktaGroupManager_ComparesEmailsRegardlessOfCase(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[{"id": "00u1", "status": "ACTIVE", "profile": {"email": "jane@corp.com"}}]`)
+	})
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestMatchingUsers(w, r, `{"id": "00u1", "status": "ACTIVE", "profile": {"email": "jane@corp.com"}}`)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("user %s must not be added again", r.PathValue("userId"))
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("user %s must not be removed", r.PathValue("userId"))
+	})
+
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: []string{"Jane@Corp.com ", "jane@corp.com"}},
+	}
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), record.NewFakeRecorder(10))
+
+	members, err := manager.UpsertUsersToOktaGroup(&okta.Group{Id: "00g1"})
+	assert.NoError(t, err)
+	if assert.Len(t, members, 1) {
+		assert.Equal(t, "jane@corp.com", members[0].Email)
+		assert.Equal(t, accessmanagerv1.OktaGroupMemberStateMember, members[0].State)
+	}
+	assert.Nil(t, manager.Drift())
+}
+
+func TestOktaGroupManager_ResolvesMembersByMostPreciseIdentifier(t *testing.T) {
+	var added, removed, filters []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[{"id": "00u4", "status": "ACTIVE", "profile": {"login": "john", "email": "john@corp.com"}}]`)
+	})
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		filter := r.URL.Query().Get("filter")
+		filters = append(filters, filter)
+		switch filter {
+		case `profile.login eq "svc-ci"`:
+			writeTestJSON(w, http.StatusOK, `[{"id": "00u1", "status": "ACTIVE", "profile": {"login": "svc-ci", "email": "robots@corp.com"}}]`)
+		case `id eq "00u3" or id eq "00u9"`:
+			writeTestJSON(w, http.StatusOK, `[{"id": "00u3", "status": "ACTIVE", "profile": {"login": "svc-deploy", "email": "robots@corp.com"}}]`)
+		default:
+			writeTestJSON(w, http.StatusOK, `[
+				{"id": "00u1", "status": "ACTIVE", "profile": {"login": "svc-ci", "email": "robots@corp.com"}},
+				{"id": "00u3", "status": "ACTIVE", "profile": {"login": "svc-deploy", "email": "robots@corp.com"}}
+			]`)
+		}
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		added = append(added, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		removed = append(removed, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// The service accounts share a mailbox, so only their logins and IDs identify them
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "robots"},
+		Spec: accessmanagerv1.OktaGroupSpec{
+			Users: []string{"robots@corp.com"},
+			Members: []accessmanagerv1.OktaGroupMember{
+				{Login: "SVC-CI", Email: "robots@corp.com"},
+				{ID: "00u3", Login: "ignored"},
+				{ID: "00u9"},
+			},
+		},
+	}
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), record.NewFakeRecorder(10))
+
+	members, err := manager.UpsertUsersToOktaGroup(&okta.Group{Id: "00g1"})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{`profile.login eq "svc-ci"`, `id eq "00u3" or id eq "00u9"`, `profile.email eq "robots@corp.com"`}, filters)
+	assert.ElementsMatch(t, []string{"00u1", "00u3"}, added)
+	assert.Equal(t, []string{"00u4"}, removed)
+
+	states := map[string]accessmanagerv1.OktaGroupMemberState{}
+	for _, member := range members {
+		states[member.Member] = member.State
+	}
+	assert.Equal(t, map[string]accessmanagerv1.OktaGroupMemberState{
+		"id:00u3":         accessmanagerv1.OktaGroupMemberStateMember,
+		"id:00u9":         accessmanagerv1.OktaGroupMemberStateNotFound,
+		"login:svc-ci":    accessmanagerv1.OktaGroupMemberStateMember,
+		"robots@corp.com": accessmanagerv1.OktaGroupMemberStateAmbiguous,
+	}, states)
+}
+
+func TestOktaGroupManager_LooksUpSharedEmailsOfGroupUsers(t *testing.T) {
+	var added, removed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[{"id": "00u1", "status": "ACTIVE", "profile": {"login": "svc-ci", "email": "robots@corp.com"}}]`)
+	})
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestMatchingUsers(w, r,
+			`{"id": "00u1", "status": "ACTIVE", "profile": {"login": "svc-ci", "email": "robots@corp.com"}}`,
+			`{"id": "00u2", "status": "ACTIVE", "profile": {"login": "svc-deploy", "email": "robots@corp.com"}}`)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		added = append(added, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		removed = append(removed, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	oktaAPI := newTestOktaAPI(t, mux)
+	users := NewOktaUserResolver(time.Minute)
+
+	// Only one of the users sharing the email is in the group, the email is still
+	// ambiguous
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "robots"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Users: []string{"robots@corp.com"}},
+	}
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, oktaAPI, record.NewFakeRecorder(10), WithUserResolver(users))
+	members, err := manager.UpsertUsersToOktaGroup(&okta.Group{Id: "00g1"})
+	assert.NoError(t, err)
+	if assert.Len(t, members, 1) {
+		assert.Equal(t, accessmanagerv1.OktaGroupMemberStateAmbiguous, members[0].State)
+	}
+	assert.Empty(t, added)
+	assert.Equal(t, []string{"00u1"}, removed)
+
+	// Neither are the other OktaGroups sharing the resolver given the user of the group
+	resolved, err := users.Resolve(context.TODO(), oktaAPI.User, "", []string{"robots@corp.com"}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, resolved["robots@corp.com"], 2)
+}
+
+func TestOktaGroupManager_RemovesExpiredMembers(t *testing.T) {
+	var added, removed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `[
+			{"id": "00u1", "status": "ACTIVE", "profile": {"login": "john", "email": "john@corp.com"}},
+			{"id": "00u2", "status": "ACTIVE", "profile": {"login": "jane", "email": "jane@corp.com"}},
+			{"id": "00u4", "status": "ACTIVE", "profile": {"login": "eve", "email": "eve@corp.com"}}
+		]`)
+	})
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		writeTestMatchingUsers(w, r,
+			`{"id": "00u1", "status": "ACTIVE", "profile": {"login": "john", "email": "john@corp.com"}}`,
+			`{"id": "00u2", "status": "ACTIVE", "profile": {"login": "jane", "email": "jane@corp.com"}}`,
+			`{"id": "00u3", "status": "ACTIVE", "profile": {"login": "adam", "email": "adam@corp.com"}}`,
+			`{"id": "00u4", "status": "ACTIVE", "profile": {"login": "eve", "email": "eve@corp.com"}}`)
+	})
+	mux.HandleFunc("PUT /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		added = append(added, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		removed = append(removed, r.PathValue("userId"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	expired := metav1.NewTime(time.Now().Add(-time.Minute))
+	synced := metav1.NewTime(time.Now().Add(30 * time.Minute))
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "on-call"},
+		Spec: accessmanagerv1.OktaGroupSpec{
+			Members: []accessmanagerv1.OktaGroupMember{
+				{Email: "john@corp.com", ExpiresAt: &expired},
+				{Email: "jane@corp.com", Duration: &metav1.Duration{Duration: time.Hour}},
+				{Email: "adam@corp.com", Duration: &metav1.Duration{Duration: time.Hour}},
+				{Email: "eve@corp.com", Duration: &metav1.Duration{Duration: 2 * time.Hour}},
+				{Email: "ghost@corp.com", Duration: &metav1.Duration{Duration: time.Hour}},
+			},
+		},
+		// jane was synced before, so her membership keeps its expiry, while the
+		// duration of eve was extended since
+		Status: accessmanagerv1.OktaGroupStatus{
+			Members: []accessmanagerv1.OktaGroupMemberStatus{
+				{Member: "jane@corp.com", Email: "jane@corp.com", UserID: "00u2", ExpiresAt: &synced, Duration: &metav1.Duration{Duration: time.Hour}},
+				{Member: "eve@corp.com", Email: "eve@corp.com", UserID: "00u4", ExpiresAt: &synced, Duration: &metav1.Duration{Duration: time.Hour}},
+			},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), recorder)
+
+	members, err := manager.UpsertUsersToOktaGroup(&okta.Group{Id: "00g1", Profile: &okta.GroupProfile{Name: "on-call"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"00u3"}, added)
+	assert.Equal(t, []string{"00u1"}, removed)
+
+	expiries := map[string]time.Time{}
+	for _, member := range members {
+		switch member.Member {
+		case "john@corp.com":
+			assert.Equal(t, accessmanagerv1.OktaGroupMemberStateExpired, member.State)
+		case "ghost@corp.com":
+			// The duration of a member that isn't in the Okta group doesn't start
+			assert.Equal(t, accessmanagerv1.OktaGroupMemberStateNotFound, member.State)
+			assert.Nil(t, member.ExpiresAt)
+			continue
+		default:
+			assert.Equal(t, accessmanagerv1.OktaGroupMemberStateMember, member.State)
+		}
+		if assert.NotNil(t, member.ExpiresAt, member.Member) {
+			expiries[member.Member] = member.ExpiresAt.Time
+		}
+	}
+	assert.Equal(t, expired.Time, expiries["john@corp.com"])
+	assert.Equal(t, synced.Time, expiries["jane@corp.com"])
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiries["adam@corp.com"], 5*time.Second)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expiries["eve@corp.com"], 5*time.Second)
+
+	close(recorder.Events)
+	var removedEvents []string
+	for event := range recorder.Events {
+		if strings.Contains(event, EventReasonMemberRemoved) {
+			removedEvents = append(removedEvents, event)
+		}
+	}
+	assert.Equal(t, []string{"Normal MemberRemoved Removed user john@corp.com (00u1) from Okta group, its membership expired"}, removedEvents)
+}
+
+func TestOktaGroupManager_PlansDeletionInDryRun(t *testing.T) {
+	ctx := context.TODO()
+	server := oktafake.NewServer()
+	defer server.Close()
+	oktaClient, err := server.Client(ctx)
+	assert.NoError(t, err)
+
+	john := server.AddUser("john", "john@example.com", oktafake.StatusActive)
+	group := server.AddGroup("developers", managedDescription("", DefaultManagerID), john.Id)
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: group.Id},
+	}
+
+	manager, _ := NewOktaGroupManager(ctx, oktaGroupCRD, NewOktaAPI(oktaClient), record.NewFakeRecorder(10), WithDryRun(true))
+	assert.NoError(t, manager.DeleteOktaGroup())
+	assert.NoError(t, manager.OrphanOktaGroup(true))
+	assert.NotNil(t, server.Group(group.Id))
+	assert.Equal(t, []string{john.Id}, server.GroupUsers(group.Id))
+
+	var actions []accessmanagerv1.OktaGroupChangeAction
+	for _, change := range manager.PlannedChanges() {
+		actions = append(actions, change.Action)
+	}
+	assert.Equal(t, []accessmanagerv1.OktaGroupChangeAction{
+		accessmanagerv1.OktaGroupChangeDeleteGroup, accessmanagerv1.OktaGroupChangeRemoveMember, accessmanagerv1.OktaGroupChangeOrphanGroup,
+	}, actions)
+}
+
+func TestOktaGroupManager_KeepsTheDescriptionOfGroupsSyncedWithoutMarker(t *testing.T) {
+	ctx := context.TODO()
+	server := oktafake.NewServer()
+	defer server.Close()
+	oktaClient, err := server.Client(ctx)
+	assert.NoError(t, err)
+
+	// The group was synced before the operator marked the groups it manages
+	group := server.AddGroup("developers", "Developers")
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers", Generation: 1},
+		Spec:       accessmanagerv1.OktaGroupSpec{Description: "Developers"},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: group.Id, ObservedGeneration: 1},
+	}
+
+	manager, _ := NewOktaGroupManager(ctx, oktaGroupCRD, NewOktaAPI(oktaClient), record.NewFakeRecorder(10))
+	_, err = manager.UpsertOktaGroup()
+	assert.NoError(t, err)
+	assert.Equal(t, "Developers", server.Group(group.Id).Profile.Description)
+	assert.NotContains(t, server.Requests(), "PUT /api/v1/groups/"+group.Id)
+
+	// Its description is updated with the spec, still without the marker
+	oktaGroupCRD.Spec.Description = "Engineers"
+	oktaGroupCRD.Generation = 2
+	manager, _ = NewOktaGroupManager(ctx, oktaGroupCRD, NewOktaAPI(oktaClient), record.NewFakeRecorder(10))
+	_, err = manager.UpsertOktaGroup()
+	assert.NoError(t, err)
+	assert.Equal(t, "Engineers", server.Group(group.Id).Profile.Description)
+	assert.Contains(t, server.Requests(), "PUT /api/v1/groups/"+group.Id)
+}
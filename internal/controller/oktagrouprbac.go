@@ -0,0 +1,171 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+)
+
+// OktaGroupUIDLabel labels the RoleBindings and ClusterRoleBindings of an OktaGroup
+// with its UID, to find the ones that are no longer in its spec.
+const OktaGroupUIDLabel = "access-manager.github.com/oktagroup-uid"
+
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;clusterroles,verbs=bind
+
+// roleBindingNameMaxLength is the maximum length of the name of a binding.
+const roleBindingNameMaxLength = validation.DNS1123SubdomainMaxLength
+
+// roleBindingName returns the name of the binding of a role to an OktaGroup. The
+// kind of the role is part of the name, as a Role and a ClusterRole can share one.
+// A name that would be too long is truncated and suffixed with its hash, so that
+// it stays unique.
+func roleBindingName(oktaGroupCRD *accessmanagerv1.OktaGroup, roleRef rbacv1.RoleRef) string {
+	name := fmt.Sprintf("oktagroup-%s-%s-%s", oktaGroupCRD.Name, strings.ToLower(roleRef.Kind), roleRef.Name)
+	if len(name) <= roleBindingNameMaxLength {
+		return name
+	}
+	hash := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(hash[:8])
+	return name[:roleBindingNameMaxLength-len(suffix)] + suffix
+}
+
+// desiredRoleBindings returns the RoleBindings and ClusterRoleBindings of the
+// kubernetesRBAC of an OktaGroup, keyed by namespace and name.
+func (r *OktaGroupReconciler) desiredRoleBindings(oktaGroupCRD *accessmanagerv1.OktaGroup) map[client.ObjectKey]client.Object {
+	desired := map[client.ObjectKey]client.Object{}
+	rbac := oktaGroupCRD.Spec.KubernetesRBAC
+	if rbac == nil {
+		return desired
+	}
+	subjects := []rbacv1.Subject{{
+		Kind:     rbacv1.GroupKind,
+		APIGroup: rbacv1.GroupName,
+		Name:     r.OIDCGroupsPrefix + oktaGroupCRD.Name,
+	}}
+	for _, clusterRole := range rbac.ClusterRoles {
+		roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRole}
+		binding := &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: roleBindingName(oktaGroupCRD, roleRef)},
+			RoleRef:    roleRef,
+			Subjects:   subjects,
+		}
+		desired[client.ObjectKeyFromObject(binding)] = binding
+	}
+	for _, namespace := range rbac.Namespaces {
+		var roleRefs []rbacv1.RoleRef
+		for _, role := range namespace.Roles {
+			roleRefs = append(roleRefs, rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role})
+		}
+		for _, clusterRole := range namespace.ClusterRoles {
+			roleRefs = append(roleRefs, rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRole})
+		}
+		for _, roleRef := range roleRefs {
+			binding := &rbacv1.RoleBinding{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Namespace, Name: roleBindingName(oktaGroupCRD, roleRef)},
+				RoleRef:    roleRef,
+				Subjects:   subjects,
+			}
+			desired[client.ObjectKeyFromObject(binding)] = binding
+		}
+	}
+	return desired
+}
+
+// syncRoleBindings creates or updates the RoleBindings and ClusterRoleBindings of
+// an OktaGroup, owned by it so that they are garbage collected with it, and deletes
+// the ones that are no longer in its spec. It returns the number of bindings that
+// were created, updated or deleted. In dry-run the changes are only validated by
+// the API server, and the bindings are left as they are.
+func (r *OktaGroupReconciler) syncRoleBindings(ctx context.Context, oktaGroupCRD *accessmanagerv1.OktaGroup, dryRun bool) (int, error) {
+	desired := r.desiredRoleBindings(oktaGroupCRD)
+	c := r.Client
+	if dryRun {
+		c = client.NewDryRunClient(r.Client)
+	}
+
+	changed := 0
+	var errs []error
+	for _, binding := range desired {
+		obj := binding.DeepCopyObject().(client.Object)
+		obj.SetResourceVersion("")
+		result, err := controllerutil.CreateOrUpdate(ctx, c, obj, func() error {
+			labels := obj.GetLabels()
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[OktaGroupUIDLabel] = string(oktaGroupCRD.UID)
+			obj.SetLabels(labels)
+			switch obj := obj.(type) {
+			case *rbacv1.ClusterRoleBinding:
+				obj.RoleRef = binding.(*rbacv1.ClusterRoleBinding).RoleRef
+				obj.Subjects = binding.(*rbacv1.ClusterRoleBinding).Subjects
+			case *rbacv1.RoleBinding:
+				obj.RoleRef = binding.(*rbacv1.RoleBinding).RoleRef
+				obj.Subjects = binding.(*rbacv1.RoleBinding).Subjects
+			}
+			return controllerutil.SetControllerReference(oktaGroupCRD, obj, r.Scheme)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to bind %s: %w", client.ObjectKeyFromObject(obj), err))
+		} else if result != controllerutil.OperationResultNone {
+			changed++
+		}
+	}
+
+	// Delete the bindings removed from the spec
+	owned := client.MatchingLabels{OktaGroupUIDLabel: string(oktaGroupCRD.UID)}
+	clusterRoleBindings := &rbacv1.ClusterRoleBindingList{}
+	if err := r.List(ctx, clusterRoleBindings, owned); err != nil {
+		return changed, errors.Join(append(errs, err)...)
+	}
+	roleBindings := &rbacv1.RoleBindingList{}
+	if err := r.List(ctx, roleBindings, owned); err != nil {
+		return changed, errors.Join(append(errs, err)...)
+	}
+	var stale []client.Object
+	for i := range clusterRoleBindings.Items {
+		stale = append(stale, &clusterRoleBindings.Items[i])
+	}
+	for i := range roleBindings.Items {
+		stale = append(stale, &roleBindings.Items[i])
+	}
+	for _, obj := range stale {
+		if _, ok := desired[client.ObjectKeyFromObject(obj)]; ok {
+			continue
+		}
+		if err := c.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, fmt.Errorf("unable to delete %s: %w", client.ObjectKeyFromObject(obj), err))
+		} else if err == nil {
+			changed++
+		}
+	}
+	return changed, errors.Join(errs...)
+}
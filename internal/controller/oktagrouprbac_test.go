@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+	"github.com/franciscoprin/access-manager-operator/internal/oktafake"
+)
+
+func TestOktaGroupReconciler_SyncsRoleBindings(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers", UID: "1234"},
+		Spec: accessmanagerv1.OktaGroupSpec{KubernetesRBAC: &accessmanagerv1.OktaGroupKubernetesRBAC{
+			ClusterRoles: []string{"view"},
+			Namespaces: []accessmanagerv1.OktaGroupNamespaceRBAC{
+				{Namespace: "team-a", Roles: []string{"edit"}, ClusterRoles: []string{"edit"}},
+			},
+		}},
+	}
+	stale := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "oktagroup-developers-clusterrole-admin",
+			Labels: map[string]string{OktaGroupUIDLabel: "1234"}},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "admin"},
+	}
+	unrelated := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "hand-written"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "admin"},
+	}
+	fakeClient := fake.NewClientBuilder().WithObjects(oktaGroupCRD, stale, unrelated).Build()
+	reconciler := &OktaGroupReconciler{Client: fakeClient, Scheme: scheme.Scheme, OIDCGroupsPrefix: "okta:"}
+
+	// In dry-run the changes are counted, but not made
+	changed, err := reconciler.syncRoleBindings(ctx, oktaGroupCRD, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, changed)
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(stale), &rbacv1.RoleBinding{}))
+	assert.Error(t, fakeClient.Get(ctx, client.ObjectKey{Name: "oktagroup-developers-clusterrole-view"}, &rbacv1.ClusterRoleBinding{}))
+
+	changed, err = reconciler.syncRoleBindings(ctx, oktaGroupCRD, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, changed)
+
+	subjects := []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "okta:developers"}}
+	clusterRoleBinding := &rbacv1.ClusterRoleBinding{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKey{Name: "oktagroup-developers-clusterrole-view"}, clusterRoleBinding))
+	assert.Equal(t, subjects, clusterRoleBinding.Subjects)
+	assert.Equal(t, "view", clusterRoleBinding.RoleRef.Name)
+	assert.True(t, metav1.IsControlledBy(clusterRoleBinding, oktaGroupCRD))
+
+	roleBindings := &rbacv1.RoleBindingList{}
+	assert.NoError(t, fakeClient.List(ctx, roleBindings))
+	var names []string
+	for _, roleBinding := range roleBindings.Items {
+		names = append(names, roleBinding.Namespace+"/"+roleBinding.Name)
+		if roleBinding.Name != unrelated.Name {
+			assert.Equal(t, subjects, roleBinding.Subjects)
+			assert.True(t, metav1.IsControlledBy(&roleBinding, oktaGroupCRD))
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"team-a/oktagroup-developers-role-edit",
+		"team-a/oktagroup-developers-clusterrole-edit",
+		"team-b/hand-written",
+	}, names)
+
+	// Removing kubernetesRBAC deletes every binding of the OktaGroup
+	oktaGroupCRD.Spec.KubernetesRBAC = nil
+	changed, err = reconciler.syncRoleBindings(ctx, oktaGroupCRD, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, changed)
+	assert.NoError(t, fakeClient.List(ctx, roleBindings))
+	assert.Len(t, roleBindings.Items, 1)
+	clusterRoleBindings := &rbacv1.ClusterRoleBindingList{}
+	assert.NoError(t, fakeClient.List(ctx, clusterRoleBindings))
+	assert.Empty(t, clusterRoleBindings.Items)
+}
+
+func TestOktaGroupReconciler_BindsRolesOnlyWhenAuthorized(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+
+	for _, rolesAuthorized := range []bool{false, true} {
+		server := oktafake.NewServer()
+		defer server.Close()
+		oktaClient, err := server.Client(ctx)
+		assert.NoError(t, err)
+		server.AddUser("john", "john@example.com", oktafake.StatusActive)
+
+		oktaGroupCRD := &accessmanagerv1.OktaGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+			Spec: accessmanagerv1.OktaGroupSpec{
+				Users:          []string{"john@example.com"},
+				KubernetesRBAC: &accessmanagerv1.OktaGroupKubernetesRBAC{ClusterRoles: []string{"cluster-admin"}},
+			},
+		}
+		fakeClient := fake.NewClientBuilder().WithObjects(oktaGroupCRD).WithStatusSubresource(oktaGroupCRD).Build()
+		reconciler := &OktaGroupReconciler{
+			Client:          fakeClient,
+			Scheme:          scheme.Scheme,
+			OktaClients:     NewOktaClientRegistry(fakeClient),
+			Recorder:        record.NewFakeRecorder(100),
+			RolesAuthorized: rolesAuthorized,
+		}
+		reconciler.OktaClients.Register("", oktaClient)
+
+		_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(oktaGroupCRD)})
+		assert.NoError(t, err)
+		updated := &accessmanagerv1.OktaGroup{}
+		assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(oktaGroupCRD), updated))
+		rbacSynced := meta.FindStatusCondition(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionRBACSynced)
+		clusterRoleBindings := &rbacv1.ClusterRoleBindingList{}
+		assert.NoError(t, fakeClient.List(ctx, clusterRoleBindings))
+
+		// Without the webhook nothing checked who may bind the roles
+		if !rolesAuthorized {
+			assert.Equal(t, metav1.ConditionFalse, rbacSynced.Status)
+			assert.Equal(t, accessmanagerv1.OktaGroupReasonRBACUnchecked, rbacSynced.Reason)
+			assert.Empty(t, clusterRoleBindings.Items)
+			continue
+		}
+		assert.Equal(t, metav1.ConditionTrue, rbacSynced.Status)
+		assert.Len(t, clusterRoleBindings.Items, 1)
+	}
+}
+
+func TestRoleBindingName_FitsLongNames(t *testing.T) {
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{ObjectMeta: metav1.ObjectMeta{Name: "developers"}}
+	view := rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}
+	assert.Equal(t, "oktagroup-developers-clusterrole-view", roleBindingName(oktaGroupCRD, view))
+
+	// The names of an OktaGroup and a role can each be as long as a binding name
+	oktaGroupCRD.Name = strings.Repeat("a", 253)
+	edit := rbacv1.RoleRef{Kind: "Role", Name: strings.Repeat("b", 253) + "-edit"}
+	admin := rbacv1.RoleRef{Kind: "Role", Name: strings.Repeat("b", 253) + "-admin"}
+	name := roleBindingName(oktaGroupCRD, edit)
+	assert.Len(t, name, 253)
+	assert.True(t, strings.HasPrefix(name, "oktagroup-aaa"))
+	assert.Equal(t, name, roleBindingName(oktaGroupCRD, edit))
+	assert.NotEqual(t, name, roleBindingName(oktaGroupCRD, admin))
+}
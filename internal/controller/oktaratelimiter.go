@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oktaRequestTimeout is the timeout of every Okta API call, the same as the SDK's default.
+	oktaRequestTimeout = 30 * time.Second
+	// defaultRateLimitWindow is how long an endpoint is throttled after a 429 response
+	// without rate limit headers. Okta's rate limits are per minute.
+	defaultRateLimitWindow = time.Minute
+)
+
+// RateLimitedError is returned for an Okta API call that was throttled, either by
+// Okta with a 429 response or before being sent because the rate limit of its
+// endpoint family is exhausted.
+type RateLimitedError struct {
+	// Endpoint is the endpoint family whose rate limit is exhausted.
+	Endpoint string
+	// Reset is when the rate limit of the endpoint family is refilled.
+	Reset time.Time
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit of Okta endpoint %s is exhausted until %s", e.Endpoint, e.Reset.UTC().Format(time.RFC3339))
+}
+
+// RetryAfter returns how long to wait before calling the endpoint again.
+func (e *RateLimitedError) RetryAfter(now time.Time) time.Duration {
+	return max(e.Reset.Sub(now), time.Second)
+}
+
+// oktaRateLimiter tracks the rate limits of the endpoint families of one Okta org,
+// as reported by the X-Rate-Limit-* headers of its responses. It is shared by every
+// client of the org, so that concurrent reconciles stop before Okta rejects them.
+type oktaRateLimiter struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*rateLimitBucket
+}
+
+type rateLimitBucket struct {
+	// remaining is the number of calls left until reset.
+	remaining int
+	reset     time.Time
+}
+
+func newOktaRateLimiter() *oktaRateLimiter {
+	return &oktaRateLimiter{
+		now:     time.Now,
+		buckets: map[string]*rateLimitBucket{},
+	}
+}
+
+// take reserves a call to the endpoint family, or returns a RateLimitedError if
+// its rate limit is exhausted. Endpoints without known limits are never throttled.
+func (l *oktaRateLimiter) take(endpoint string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[endpoint]
+	if !ok || !l.now().Before(bucket.reset) {
+		return nil
+	}
+	if bucket.remaining <= 0 {
+		return &RateLimitedError{Endpoint: endpoint, Reset: bucket.reset}
+	}
+	bucket.remaining--
+	return nil
+}
+
+// update refills the bucket of the endpoint family from the rate limit headers of a
+// response, and empties it on a 429 response. It returns the reset time of the bucket.
+func (l *oktaRateLimiter) update(endpoint string, resp *http.Response) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	remaining, remainingErr := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Remaining"))
+	resetUnix, resetErr := strconv.ParseInt(resp.Header.Get("X-Rate-Limit-Reset"), 10, 64)
+	reset := time.Unix(resetUnix, 0)
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests && resetErr != nil:
+		remaining, reset = 0, l.now().Add(defaultRateLimitWindow)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		remaining = 0
+	case remainingErr != nil || resetErr != nil:
+		return time.Time{}
+	}
+
+	// Responses of the same window can arrive out of order, the lowest count is the latest
+	if bucket, ok := l.buckets[endpoint]; ok && bucket.reset.Equal(reset) {
+		remaining = min(remaining, bucket.remaining)
+	}
+	l.buckets[endpoint] = &rateLimitBucket{remaining: remaining, reset: reset}
+	return reset
+}
+
+// rateLimitedTransport throttles the Okta API calls with a shared rate limiter and
+// turns 429 responses into a RateLimitedError, instead of letting the SDK sleep
+// through the backoff while holding a reconcile worker.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *oktaRateLimiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := oktaEndpointFamily(req.URL.Path)
+	if err := t.limiter.take(endpoint); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	reset := t.limiter.update(endpoint, resp)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, &RateLimitedError{Endpoint: endpoint, Reset: reset}
+	}
+	return resp, nil
+}
+
+// newRateLimitedHTTPClient returns the HTTP client of the Okta clients of an org.
+// The calls that reach Okta are recorded in the metrics of the org, and traced.
+func newRateLimitedHTTPClient(orgName string, limiter *oktaRateLimiter) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.IdleConnTimeout = 30 * time.Second
+
+	return &http.Client{
+		Transport: &rateLimitedTransport{next: &instrumentedTransport{next: transport, org: orgName}, limiter: limiter},
+		Timeout:   oktaRequestTimeout,
+	}
+}
+
+// oktaEndpointFamily returns the template of an Okta API path, which Okta's rate
+// limits are grouped by: the IDs are replaced by {id}, and the ID of the item of a
+// sub-collection is dropped. For example /api/v1/groups/00g1/users/00u1 belongs to
+// /api/v1/groups/{id}/users.
+func oktaEndpointFamily(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	// Skip the prefix, e.g. api/v1, then every other segment is an ID
+	for i := 3; i < len(segments); i += 2 {
+		segments[i] = "{id}"
+	}
+	if len(segments) > 4 && segments[len(segments)-1] == "{id}" {
+		segments = segments[:len(segments)-1]
+	}
+	return "/" + strings.Join(segments, "/")
+}
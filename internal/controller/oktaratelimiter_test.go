@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOktaEndpointFamily(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/api/v1/groups":                 "/api/v1/groups",
+		"/api/v1/groups/00g1":            "/api/v1/groups/{id}",
+		"/api/v1/groups/00g1/users":      "/api/v1/groups/{id}/users",
+		"/api/v1/groups/00g1/users/00u1": "/api/v1/groups/{id}/users",
+		"/api/v1/users":                  "/api/v1/users",
+		"/oauth2/v1/token":               "/oauth2/v1/token",
+	} {
+		assert.Equal(t, expected, oktaEndpointFamily(path), path)
+	}
+}
+
+func TestRateLimitedTransport_ThrottlesExhaustedEndpoints(t *testing.T) {
+	reset := time.Now().Add(time.Minute).Truncate(time.Second)
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Rate-Limit-Remaining", strconv.Itoa(2-calls))
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		writeTestJSON(w, http.StatusOK, "[]")
+	}))
+	defer server.Close()
+
+	limiter := newOktaRateLimiter()
+	httpClient := newRateLimitedHTTPClient("test", limiter)
+	get := func(path string) error {
+		resp, err := httpClient.Get(server.URL + path)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, get("/api/v1/groups/00g1/users"))
+	assert.NoError(t, get("/api/v1/groups/00g2/users"))
+
+	var rateLimited *RateLimitedError
+	assert.True(t, errors.As(get("/api/v1/groups/00g3/users"), &rateLimited))
+	assert.Equal(t, "/api/v1/groups/{id}/users", rateLimited.Endpoint)
+	assert.True(t, reset.Equal(rateLimited.Reset))
+	assert.Equal(t, 2, calls, "the throttled call must not reach Okta")
+
+	// Other endpoint families have their own limits
+	assert.NoError(t, get("/api/v1/users"))
+
+	// The limit is lifted once it is reset
+	limiter.now = func() time.Time { return reset.Add(time.Second) }
+	assert.NoError(t, get("/api/v1/groups/00g3/users"))
+}
+
+func TestRateLimitedTransport_TooManyRequests(t *testing.T) {
+	reset := time.Now().Add(30 * time.Second).Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit-Remaining", "10")
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		writeTestJSON(w, http.StatusTooManyRequests, `{"errorCode": "E0000047", "errorSummary": "API call exceeded rate limit due to too many requests."}`)
+	}))
+	defer server.Close()
+
+	_, oktaClient, err := okta.NewClient(context.TODO(),
+		okta.WithCache(false),
+		okta.WithOrgUrl(server.URL),
+		okta.WithToken("test-token"),
+		okta.WithTestingDisableHttpsCheck(true),
+		okta.WithHttpClientPtr(newRateLimitedHTTPClient("test", newOktaRateLimiter())),
+	)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, _, err = oktaClient.Group.GetGroup(context.TODO(), "00g1")
+
+	var rateLimited *RateLimitedError
+	assert.True(t, errors.As(err, &rateLimited))
+	assert.True(t, reset.Equal(rateLimited.Reset))
+	assert.Less(t, time.Since(start), 5*time.Second, "the SDK must not sleep through the backoff")
+	assert.InDelta(t, 30*time.Second, rateLimited.RetryAfter(start), float64(2*time.Second))
+}
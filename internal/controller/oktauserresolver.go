@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// userResolverBatchSize is the number of identifiers looked up by a single ListUsers
+// call, combined into one "or" filter. It keeps the request URL well below the
+// limits of Okta and of the proxies in between.
+const userResolverBatchSize = 20
+
+// oktaFilterEscaper escapes the values compared in the filters of ListUsers, so
+// that a quote in a login or email can't end the string and add expressions.
+var oktaFilterEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// userAttribute is an attribute Okta users are looked up by, as named in the
+// filters of ListUsers.
+type userAttribute string
+
+const (
+	userAttributeID    userAttribute = "id"
+	userAttributeLogin userAttribute = "profile.login"
+	userAttributeEmail userAttribute = "profile.email"
+)
+
+// value returns the attribute of the user, lowercased unless it is the ID.
+func (a userAttribute) value(user *okta.User) string {
+	switch a {
+	case userAttributeID:
+		return user.Id
+	case userAttributeLogin:
+		return strings.ToLower(userLogin(user))
+	default:
+		return strings.ToLower(userEmail(user))
+	}
+}
+
+// normalize returns the key of a value of the attribute, Okta compares logins and
+// emails regardless of case.
+func (a userAttribute) normalize(value string) string {
+	if a == userAttributeID {
+		return value
+	}
+	return strings.ToLower(value)
+}
+
+// OktaUserResolver resolves IDs, logins and emails to Okta users for every OktaGroup
+// of the operator. It looks them up in batches and caches the result of each one for
+// a TTL, so that unchanged groups don't cost one API call per member on every
+// reconcile. The cache is keyed by OktaOrg, as the same email is a different user
+// in each org.
+type OktaUserResolver struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	users map[userResolverKey]cachedOktaUsers
+}
+
+type userResolverKey struct {
+	orgName   string
+	attribute userAttribute
+	value     string
+}
+
+type cachedOktaUsers struct {
+	// users are the Okta users with the value, more than one if it is ambiguous.
+	users   []*okta.User
+	expires time.Time
+}
+
+// NewOktaUserResolver creates a resolver that caches every lookup for the given TTL.
+// A TTL of 0 disables the cache, but the lookups are still batched.
+func NewOktaUserResolver(ttl time.Duration) *OktaUserResolver {
+	return &OktaUserResolver{
+		ttl:   ttl,
+		now:   time.Now,
+		users: map[userResolverKey]cachedOktaUsers{},
+	}
+}
+
+// Resolve returns the Okta users of the given org that have each email, looking up
+// the emails that aren't cached with as few ListUsers calls as possible. An email
+// is missing from the result if its lookup failed, the error of every failed batch
+// is returned.
+func (r *OktaUserResolver) Resolve(ctx context.Context, users UserAPI, orgName string, emails []string, pageSize int64) (map[string][]*okta.User, error) {
+	return r.resolve(ctx, users, orgName, userAttributeEmail, emails, pageSize)
+}
+
+// ResolveLogins is like Resolve for logins, every login matches at most one user.
+func (r *OktaUserResolver) ResolveLogins(ctx context.Context, users UserAPI, orgName string, logins []string, pageSize int64) (map[string][]*okta.User, error) {
+	return r.resolve(ctx, users, orgName, userAttributeLogin, logins, pageSize)
+}
+
+// ResolveIDs is like Resolve for user IDs, every ID matches at most one user.
+func (r *OktaUserResolver) ResolveIDs(ctx context.Context, users UserAPI, orgName string, ids []string, pageSize int64) (map[string][]*okta.User, error) {
+	return r.resolve(ctx, users, orgName, userAttributeID, ids, pageSize)
+}
+
+func (r *OktaUserResolver) resolve(ctx context.Context, users UserAPI, orgName string, attribute userAttribute, values []string, pageSize int64) (map[string][]*okta.User, error) {
+	resolved := make(map[string][]*okta.User, len(values))
+	if len(values) == 0 {
+		return resolved, nil
+	}
+	ctx, span := tracer.Start(ctx, "OktaUserResolver.resolve", trace.WithAttributes(
+		oktaOrgKey.String(orgName), oktaUserAttributeKey.String(string(attribute)), oktaUsersKey.Int(len(values))))
+	var missing []string
+
+	r.mu.Lock()
+	now := r.now()
+	for _, value := range values {
+		key := userResolverKey{orgName: orgName, attribute: attribute, value: attribute.normalize(value)}
+		cached, ok := r.users[key]
+		switch {
+		case ok && now.Before(cached.expires):
+			resolved[value] = cached.users
+		case ok:
+			delete(r.users, key)
+			missing = append(missing, value)
+		default:
+			missing = append(missing, value)
+		}
+	}
+	r.mu.Unlock()
+	span.SetAttributes(oktaUsersCachedKey.Int(len(values) - len(missing)))
+
+	var errs []error
+	for start := 0; start < len(missing); start += userResolverBatchSize {
+		batch := missing[start:min(start+userResolverBatchSize, len(missing))]
+
+		filters := make([]string, len(batch))
+		for i, value := range batch {
+			filters[i] = fmt.Sprintf(`%s eq "%s"`, attribute, oktaFilterEscaper.Replace(value))
+		}
+		found, resp, err := users.ListUsers(ctx, &query.Params{
+			Filter: strings.Join(filters, " or "),
+			Limit:  pageSize,
+		})
+		found, err = allPages(ctx, found, resp, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to search users %s: %w", strings.Join(batch, ", "), err))
+			continue
+		}
+
+		byValue := map[string][]*okta.User{}
+		for _, user := range found {
+			value := attribute.value(user)
+			byValue[value] = append(byValue[value], user)
+		}
+
+		r.mu.Lock()
+		for _, value := range batch {
+			matches := byValue[attribute.normalize(value)]
+			resolved[value] = matches
+			r.store(orgName, attribute, value, matches)
+		}
+		r.mu.Unlock()
+	}
+
+	err := errors.Join(errs...)
+	endSpan(span, err)
+	return resolved, err
+}
+
+// Seed caches the given users, typically the members of a group that were listed
+// anyway, so that resolving their IDs and logins doesn't need another API call. Their
+// emails aren't cached, as users that weren't listed can share them.
+func (r *OktaUserResolver) Seed(orgName string, users []*okta.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range users {
+		for _, attribute := range []userAttribute{userAttributeID, userAttributeLogin} {
+			if value := attribute.value(user); value != "" {
+				r.store(orgName, attribute, value, []*okta.User{user})
+			}
+		}
+	}
+}
+
+// Invalidate drops the cached users of the given emails or logins of an org.
+func (r *OktaUserResolver) Invalidate(orgName string, values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, value := range values {
+		for _, attribute := range []userAttribute{userAttributeLogin, userAttributeEmail} {
+			delete(r.users, userResolverKey{orgName: orgName, attribute: attribute, value: attribute.normalize(value)})
+		}
+	}
+}
+
+// InvalidateUser drops every cached lookup of an org that resolved to the given user.
+func (r *OktaUserResolver) InvalidateUser(orgName, userID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, cached := range r.users {
+		if key.orgName != orgName {
+			continue
+		}
+		for _, user := range cached.users {
+			if user.Id == userID {
+				delete(r.users, key)
+				break
+			}
+		}
+	}
+}
+
+// InvalidateOrg drops every cached user of an org.
+func (r *OktaUserResolver) InvalidateOrg(orgName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.users {
+		if key.orgName == orgName {
+			delete(r.users, key)
+		}
+	}
+}
+
+// store caches the users of a value of an attribute, the caller must hold the lock.
+func (r *OktaUserResolver) store(orgName string, attribute userAttribute, value string, users []*okta.User) {
+	if r.ttl <= 0 {
+		return
+	}
+	r.users[userResolverKey{orgName: orgName, attribute: attribute, value: attribute.normalize(value)}] = cachedOktaUsers{
+		users:   users,
+		expires: r.now().Add(r.ttl),
+	}
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestUsersHandler serves ListUsers from the given users, matching the emails
+// of an "or" filter, and counts the calls.
+func newTestUsersHandler(users map[string][]string, calls *int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		var matches []string
+		for _, filter := range strings.Split(r.URL.Query().Get("filter"), " or ") {
+			email := strings.Trim(strings.TrimPrefix(filter, "profile.email eq "), `"`)
+			for _, id := range users[email] {
+				matches = append(matches, fmt.Sprintf(`{"id": %q, "status": "ACTIVE", "profile": {"email": %q}}`, id, email))
+			}
+		}
+		writeTestJSON(w, http.StatusOK, "["+strings.Join(matches, ",")+"]")
+	})
+	return mux
+}
+
+func TestOktaUserResolver_BatchesLookups(t *testing.T) {
+	users := map[string][]string{"twice@example.com": {"00u1", "00u2"}}
+	var emails []string
+	for i := 0; i < userResolverBatchSize+5; i++ {
+		email := fmt.Sprintf("user%d@example.com", i)
+		users[email] = []string{fmt.Sprintf("00u%d", i+10)}
+		emails = append(emails, email)
+	}
+	emails = append(emails, "twice@example.com", "missing@example.com")
+
+	var calls int
+	oktaAPI := newTestOktaAPI(t, newTestUsersHandler(users, &calls))
+	resolver := NewOktaUserResolver(time.Minute)
+
+	resolved, err := resolver.Resolve(context.TODO(), oktaAPI.User, "prod", emails, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Len(t, resolved, len(emails))
+	assert.Equal(t, "00u10", resolved["user0@example.com"][0].Id)
+	assert.Len(t, resolved["twice@example.com"], 2)
+	assert.Empty(t, resolved["missing@example.com"])
+	assert.Contains(t, resolved, "missing@example.com")
+
+	// Every result is cached, including the emails no user has
+	_, err = resolver.Resolve(context.TODO(), oktaAPI.User, "prod", emails, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	// The cache isn't shared across orgs
+	_, err = resolver.Resolve(context.TODO(), oktaAPI.User, "preview", []string{"user0@example.com"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestOktaUserResolver_ExpiresAndInvalidates(t *testing.T) {
+	users := map[string][]string{"john@example.com": {"00u1"}, "jane@example.com": {"00u2"}}
+	var calls int
+	oktaAPI := newTestOktaAPI(t, newTestUsersHandler(users, &calls))
+
+	now := time.Now()
+	resolver := NewOktaUserResolver(time.Minute)
+	resolver.now = func() time.Time { return now }
+
+	resolve := func(emails ...string) {
+		_, err := resolver.Resolve(context.TODO(), oktaAPI.User, "prod", emails, 0)
+		assert.NoError(t, err)
+	}
+
+	resolve("john@example.com", "jane@example.com")
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(2 * time.Minute)
+	resolve("john@example.com")
+	assert.Equal(t, 2, calls)
+
+	resolver.Invalidate("prod", "John@Example.com")
+	resolve("john@example.com")
+	assert.Equal(t, 3, calls)
+
+	resolver.InvalidateUser("prod", "00u1")
+	resolve("john@example.com")
+	assert.Equal(t, 4, calls)
+
+	resolver.InvalidateOrg("prod")
+	resolve("john@example.com")
+	assert.Equal(t, 5, calls)
+
+	// The seeded users are cached by ID and login, but not by email, as other users
+	// can share it
+	resolver.Seed("prod", []*okta.User{{Id: "00u3", Profile: &okta.UserProfile{"login": "seeded", "email": "john@example.com"}}})
+	resolved, err := resolver.ResolveIDs(context.TODO(), oktaAPI.User, "prod", []string{"00u3"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, "00u3", resolved["00u3"][0].Id)
+	resolved, err = resolver.ResolveLogins(context.TODO(), oktaAPI.User, "prod", []string{"Seeded"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, "00u3", resolved["Seeded"][0].Id)
+	resolver.InvalidateOrg("prod")
+	resolver.Seed("prod", []*okta.User{{Id: "00u3", Profile: &okta.UserProfile{"email": "john@example.com"}}})
+	resolved, err = resolver.Resolve(context.TODO(), oktaAPI.User, "prod", []string{"john@example.com"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, calls)
+	assert.Equal(t, "00u1", resolved["john@example.com"][0].Id)
+}
+
+func TestOktaUserResolver_WithoutCache(t *testing.T) {
+	var calls int
+	oktaAPI := newTestOktaAPI(t, newTestUsersHandler(map[string][]string{"john@example.com": {"00u1"}}, &calls))
+	resolver := NewOktaUserResolver(0)
+
+	for i := 0; i < 2; i++ {
+		_, err := resolver.Resolve(context.TODO(), oktaAPI.User, "prod", []string{"john@example.com"}, 0)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestOktaUserResolver_ResolvesLoginsAndIDs(t *testing.T) {
+	var filters []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		filters = append(filters, r.URL.Query().Get("filter"))
+		writeTestJSON(w, http.StatusOK, `[{"id": "00u1", "status": "ACTIVE", "profile": {"login": "svc-ci", "email": "robots@example.com"}}]`)
+	})
+	oktaAPI := newTestOktaAPI(t, mux)
+	resolver := NewOktaUserResolver(time.Minute)
+
+	resolved, err := resolver.ResolveLogins(context.TODO(), oktaAPI.User, "prod", []string{"SVC-CI", "svc-deploy"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "00u1", resolved["SVC-CI"][0].Id)
+	assert.Empty(t, resolved["svc-deploy"])
+
+	resolved, err = resolver.ResolveIDs(context.TODO(), oktaAPI.User, "prod", []string{"00u1"}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "00u1", resolved["00u1"][0].Id)
+	assert.Equal(t, []string{`profile.login eq "SVC-CI" or profile.login eq "svc-deploy"`, `id eq "00u1"`}, filters)
+
+	// Both lookups are cached, and dropped when the user changes
+	_, err = resolver.ResolveLogins(context.TODO(), oktaAPI.User, "prod", []string{"svc-ci"}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, filters, 2)
+	resolver.InvalidateUser("prod", "00u1")
+	assert.Len(t, resolver.users, 1, "only the login nobody has stays cached")
+}
+
+func TestOktaUserResolver_EscapesFilterValues(t *testing.T) {
+	var filters []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		filters = append(filters, r.URL.Query().Get("filter"))
+		writeTestJSON(w, http.StatusOK, `[]`)
+	})
+	oktaAPI := newTestOktaAPI(t, mux)
+	resolver := NewOktaUserResolver(time.Minute)
+
+	resolved, err := resolver.ResolveLogins(context.TODO(), oktaAPI.User, "prod", []string{`x" or profile.login sw "`, `a\b`}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, resolved, 2)
+	assert.Equal(t, []string{`profile.login eq "x\" or profile.login sw \"" or profile.login eq "a\\b"`}, filters)
+}
@@ -18,6 +18,7 @@ package controller
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
@@ -66,6 +67,14 @@ var _ = BeforeSuite(func() {
 			fmt.Sprintf("1.28.0-%s-%s", runtime.GOOS, runtime.GOARCH)),
 	}
 
+	// The specs are skipped when the envtest binaries aren't installed, e.g. when
+	// running go test without the makefile target test.
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		if _, err := os.Stat(testEnv.BinaryAssetsDirectory); err != nil {
+			Skip("the envtest binaries aren't installed, run make test")
+		}
+	}
+
 	var err error
 	// cfg is defined in this file globally.
 	cfg, err = testEnv.Start()
@@ -84,6 +93,9 @@ var _ = BeforeSuite(func() {
 })
 
 var _ = AfterSuite(func() {
+	if cfg == nil {
+		return
+	}
 	By("tearing down the test environment")
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the operator.
+const tracerName = "github.com/franciscoprin/access-manager-operator/internal/controller"
+
+// tracer starts the spans of the operator. It records nothing until a tracer
+// provider is set with otel.SetTracerProvider.
+var tracer = otel.Tracer(tracerName)
+
+// The attributes of the spans that aren't defined by the semantic conventions.
+const (
+	oktaOrgKey                = attribute.Key("okta.org")
+	oktaGroupKey              = attribute.Key("oktagroup.name")
+	oktaGroupReasonKey        = attribute.Key("oktagroup.reason")
+	oktaGroupIDKey            = attribute.Key("okta.group.id")
+	oktaRemoveMembersKey      = attribute.Key("okta.group.remove_members")
+	oktaRateLimitKey          = attribute.Key("okta.rate_limit.limit")
+	oktaRateLimitRemainingKey = attribute.Key("okta.rate_limit.remaining")
+	oktaRateLimitResetKey     = attribute.Key("okta.rate_limit.reset")
+	oktaUserAttributeKey      = attribute.Key("okta.user.attribute")
+	oktaUsersKey              = attribute.Key("okta.users")
+	oktaUsersCachedKey        = attribute.Key("okta.users.cached")
+)
+
+// NewTracerProvider returns a tracer provider exporting the spans of the operator
+// to the OTLP/HTTP endpoint, a host and port such as otel-collector:4318. A sampling
+// ratio below 1 samples that fraction of the reconciles.
+func NewTracerProvider(ctx context.Context, endpoint string, insecure bool, samplingRatio float64) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("access-manager-operator")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplingRatio))),
+	), nil
+}
+
+// startSpan starts the span of a method of the manager. The Okta API calls made
+// with the returned context are children of the span.
+func startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "OktaGroupManager."+method, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, marking it as failed if err isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceOktaRequest starts the span of an Okta API call. It is named after the
+// endpoint family of the call rather than its path so that the calls can be
+// aggregated.
+func traceOktaRequest(req *http.Request, org, endpoint string) (*http.Request, trace.Span) {
+	ctx, span := tracer.Start(req.Context(), req.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLTemplate(endpoint),
+			semconv.ServerAddress(req.URL.Hostname()),
+			oktaOrgKey.String(org),
+		))
+	return req.WithContext(ctx), span
+}
+
+// endOktaRequest ends the span of an Okta API call with its status and the rate
+// limit reported by Okta. As for any HTTP client span, a 4xx or 5xx status marks it
+// as failed.
+func endOktaRequest(span trace.Span, resp *http.Response, err error) {
+	if err != nil {
+		endSpan(span, err)
+		return
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	for header, key := range map[string]attribute.Key{
+		"X-Rate-Limit-Limit":     oktaRateLimitKey,
+		"X-Rate-Limit-Remaining": oktaRateLimitRemainingKey,
+		"X-Rate-Limit-Reset":     oktaRateLimitResetKey,
+	} {
+		if value, err := strconv.ParseInt(resp.Header.Get(header), 10, 64); err == nil {
+			span.SetAttributes(key.Int64(value))
+		}
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	span.End()
+}
@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	accessmanagerv1 "github.com/franciscoprin/access-manager-operator/api/v1"
+	"github.com/franciscoprin/access-manager-operator/internal/oktafake"
+)
+
+var (
+	spanExporter     = tracetest.NewInMemoryExporter()
+	spanExporterOnce sync.Once
+)
+
+// recordSpans makes the tracer of the operator export its spans to memory, and
+// drops the spans of the previous tests. The global tracer provider can only be
+// set once for the tracer to use it.
+func recordSpans() *tracetest.InMemoryExporter {
+	spanExporterOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+	})
+	spanExporter.Reset()
+	return spanExporter
+}
+
+// findSpan returns the first span with the given name, or nil if there is none.
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+// spanAttribute returns the value of an attribute of a span, or an empty value.
+func spanAttribute(span *tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// childSpans returns the names of the children of a span.
+func childSpans(spans tracetest.SpanStubs, parent *tracetest.SpanStub) (names []string) {
+	for _, span := range spans {
+		if span.Parent.SpanID() == parent.SpanContext.SpanID() {
+			names = append(names, span.Name)
+		}
+	}
+	return names
+}
+
+func TestOktaGroupReconciler_TracesReconcile(t *testing.T) {
+	ctx := context.TODO()
+	accessmanagerv1.AddToScheme(scheme.Scheme)
+	exporter := recordSpans()
+
+	server := oktafake.NewServer()
+	defer server.Close()
+	oktaClient, err := server.Client(ctx, okta.WithHttpClientPtr(newRateLimitedHTTPClient("", newOktaRateLimiter())))
+	assert.NoError(t, err)
+
+	john := server.AddUser("john", "john@example.com", oktafake.StatusActive)
+	server.AddUser("jane", "jane@example.com", oktafake.StatusActive)
+	group := server.AddGroup("developers", managedDescription("Developers", DefaultManagerID), john.Id)
+
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Spec:       accessmanagerv1.OktaGroupSpec{Description: "Developers", Users: []string{"john@example.com", "jane@example.com"}},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: group.Id},
+	}
+	fakeClient := fake.NewClientBuilder().WithObjects(oktaGroupCRD).WithStatusSubresource(oktaGroupCRD).Build()
+	reconciler := &OktaGroupReconciler{
+		Client:      fakeClient,
+		Scheme:      scheme.Scheme,
+		OktaClients: NewOktaClientRegistry(fakeClient),
+		Recorder:    record.NewFakeRecorder(100),
+	}
+	reconciler.OktaClients.Register("", oktaClient)
+
+	_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(oktaGroupCRD)})
+	assert.NoError(t, err)
+	updated := &accessmanagerv1.OktaGroup{}
+	assert.NoError(t, fakeClient.Get(ctx, client.ObjectKeyFromObject(oktaGroupCRD), updated))
+	assert.True(t, meta.IsStatusConditionTrue(updated.Status.Conditions, accessmanagerv1.OktaGroupConditionReady))
+
+	spans := exporter.GetSpans()
+
+	// A span per reconcile, with the manager methods and the status update as children
+	reconcileSpan := findSpan(spans, "OktaGroupReconciler.Reconcile")
+	if !assert.NotNil(t, reconcileSpan) {
+		return
+	}
+	assert.False(t, reconcileSpan.Parent.IsValid())
+	assert.Equal(t, codes.Unset, reconcileSpan.Status.Code)
+	assert.Equal(t, "developers", spanAttribute(reconcileSpan, oktaGroupKey).AsString())
+	assert.Equal(t, accessmanagerv1.OktaGroupReasonSynced, spanAttribute(reconcileSpan, oktaGroupReasonKey).AsString())
+	assert.Equal(t, []string{
+		"OktaGroupManager.UpsertOktaGroup",
+		"OktaGroupManager.UpsertUsersToOktaGroup",
+		"OktaGroupManager.SearchOktaGroup",
+		"OktaGroupReconciler.updateStatus",
+	}, childSpans(spans, reconcileSpan))
+
+	// The methods called by a method of the manager are children of its span
+	upsertGroup := findSpan(spans, "OktaGroupManager.UpsertOktaGroup")
+	assert.Equal(t, []string{"OktaGroupManager.SearchOktaGroup"}, childSpans(spans, upsertGroup))
+
+	// The members are looked up and added within the span of the method doing it
+	upsertUsers := findSpan(spans, "OktaGroupManager.UpsertUsersToOktaGroup")
+	assert.Equal(t, []string{
+		"GET /api/v1/groups/{id}/users",
+		"OktaGroupManager.resolveMembers",
+		"PUT /api/v1/groups/{id}/users",
+	}, childSpans(spans, upsertUsers))
+	resolveMembers := findSpan(spans, "OktaGroupManager.resolveMembers")
+	assert.Equal(t, []string{"OktaUserResolver.resolve"}, childSpans(spans, resolveMembers))
+	resolve := findSpan(spans, "OktaUserResolver.resolve")
+	assert.Equal(t, "profile.email", spanAttribute(resolve, oktaUserAttributeKey).AsString())
+	assert.Equal(t, int64(2), spanAttribute(resolve, oktaUsersKey).AsInt64())
+	assert.Equal(t, int64(0), spanAttribute(resolve, oktaUsersCachedKey).AsInt64())
+	assert.Equal(t, []string{"GET /api/v1/users"}, childSpans(spans, resolve))
+
+	// Every Okta API call is a client span named after its URL template
+	addUser := findSpan(spans, "PUT /api/v1/groups/{id}/users")
+	assert.Equal(t, trace.SpanKindClient, addUser.SpanKind)
+	assert.Equal(t, http.MethodPut, spanAttribute(addUser, semconv.HTTPRequestMethodKey).AsString())
+	assert.Equal(t, "/api/v1/groups/{id}/users", spanAttribute(addUser, semconv.URLTemplateKey).AsString())
+	assert.Equal(t, int64(http.StatusNoContent), spanAttribute(addUser, semconv.HTTPResponseStatusCodeKey).AsInt64())
+	assert.Equal(t, codes.Unset, addUser.Status.Code)
+}
+
+func TestInstrumentedTransport_TracesOktaAPICalls(t *testing.T) {
+	exporter := recordSpans()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit-Limit", "600")
+		w.Header().Set("X-Rate-Limit-Remaining", "0")
+		w.Header().Set("X-Rate-Limit-Reset", "1700000000")
+		writeTestJSON(w, http.StatusTooManyRequests, `{"errorCode": "E0000047"}`)
+	}))
+
+	httpClient := newRateLimitedHTTPClient("tracing", newOktaRateLimiter())
+	_, err := httpClient.Get(server.URL + "/api/v1/groups/00g1")
+	assert.Error(t, err)
+
+	// A rate limited call records the rate limit reported by Okta
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		span := &spans[0]
+		assert.Equal(t, "GET /api/v1/groups/{id}", span.Name)
+		assert.Equal(t, codes.Error, span.Status.Code)
+		assert.Equal(t, "tracing", spanAttribute(span, oktaOrgKey).AsString())
+		assert.Equal(t, int64(http.StatusTooManyRequests), spanAttribute(span, semconv.HTTPResponseStatusCodeKey).AsInt64())
+		assert.Equal(t, int64(600), spanAttribute(span, oktaRateLimitKey).AsInt64())
+		assert.Equal(t, int64(0), spanAttribute(span, oktaRateLimitRemainingKey).AsInt64())
+		assert.Equal(t, int64(1700000000), spanAttribute(span, oktaRateLimitResetKey).AsInt64())
+	}
+
+	// A call without response records the error
+	exporter.Reset()
+	server.Close()
+	_, err = newRateLimitedHTTPClient("tracing", newOktaRateLimiter()).Get(server.URL + "/api/v1/users")
+	assert.Error(t, err)
+	spans = exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "GET /api/v1/users", spans[0].Name)
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		assert.Len(t, spans[0].Events, 1)
+	}
+}
+
+func TestOktaGroupManager_TracesFailures(t *testing.T) {
+	exporter := recordSpans()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups/00g1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, `{"id": "00g1", "profile": {"name": "developers"}}`)
+	})
+	mux.HandleFunc("DELETE /api/v1/groups/00g1", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusForbidden, `{"errorCode": "E0000006", "errorSummary": "You do not have permission"}`)
+	})
+	oktaGroupCRD := &accessmanagerv1.OktaGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "developers"},
+		Status:     accessmanagerv1.OktaGroupStatus{Id: "00g1"},
+	}
+	manager, _ := NewOktaGroupManager(context.TODO(), oktaGroupCRD, newTestOktaAPI(t, mux), record.NewFakeRecorder(10))
+
+	err := manager.DeleteOktaGroup()
+	assert.Error(t, err)
+
+	// The span of a failed method is marked as failed, the ones of its successful calls aren't
+	spans := exporter.GetSpans()
+	deleteGroup := findSpan(spans, "OktaGroupManager.DeleteOktaGroup")
+	if assert.NotNil(t, deleteGroup) {
+		assert.Equal(t, codes.Error, deleteGroup.Status.Code)
+		assert.Equal(t, err.Error(), deleteGroup.Status.Description)
+		assert.Len(t, deleteGroup.Events, 1)
+	}
+	searchGroup := findSpan(spans, "OktaGroupManager.SearchOktaGroup")
+	if assert.NotNil(t, searchGroup) {
+		assert.Equal(t, codes.Unset, searchGroup.Status.Code)
+	}
+}
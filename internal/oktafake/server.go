@@ -0,0 +1,569 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oktafake is an in-memory Okta org served over HTTP, for tests that
+// exercise the operator against the Okta API without a live tenant. It covers the
+// groups, users and group membership endpoints used by the operator, follows
+// Okta's pagination, and can answer with rate limit and other errors on demand.
+package oktafake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+)
+
+// Statuses of the Okta users.
+const (
+	StatusActive        = "ACTIVE"
+	StatusStaged        = "STAGED"
+	StatusSuspended     = "SUSPENDED"
+	StatusDeprovisioned = "DEPROVISIONED"
+)
+
+// defaultLimit is the page size of the list endpoints when the request has no limit.
+const defaultLimit = 200
+
+// Server is a fake Okta org. Its zero value isn't usable, create it with NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// users and groups are kept in creation order, as Okta lists them.
+	users  []*okta.User
+	groups []*okta.Group
+	// members are the IDs of the users of every group, in the order they were added.
+	members  map[string][]string
+	failures []*failure
+	requests []string
+	lastID   int
+}
+
+// failure answers the requests that match its route with an error, a number of times.
+type failure struct {
+	route  string
+	status int
+	times  int
+}
+
+// NewServer starts a fake Okta org. It is closed with Close.
+func NewServer() *Server {
+	s := &Server{members: map[string][]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/groups", s.listGroups)
+	mux.HandleFunc("POST /api/v1/groups", s.createGroup)
+	mux.HandleFunc("GET /api/v1/groups/{groupId}", s.getGroup)
+	mux.HandleFunc("PUT /api/v1/groups/{groupId}", s.updateGroup)
+	mux.HandleFunc("DELETE /api/v1/groups/{groupId}", s.deleteGroup)
+	mux.HandleFunc("GET /api/v1/groups/{groupId}/users", s.listGroupUsers)
+	mux.HandleFunc("PUT /api/v1/groups/{groupId}/users/{userId}", s.addUserToGroup)
+	mux.HandleFunc("DELETE /api/v1/groups/{groupId}/users/{userId}", s.removeUserFromGroup)
+	mux.HandleFunc("GET /api/v1/users", s.listUsers)
+	mux.HandleFunc("POST /api/v1/users", s.createUser)
+	mux.HandleFunc("GET /api/v1/users/{userId}", s.getUser)
+	mux.HandleFunc("DELETE /api/v1/users/{userId}", s.deleteUser)
+	mux.HandleFunc("POST /api/v1/users/{userId}/lifecycle/{operation}", s.changeUserStatus)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+		failure := s.takeFailure(route)
+		s.mu.Unlock()
+		if failure != nil {
+			writeFailure(w, failure.status)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// Client returns an Okta client of the fake org. It doesn't retry the rate limited
+// requests, so that the tests see the 429s. The options are applied last, e.g. to
+// set the HTTP client of the operator.
+func (s *Server) Client(ctx context.Context, opts ...okta.ConfigSetter) (*okta.Client, error) {
+	_, oktaClient, err := okta.NewClient(ctx, append([]okta.ConfigSetter{
+		okta.WithCache(false),
+		okta.WithOrgUrl(s.URL),
+		okta.WithToken("oktafake"),
+		okta.WithTestingDisableHttpsCheck(true),
+		okta.WithRateLimitMaxRetries(0),
+	}, opts...)...)
+	return oktaClient, err
+}
+
+// AddUser adds a user with the given login, email and status to the org.
+func (s *Server) AddUser(login, email, status string) *okta.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addUser(okta.UserProfile{"login": login, "email": email}, status)
+}
+
+// SetUserStatus changes the status of a user, e.g. to suspend it.
+func (s *Server) SetUserStatus(userID, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user := s.user(userID); user != nil {
+		user.Status = status
+	}
+}
+
+// AddGroup adds a group with the given name and description to the org, with the
+// users of the given IDs as members.
+func (s *Server) AddGroup(name, description string, userIDs ...string) *okta.Group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group := s.addGroup(okta.GroupProfile{Name: name, Description: description})
+	s.members[group.Id] = slices.Clone(userIDs)
+	return group
+}
+
+// Group returns a copy of the group with the given ID, or nil if there is none.
+func (s *Server) Group(groupID string) *okta.Group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group := s.group(groupID); group != nil {
+		clone := *group
+		profile := *group.Profile
+		clone.Profile = &profile
+		return &clone
+	}
+	return nil
+}
+
+// GroupUsers returns the IDs of the users of a group, in the order they were added.
+func (s *Server) GroupUsers(groupID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.members[groupID])
+}
+
+// Fail answers the next requests of a route with an error of the given status.
+// The route is a pattern of the fake, e.g. "PUT /api/v1/groups/{groupId}/users/{userId}".
+// A status of 429 answers like Okta does when the rate limit is exhausted.
+func (s *Server) Fail(route string, status, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure{route: route, status: status, times: times})
+}
+
+// Requests returns the method and URI of every request received so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func (s *Server) takeFailure(route string) *failure {
+	for i, failure := range s.failures {
+		if failure.route == route {
+			if failure.times--; failure.times <= 0 {
+				s.failures = slices.Delete(s.failures, i, i+1)
+			}
+			return failure
+		}
+	}
+	return nil
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.lastID++
+	return fmt.Sprintf("%s%017d", prefix, s.lastID)
+}
+
+func (s *Server) addUser(profile okta.UserProfile, status string) *okta.User {
+	now := timestamp()
+	user := &okta.User{
+		Id:          s.nextID("00u"),
+		Status:      status,
+		Created:     &now,
+		LastUpdated: &now,
+		Profile:     &profile,
+	}
+	s.users = append(s.users, user)
+	return user
+}
+
+func (s *Server) addGroup(profile okta.GroupProfile) *okta.Group {
+	now := timestamp()
+	group := &okta.Group{
+		Id:                    s.nextID("00g"),
+		Type:                  "OKTA_GROUP",
+		ObjectClass:           []string{"okta:user_group"},
+		Created:               &now,
+		LastUpdated:           &now,
+		LastMembershipUpdated: &now,
+		Profile:               &profile,
+	}
+	s.groups = append(s.groups, group)
+	return group
+}
+
+func (s *Server) user(userID string) *okta.User {
+	for _, user := range s.users {
+		if user.Id == userID {
+			return user
+		}
+	}
+	return nil
+}
+
+func (s *Server) group(groupID string) *okta.Group {
+	for _, group := range s.groups {
+		if group.Id == groupID {
+			return group
+		}
+	}
+	return nil
+}
+
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := strings.ToLower(r.URL.Query().Get("q"))
+	var groups []*okta.Group
+	for _, group := range s.groups {
+		if strings.HasPrefix(strings.ToLower(group.Profile.Name), q) {
+			groups = append(groups, group)
+		}
+	}
+	writePage(w, r, groups)
+}
+
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request) {
+	var body okta.Group
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Profile == nil || body.Profile.Name == "" {
+		writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: name")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, group := range s.groups {
+		if strings.EqualFold(group.Profile.Name, body.Profile.Name) {
+			writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: name: An object with this field already exists in the current organization")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, s.addGroup(okta.GroupProfile{Name: body.Profile.Name, Description: body.Profile.Description}))
+}
+
+func (s *Server) getGroup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group := s.group(r.PathValue("groupId"))
+	if group == nil {
+		writeNotFound(w, r.PathValue("groupId"), "UserGroup")
+		return
+	}
+	writeJSON(w, http.StatusOK, group)
+}
+
+func (s *Server) updateGroup(w http.ResponseWriter, r *http.Request) {
+	var body okta.Group
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Profile == nil || body.Profile.Name == "" {
+		writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: name")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group := s.group(r.PathValue("groupId"))
+	if group == nil {
+		writeNotFound(w, r.PathValue("groupId"), "UserGroup")
+		return
+	}
+	now := timestamp()
+	group.Profile = &okta.GroupProfile{Name: body.Profile.Name, Description: body.Profile.Description}
+	group.LastUpdated = &now
+	writeJSON(w, http.StatusOK, group)
+}
+
+func (s *Server) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groupID := r.PathValue("groupId")
+	if s.group(groupID) == nil {
+		writeNotFound(w, groupID, "UserGroup")
+		return
+	}
+	s.groups = slices.DeleteFunc(s.groups, func(group *okta.Group) bool { return group.Id == groupID })
+	delete(s.members, groupID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listGroupUsers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groupID := r.PathValue("groupId")
+	if s.group(groupID) == nil {
+		writeNotFound(w, groupID, "UserGroup")
+		return
+	}
+	var users []*okta.User
+	for _, userID := range s.members[groupID] {
+		if user := s.user(userID); user != nil {
+			users = append(users, user)
+		}
+	}
+	writePage(w, r, users)
+}
+
+func (s *Server) addUserToGroup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, userID := s.group(r.PathValue("groupId")), r.PathValue("userId")
+	switch {
+	case group == nil:
+		writeNotFound(w, r.PathValue("groupId"), "UserGroup")
+		return
+	case s.user(userID) == nil:
+		writeNotFound(w, userID, "User")
+		return
+	}
+	if !slices.Contains(s.members[group.Id], userID) {
+		now := timestamp()
+		s.members[group.Id] = append(s.members[group.Id], userID)
+		group.LastMembershipUpdated = &now
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) removeUserFromGroup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, userID := s.group(r.PathValue("groupId")), r.PathValue("userId")
+	if group == nil {
+		writeNotFound(w, r.PathValue("groupId"), "UserGroup")
+		return
+	}
+	if i := slices.Index(s.members[group.Id], userID); i >= 0 {
+		now := timestamp()
+		s.members[group.Id] = slices.Delete(s.members[group.Id], i, i+1)
+		group.LastMembershipUpdated = &now
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("filter")
+	match, err := parseFilter(filter)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "E0000031", fmt.Sprintf("Invalid search criteria: %v", err))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Like Okta, the deprovisioned users are only listed when filtering.
+	var users []*okta.User
+	for _, user := range s.users {
+		if (filter != "" || user.Status != StatusDeprovisioned) && match(user) {
+			users = append(users, user)
+		}
+	}
+	writePage(w, r, users)
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var body okta.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Profile == nil {
+		writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: profile")
+		return
+	}
+	status := StatusActive
+	if activate, err := strconv.ParseBool(r.URL.Query().Get("activate")); err == nil && !activate {
+		status = StatusStaged
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	login, _ := (*body.Profile)["login"].(string)
+	for _, user := range s.users {
+		if other, _ := (*user.Profile)["login"].(string); strings.EqualFold(other, login) {
+			writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: login: An object with this field already exists in the current organization")
+			return
+		}
+	}
+	profile := okta.UserProfile{}
+	for key, value := range *body.Profile {
+		profile[key] = value
+	}
+	writeJSON(w, http.StatusOK, s.addUser(profile, status))
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := s.user(r.PathValue("userId"))
+	if user == nil {
+		writeNotFound(w, r.PathValue("userId"), "User")
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// deleteUser deactivates an active user, and deletes a deactivated one, like Okta.
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userID := r.PathValue("userId")
+	user := s.user(userID)
+	if user == nil {
+		writeNotFound(w, userID, "User")
+		return
+	}
+	if user.Status != StatusDeprovisioned {
+		user.Status = StatusDeprovisioned
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.users = slices.DeleteFunc(s.users, func(user *okta.User) bool { return user.Id == userID })
+	for groupID, userIDs := range s.members {
+		s.members[groupID] = slices.DeleteFunc(userIDs, func(id string) bool { return id == userID })
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) changeUserStatus(w http.ResponseWriter, r *http.Request) {
+	status, ok := map[string]string{
+		"activate":   StatusActive,
+		"deactivate": StatusDeprovisioned,
+		"suspend":    StatusSuspended,
+		"unsuspend":  StatusActive,
+	}[r.PathValue("operation")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "E0000022", "The endpoint does not support the provided HTTP method")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user := s.user(r.PathValue("userId"))
+	if user == nil {
+		writeNotFound(w, r.PathValue("userId"), "User")
+		return
+	}
+	user.Status = status
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+// timestamp is the time of the changes to the org, in whole seconds so that the
+// timestamps are the same after a round trip through a metav1.Time.
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// parseFilter parses the filters of ListUsers supported by the fake: comparisons
+// of the id, status, profile.login and profile.email of the users joined by "or".
+func parseFilter(filter string) (func(*okta.User) bool, error) {
+	if filter == "" {
+		return func(*okta.User) bool { return true }, nil
+	}
+	type comparison struct{ attribute, value string }
+	var comparisons []comparison
+	for _, expression := range strings.Split(filter, " or ") {
+		attribute, quoted, ok := strings.Cut(strings.TrimSpace(expression), " eq ")
+		value, err := strconv.Unquote(quoted)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("unsupported expression %q", expression)
+		}
+		switch attribute {
+		case "id", "status", "profile.login", "profile.email":
+		default:
+			return nil, fmt.Errorf("unsupported attribute %q", attribute)
+		}
+		comparisons = append(comparisons, comparison{attribute, value})
+	}
+	return func(user *okta.User) bool {
+		for _, c := range comparisons {
+			switch c.attribute {
+			case "id":
+				if user.Id == c.value {
+					return true
+				}
+			case "status":
+				if user.Status == c.value {
+					return true
+				}
+			default:
+				value, _ := (*user.Profile)[strings.TrimPrefix(c.attribute, "profile.")].(string)
+				if strings.EqualFold(value, c.value) {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// writePage writes the page of items selected by the limit and after parameters
+// of the request, with the Link header of the next page if there is one. The
+// cursor is the index of the first item of the page.
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultLimit
+	}
+	after, _ := strconv.Atoi(query.Get("after"))
+	after = min(max(after, 0), len(items))
+	end := min(after+limit, len(items))
+
+	if end < len(items) {
+		next := url.Values{"after": {strconv.Itoa(end)}, "limit": {strconv.Itoa(limit)}}
+		w.Header().Add("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+	page := items[after:end]
+	if page == nil {
+		page = []T{}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, summary string) {
+	writeJSON(w, status, okta.Error{ErrorCode: code, ErrorSummary: summary, ErrorId: "oktafake"})
+}
+
+func writeNotFound(w http.ResponseWriter, id, kind string) {
+	writeError(w, http.StatusNotFound, "E0000007", fmt.Sprintf("Not found: Resource not found: %s (%s)", id, kind))
+}
+
+// writeFailure writes an injected error. The rate limit errors carry the headers
+// Okta sends, with a reset one second later.
+func writeFailure(w http.ResponseWriter, status int) {
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("X-Rate-Limit-Limit", "600")
+		w.Header().Set("X-Rate-Limit-Remaining", "0")
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10))
+		writeError(w, status, "E0000047", "API call exceeded rate limit due to too many requests.")
+		return
+	}
+	writeError(w, status, "E0000009", http.StatusText(status))
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oktafake
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) (*Server, *okta.Client) {
+	server := NewServer()
+	t.Cleanup(server.Close)
+	oktaClient, err := server.Client(context.TODO())
+	assert.NoError(t, err)
+	return server, oktaClient
+}
+
+func TestServer_Groups(t *testing.T) {
+	ctx := context.TODO()
+	server, oktaClient := newTestServer(t)
+
+	group, _, err := oktaClient.Group.CreateGroup(ctx, okta.Group{Profile: &okta.GroupProfile{Name: "developers", Description: "Developers"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "developers", server.Group(group.Id).Profile.Name)
+
+	_, resp, err := oktaClient.Group.CreateGroup(ctx, okta.Group{Profile: &okta.GroupProfile{Name: "Developers"}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	server.AddGroup("admins", "")
+	groups, _, err := oktaClient.Group.ListGroups(ctx, &query.Params{Q: "dev"})
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+
+	_, err = oktaClient.Group.DeleteGroup(ctx, group.Id)
+	assert.NoError(t, err)
+	_, resp, err = oktaClient.Group.GetGroup(ctx, group.Id)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServer_Membership(t *testing.T) {
+	ctx := context.TODO()
+	server, oktaClient := newTestServer(t)
+
+	john := server.AddUser("john", "john@example.com", StatusActive)
+	jane := server.AddUser("jane", "jane@example.com", StatusActive)
+	group := server.AddGroup("developers", "", john.Id)
+
+	_, err := oktaClient.Group.AddUserToGroup(ctx, group.Id, jane.Id)
+	assert.NoError(t, err)
+	_, err = oktaClient.Group.RemoveUserFromGroup(ctx, group.Id, john.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{jane.Id}, server.GroupUsers(group.Id))
+
+	resp, err := oktaClient.Group.AddUserToGroup(ctx, group.Id, "00uunknown")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServer_Pagination(t *testing.T) {
+	ctx := context.TODO()
+	server, oktaClient := newTestServer(t)
+
+	var userIDs []string
+	for _, login := range []string{"a", "b", "c", "d", "e"} {
+		userIDs = append(userIDs, server.AddUser(login, login+"@example.com", StatusActive).Id)
+	}
+	group := server.AddGroup("developers", "", userIDs...)
+
+	users, resp, err := oktaClient.Group.ListGroupUsers(ctx, group.Id, &query.Params{Limit: 2})
+	assert.NoError(t, err)
+	var pages []int
+	for {
+		pages = append(pages, len(users))
+		if !resp.HasNextPage() {
+			break
+		}
+		users = nil
+		resp, err = resp.Next(ctx, &users)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []int{2, 2, 1}, pages)
+}
+
+func TestServer_UserFilters(t *testing.T) {
+	ctx := context.TODO()
+	server, oktaClient := newTestServer(t)
+
+	john := server.AddUser("svc-ci", "robots@example.com", StatusActive)
+	jane := server.AddUser("jane", "jane@example.com", StatusDeprovisioned)
+	server.AddUser("adam", "adam@example.com", StatusActive)
+
+	users, _, err := oktaClient.User.ListUsers(ctx, &query.Params{Filter: `profile.login eq "SVC-CI" or id eq "` + jane.Id + `"`})
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+	assert.Equal(t, john.Id, users[0].Id)
+
+	// The deprovisioned users are only listed when filtering
+	users, _, err = oktaClient.User.ListUsers(ctx, nil)
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+
+	_, resp, err := oktaClient.User.ListUsers(ctx, &query.Params{Filter: `profile.firstName sw "J"`})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestServer_Failures(t *testing.T) {
+	ctx := context.TODO()
+	server, oktaClient := newTestServer(t)
+	group := server.AddGroup("developers", "")
+
+	server.Fail("GET /api/v1/groups/{groupId}", http.StatusTooManyRequests, 1)
+	server.Fail("GET /api/v1/groups/{groupId}/users", http.StatusInternalServerError, 2)
+
+	// The client doesn't retry, so the rate limited request fails
+	_, _, err := oktaClient.Group.GetGroup(ctx, group.Id)
+	assert.ErrorContains(t, err, "too many requests")
+	_, _, err = oktaClient.Group.GetGroup(ctx, group.Id)
+	assert.NoError(t, err)
+
+	for range 2 {
+		_, resp, err := oktaClient.Group.ListGroupUsers(ctx, group.Id, nil)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	}
+	_, _, err = oktaClient.Group.ListGroupUsers(ctx, group.Id, nil)
+	assert.NoError(t, err)
+	assert.Len(t, server.Requests(), 5)
+}